@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/crawler"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"io"
+	"text/tabwriter"
+)
+
+// crawlResult is a single crawled site as printed by the JSON and NDJSON formats.
+type crawlResult struct {
+	URL    string              `json:"url"`
+	Report *crawler.SiteReport `json:"report,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+func runCrawl(ctx context.Context, s *scanner.Scanner, urls []string, opts crawler.Options, format string, stdout, stderr io.Writer) int {
+	c, err := crawler.New(s, opts)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+
+		return exitError
+	}
+
+	code := exitOK
+	results := make([]crawlResult, 0, len(urls))
+
+	for _, rawURL := range urls {
+		res := crawlResult{URL: rawURL}
+
+		u, err := parseURL(rawURL)
+		if err == nil {
+			var report crawler.SiteReport
+
+			report, err = c.Crawl(ctx, u, nil)
+			res.Report = &report
+		}
+
+		switch {
+		case err != nil:
+			res.Report = nil
+			res.Error = err.Error()
+			code = exitError
+		case len(res.Report.BrokenLinks) > 0 && code == exitOK:
+			code = exitBrokenLinks
+		}
+
+		switch format {
+		case "table":
+			printCrawlTable(stdout, res)
+		case "ndjson":
+			_ = json.NewEncoder(stdout).Encode(res)
+		}
+
+		results = append(results, res)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(results)
+	}
+
+	return code
+}
+
+func printCrawlTable(w io.Writer, res crawlResult) {
+	_, _ = fmt.Fprintf(w, "%s\n", res.URL)
+
+	if res.Error != "" {
+		_, _ = fmt.Fprintf(w, "  Error: %s\n\n", res.Error)
+
+		return
+	}
+
+	report := res.Report
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "  DEPTH\tLINKS\tBROKEN\tPAGE\tTITLE")
+
+	for _, p := range report.Pages {
+		if p.Error != "" {
+			_, _ = fmt.Fprintf(tw, "  %d\t-\t-\t%s\tError: %s\n", p.Depth, p.URL, p.Error)
+
+			continue
+		}
+
+		if p.Page == nil {
+			_, _ = fmt.Fprintf(tw, "  %d\t-\t-\t%s\tNot HTML: %s\n", p.Depth, p.URL, p.ContentType)
+
+			continue
+		}
+
+		_, _ = fmt.Fprintf(tw, "  %d\t%d\t%d\t%s\t%q\n", p.Depth, len(p.Page.HyperLinks), len(p.Page.BrokenLinks()), p.URL, p.Page.Title)
+	}
+
+	_ = tw.Flush()
+
+	if len(report.BrokenLinks) > 0 {
+		_, _ = fmt.Fprintln(w, "\n  Broken links:")
+
+		for _, b := range report.BrokenLinks {
+			status := "N/A"
+			if b.StatusCode > 0 {
+				status = fmt.Sprint(b.StatusCode)
+			}
+
+			_, _ = fmt.Fprintf(w, "    %s %s\n", status, b.URL)
+
+			for _, ref := range b.ReferencedBy {
+				_, _ = fmt.Fprintf(w, "      linked from %s\n", ref)
+			}
+		}
+	}
+
+	if len(report.Orphans) > 0 {
+		_, _ = fmt.Fprintln(w, "\n  Pages no other page links to:")
+
+		for _, o := range report.Orphans {
+			_, _ = fmt.Fprintf(w, "    %s\n", o)
+		}
+	}
+
+	_, _ = fmt.Fprintf(w, "\n  %d page(s), %d broken link(s)\n\n", len(report.Pages), len(report.BrokenLinks))
+}
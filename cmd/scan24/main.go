@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/crawler"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"github.com/hugmouse/scan24/internal/robots"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"golang.org/x/time/rate"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes of the scan24 command.
+const (
+	exitOK          = 0
+	exitBrokenLinks = 1
+	exitError       = 2
+)
+
+// result is a single scanned URL as printed by the JSON and NDJSON formats.
+type result struct {
+	URL         string            `json:"url"`
+	Page        *scanner.PageData `json:"page,omitempty"`
+	BrokenLinks int               `json:"broken_links"`
+	Error       string            `json:"error,omitempty"`
+}
+
+func main() {
+	// Ctrl+C aborts the scan that is in progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("scan24", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(stderr, "Usage: scan24 [flags] URL [URL...]")
+		flags.PrintDefaults()
+	}
+
+	format := flags.String("format", "table", "output format: table, json or ndjson")
+	timeout := flags.Int("timeout", 5, "HTTP client timeout in seconds")
+	rateLimit := flags.Int("rate", 2, "requests per second allowed for every domain")
+	rateGroup := flags.String("rate-group", string(ratelimiter.GroupByHost), "rate limit every host or every site (eTLD+1)")
+	maxRedirects := flags.Int("max-redirects", 3, "maximum number of redirects to follow")
+	retries := flags.Int("retries", 2, "how often a link answering 429 or 503 is checked again")
+	maxBackoff := flags.Int("max-backoff", 60, "maximum seconds to back off from a throttling host")
+	concurrency := flags.Int("concurrency", scanner.DefaultMaxConcurrency, "maximum number of links checked at the same time")
+	verbose := flags.Bool("v", false, "log every checked link to stderr")
+	skipSPAFragments := flags.Bool("skip-spa-fragments", false, "don't check anchors of client-side routes like #!/page or #/page")
+	robotsMode := flags.String("robots", string(robots.ModeReport), "robots.txt: report disallowed links, obey it or ignore it")
+	crawl := flags.Bool("crawl", false, "follow internal links and analyze every page of the site")
+	depth := flags.Int("depth", crawler.DefaultOptions.MaxDepth, "crawl: maximum link depth from the start page")
+	maxPages := flags.Int("max-pages", crawler.DefaultOptions.MaxPages, "crawl: maximum number of pages to analyze")
+	scope := flags.String("scope", string(crawler.DefaultOptions.Scope), "crawl: same-host or same-site")
+	sitemap := flags.Bool("sitemap", false, "crawl: also crawl pages listed in /sitemap.xml")
+
+	var include, exclude, rateOverrides stringList
+
+	flags.Var(&rateOverrides, "rate-override", "per-domain rate limit like example.com=10 or example.com=10:5 (repeatable)")
+	flags.Var(&include, "include", "crawl: only follow paths matching this regular expression (repeatable)")
+	flags.Var(&exclude, "exclude", "crawl: never follow paths matching this regular expression (repeatable)")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return exitError
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+
+		return exitError
+	}
+
+	if *format != "table" && *format != "json" && *format != "ndjson" {
+		_, _ = fmt.Fprintf(stderr, "unknown format %q\n", *format)
+
+		return exitError
+	}
+
+	mode, err := robots.ParseMode(*robotsMode)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+
+		return exitError
+	}
+
+	grouping, err := ratelimiter.ParseGrouping(*rateGroup)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+
+		return exitError
+	}
+
+	overrides, err := ratelimiter.ParseOverrides(rateOverrides)
+	if err != nil {
+		_, _ = fmt.Fprintln(stderr, err)
+
+		return exitError
+	}
+
+	log.SetOutput(stderr)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	client := &http.Client{
+		Timeout: time.Duration(*timeout) * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= *maxRedirects {
+				return http.ErrUseLastResponse
+			}
+
+			return nil
+		},
+	}
+
+	limiter := ratelimiter.NewDomainRateLimiter(rate.Limit(*rateLimit), 1)
+	limiter.MaxBackoff = time.Duration(*maxBackoff) * time.Second
+	limiter.GroupBy = grouping
+	limiter.Overrides = overrides
+
+	s := &scanner.Scanner{
+		Client:           client,
+		RateLimiter:      limiter,
+		MaxConcurrency:   *concurrency,
+		SkipSPAFragments: *skipSPAFragments,
+		MaxRetries:       *retries,
+		Robots:           robots.NewFetcher(client, parser.UserAgent, time.Hour),
+		RobotsMode:       mode,
+	}
+
+	if *crawl {
+		return runCrawl(ctx, s, flags.Args(), crawler.Options{
+			MaxDepth: *depth,
+			MaxPages: *maxPages,
+			Include:  include,
+			Exclude:  exclude,
+			Scope:    crawler.Scope(*scope),
+			Sitemap:  *sitemap,
+		}, *format, stdout, stderr)
+	}
+
+	code := exitOK
+	results := make([]result, 0, flags.NArg())
+
+	for _, rawURL := range flags.Args() {
+		res := scan(ctx, s, rawURL)
+
+		switch {
+		case res.Error != "":
+			code = exitError
+		case res.BrokenLinks > 0 && code == exitOK:
+			code = exitBrokenLinks
+		}
+
+		switch *format {
+		case "table":
+			printTable(stdout, res)
+		case "ndjson":
+			_ = json.NewEncoder(stdout).Encode(res)
+		}
+
+		results = append(results, res)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(results)
+	}
+
+	return code
+}
+
+// stringList is a flag that can be passed multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+
+	return nil
+}
+
+func parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid URL provided: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("Only HTTP/HTTPS links are allowed. For example: https://mysh.dev")
+	}
+
+	return u, nil
+}
+
+func scan(ctx context.Context, s *scanner.Scanner, rawURL string) result {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return result{URL: rawURL, Error: err.Error()}
+	}
+
+	page, err := s.Scan(ctx, u, nil)
+	if err != nil {
+		return result{URL: rawURL, Error: err.Error()}
+	}
+
+	return result{URL: rawURL, Page: &page, BrokenLinks: len(page.BrokenLinks())}
+}
+
+func printTable(w io.Writer, res result) {
+	_, _ = fmt.Fprintf(w, "%s\n", res.URL)
+
+	if res.Error != "" {
+		_, _ = fmt.Fprintf(w, "  Error: %s\n\n", res.Error)
+
+		return
+	}
+
+	page := res.Page
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	if page.FinalURL != page.URL {
+		_, _ = fmt.Fprintf(tw, "  Final URL:\t%s\n", page.FinalURL)
+	}
+
+	if page.BaseURL != page.FinalURL {
+		_, _ = fmt.Fprintf(tw, "  Base URL:\t%s\n", page.BaseURL)
+	}
+
+	if page.Robots != "" {
+		_, _ = fmt.Fprintf(tw, "  robots.txt:\t%s\n", page.Robots)
+	}
+
+	_, _ = fmt.Fprintf(tw, "  Title:\t%q\n", page.Title)
+	_, _ = fmt.Fprintf(tw, "  Load time:\t%s (first byte after %s)\n", page.Timing.Total.Round(time.Millisecond), page.Timing.TTFB.Round(time.Millisecond))
+	_, _ = fmt.Fprintf(tw, "  HTML Version:\t%s\n", page.HTMLVersion)
+	_, _ = fmt.Fprintf(tw, "  Login form found:\t%s\n", yesNo(page.HasLoginForm))
+
+	tags := make([]string, 0, len(page.Headings))
+	for tag := range page.Headings {
+		tags = append(tags, tag)
+	}
+
+	sort.Strings(tags)
+
+	for _, tag := range tags {
+		if page.Headings[tag] > 0 {
+			_, _ = fmt.Fprintf(tw, "  %s:\t%d\n", tag, page.Headings[tag])
+		}
+	}
+
+	counters := page.LinkCounters
+	_, _ = fmt.Fprintf(tw, "  Same-origin links:\t%d (%d accessible)\n", counters.SameOrigin, counters.SameOriginAlive)
+	_, _ = fmt.Fprintf(tw, "  Subdomain links:\t%d (%d accessible)\n", counters.Subdomain, counters.SubdomainAlive)
+	_, _ = fmt.Fprintf(tw, "  Same-site links:\t%d (%d accessible)\n", counters.SameSite, counters.SameSiteAlive)
+	_, _ = fmt.Fprintf(tw, "  External links:\t%d (%d accessible)\n", counters.External, counters.ExternalAlive)
+	_, _ = fmt.Fprintf(tw, "  Protocol links:\t%d\n", counters.Protocol)
+
+	schemes := make([]string, 0, len(counters.Protocols))
+	for scheme := range counters.Protocols {
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+
+	for _, scheme := range schemes {
+		_, _ = fmt.Fprintf(tw, "    %s:\t%d\n", scheme, counters.Protocols[scheme])
+	}
+	_ = tw.Flush()
+
+	_, _ = fmt.Fprintln(w)
+
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "  STATUS\tTYPE\tSOURCE\tLINK\tERROR")
+
+	for _, link := range page.HyperLinks {
+		status := "N/A"
+
+		switch {
+		case link.StatusCode > 0:
+			status = fmt.Sprint(link.StatusCode)
+		case link.Failure != "":
+			status = string(link.Failure)
+		}
+
+		errMsg := ""
+		if link.Err != nil {
+			errMsg = link.Err.Error()
+		}
+
+		if n := len(link.Occurrences); n > 1 {
+			errMsg = strings.TrimSpace(errMsg + fmt.Sprintf(" [linked %d times]", n))
+		}
+
+		if link.Robots == robots.StatusDisallowed && link.Failure != parser.FailureRobotsDisallowed {
+			errMsg = strings.TrimSpace(errMsg + " [disallowed by robots.txt]")
+		}
+
+		if link.Retries > 0 {
+			errMsg = strings.TrimSpace(errMsg + fmt.Sprintf(" [retried %d times]", link.Retries))
+		}
+
+		for _, issue := range link.RedirectIssues {
+			errMsg = strings.TrimSpace(errMsg + " [" + issue.Label() + "]")
+		}
+
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", status, link.HrefType, link.Element+"["+link.Attr+"]", link.Raw, errMsg)
+	}
+
+	_ = tw.Flush()
+
+	if len(page.SlowestLinks) > 0 {
+		_, _ = fmt.Fprintln(w, "\n  Slowest links:")
+
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, link := range page.SlowestLinks {
+			_, _ = fmt.Fprintf(tw, "  %s\t%s\n", link.Timing.Total.Round(time.Millisecond), link.Raw)
+		}
+
+		_ = tw.Flush()
+	}
+
+	if len(page.Throttling) > 0 {
+		_, _ = fmt.Fprintln(w, "\n  Throttling:")
+
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, event := range page.Throttling {
+			_, _ = fmt.Fprintf(tw, "  %d\t%s\tbacked off %s\t%s\n", event.StatusCode, event.Host, event.Backoff, event.URL)
+		}
+
+		_ = tw.Flush()
+	}
+
+	_, _ = fmt.Fprintf(w, "\n  %d broken link(s)\n\n", res.BrokenLinks)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "Yes"
+	}
+
+	return "No"
+}
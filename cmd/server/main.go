@@ -2,14 +2,24 @@ package main
 
 import (
 	"github.com/caarlos0/env/v11"
-	"github.com/hugmouse/scan24/internal/cache"
+	"github.com/hugmouse/scan24/internal/batch"
 	"github.com/hugmouse/scan24/internal/handler"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/linkcache"
+	"github.com/hugmouse/scan24/internal/monitor"
+	"github.com/hugmouse/scan24/internal/netguard"
+	"github.com/hugmouse/scan24/internal/parser"
 	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"github.com/hugmouse/scan24/internal/robots"
+	"github.com/hugmouse/scan24/internal/storage"
+	"github.com/hugmouse/scan24/internal/webhook"
+	"github.com/hugmouse/scan24/internal/workerpool"
 	"github.com/hugmouse/scan24/static"
 	"golang.org/x/time/rate"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -25,8 +35,45 @@ type config struct {
 	MaxIdleConns                int    `env:"MAX_IDLE_CONNS"                  envDefault:"100"`
 	IdleConnTimeout             int    `env:"IDLE_CONN_TIMEOUT"               envDefault:"90"`
 	MaxRedirects                int    `env:"MAX_REDIRECTS"                   envDefault:"3"`
+	LongRedirectChain           int    `env:"LONG_REDIRECT_CHAIN"             envDefault:"2"`
 	RateLimit                   int    `env:"RATE_LIMIT"                      envDefault:"2"`
+	RateLimitGroup              string `env:"RATE_LIMIT_GROUP"                envDefault:"host"`
+	RateLimiterIdleTTL          int    `env:"RATE_LIMITER_IDLE_TTL"           envDefault:"600"`
+	ThrottleRetries             int    `env:"THROTTLE_RETRIES"                envDefault:"2"`
+	ThrottleMaxBackoff          int    `env:"THROTTLE_MAX_BACKOFF"            envDefault:"60"`
 	CacheTTL                    int    `env:"CACHE_TTL"                       envDefault:"60"`
+	LinkCacheTTL                int    `env:"LINK_CACHE_TTL"                  envDefault:"300"`
+	LinkCacheFailureTTL         int    `env:"LINK_CACHE_FAILURE_TTL"          envDefault:"30"`
+	LinkCacheMaxEntries         int    `env:"LINK_CACHE_MAX_ENTRIES"          envDefault:"50000"`
+	SkipSPAFragments            bool   `env:"SKIP_SPA_FRAGMENTS"              envDefault:"false"`
+	Robots                      string `env:"ROBOTS"                          envDefault:"report"`
+	RobotsCacheTTL              int    `env:"ROBOTS_CACHE_TTL"                envDefault:"3600"`
+	Workers                     int    `env:"WORKERS"                         envDefault:"64"`
+	JobConcurrency              int    `env:"JOB_CONCURRENCY"                 envDefault:"16"`
+	MaxConcurrentJobs           int    `env:"MAX_CONCURRENT_JOBS"             envDefault:"4"`
+	HistoryDir                  string `env:"HISTORY_DIR"`
+	HistoryMaxAge               int    `env:"HISTORY_MAX_AGE"                 envDefault:"30"`
+	HistoryMaxPerURL            int    `env:"HISTORY_MAX_PER_URL"             envDefault:"50"`
+	MonitorsFile                string `env:"MONITORS_FILE"`
+	MonitorMinInterval          int    `env:"MONITOR_MIN_INTERVAL"            envDefault:"300"`
+	PublicURL                   string `env:"PUBLIC_URL"`
+	WebhookSecret               string `env:"WEBHOOK_SECRET"`
+	WebhookTimeout              int    `env:"WEBHOOK_TIMEOUT"                 envDefault:"10"`
+	WebhookMaxAttempts          int    `env:"WEBHOOK_MAX_ATTEMPTS"            envDefault:"5"`
+	WebhookMaxBackoff           int    `env:"WEBHOOK_MAX_BACKOFF"             envDefault:"300"`
+	BatchMaxURLs                int    `env:"BATCH_MAX_URLS"                  envDefault:"500"`
+	BatchRetention              int    `env:"BATCH_RETENTION"                 envDefault:"86400"`
+
+	// Limits of single domains like "example.com=10:5", in requests per second with an optional burst
+	RateLimitOverrides []string `env:"RATE_LIMIT_OVERRIDES" envSeparator:","`
+
+	// Networks and hosts that are reachable despite the SSRF protection, and ones that never are
+	SSRFAllow []string `env:"SSRF_ALLOW" envSeparator:","`
+	SSRFDeny  []string `env:"SSRF_DENY"  envSeparator:","`
+
+	// URLs that receive webhooks, and the events they get (all of them if empty)
+	WebhookURLs   []string `env:"WEBHOOK_URLS"   envSeparator:","`
+	WebhookEvents []string `env:"WEBHOOK_EVENTS" envSeparator:","`
 }
 
 func main() {
@@ -35,11 +82,54 @@ func main() {
 		log.Fatal(err)
 	}
 
+	robotsMode, err := robots.ParseMode(cfg.Robots)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rateLimitGroup, err := ratelimiter.ParseGrouping(cfg.RateLimitGroup)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rateLimitOverrides, err := ratelimiter.ParseOverrides(cfg.RateLimitOverrides)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	guard, err := netguard.New(cfg.SSRFAllow, cfg.SSRFDeny)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, u := range cfg.WebhookURLs {
+		parsed, err := url.ParseRequestURI(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			log.Fatalf("Invalid webhook URL %q", u)
+		}
+	}
+
+	if cfg.WebhookTimeout < 1 || cfg.WebhookMaxAttempts < 1 || cfg.WebhookMaxBackoff < 1 {
+		log.Fatal("WEBHOOK_TIMEOUT, WEBHOOK_MAX_ATTEMPTS and WEBHOOK_MAX_BACKOFF must be at least 1")
+	}
+
+	webhookEvents := make([]webhook.Event, 0, len(cfg.WebhookEvents))
+
+	for _, e := range cfg.WebhookEvents {
+		event, err := webhook.ParseEvent(e)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		webhookEvents = append(webhookEvents, event)
+	}
+
 	transport := &http.Transport{
-		DialContext: (&net.Dialer{
+		// Every connection, including the ones made for redirects, is checked after DNS resolution
+		DialContext: guard.DialContext(&net.Dialer{
 			Timeout:   time.Duration(cfg.DialTimeout) * time.Second,
 			KeepAlive: time.Duration(cfg.DialKeepAlive) * time.Second,
-		}).DialContext,
+		}),
 		TLSHandshakeTimeout:   time.Duration(cfg.TLSHandshakeTimeout) * time.Second,
 		ResponseHeaderTimeout: time.Duration(cfg.ResponseHeaderTimeout) * time.Second,
 		ExpectContinueTimeout: time.Duration(cfg.ExpectContinueTimeout) * time.Second,
@@ -61,21 +151,131 @@ func main() {
 		Jar: nil,
 	}
 
-	jobCache := cache.New[string, handler.GlobalMapData](time.Duration(cfg.CacheTTL) * time.Second)
+	// Webhooks connect through the same SSRF protection as scans, New makes sure they don't follow redirects
+	webhooks := webhook.New(&http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(cfg.WebhookTimeout) * time.Second,
+	})
+	webhooks.URLs = cfg.WebhookURLs
+	webhooks.Events = webhookEvents
+	webhooks.Secret = cfg.WebhookSecret
+	webhooks.MaxAttempts = cfg.WebhookMaxAttempts
+	webhooks.MaxBackoff = time.Duration(cfg.WebhookMaxBackoff) * time.Second
+
+	jobManager := jobs.NewManager(time.Duration(cfg.CacheTTL)*time.Second, cfg.MaxConcurrentJobs)
+	pool := workerpool.New(cfg.Workers)
 	limiter := ratelimiter.NewDomainRateLimiter(rate.Limit(cfg.RateLimit), 1)
+	limiter.MaxBackoff = time.Duration(cfg.ThrottleMaxBackoff) * time.Second
+	limiter.GroupBy = rateLimitGroup
+	limiter.Overrides = rateLimitOverrides
+	limiter.IdleTTL = time.Duration(cfg.RateLimiterIdleTTL) * time.Second
+
+	// Link check results are shared between scans, separately from the jobs themselves
+	var linkCache *linkcache.Cache
+	if cfg.LinkCacheTTL > 0 || cfg.LinkCacheFailureTTL > 0 {
+		linkCache = linkcache.New(time.Duration(cfg.LinkCacheTTL)*time.Second, time.Duration(cfg.LinkCacheFailureTTL)*time.Second, cfg.LinkCacheMaxEntries)
+	}
 
 	h := &handler.Handler{
-		Client:      client,
-		RateLimit:   cfg.RateLimit,
-		Cache:       jobCache,
-		RateLimiter: limiter,
+		Client:            client,
+		RateLimit:         cfg.RateLimit,
+		Jobs:              jobManager,
+		RateLimiter:       limiter,
+		Pool:              pool,
+		JobConcurrency:    cfg.JobConcurrency,
+		LongRedirectChain: cfg.LongRedirectChain,
+		LinkCache:         linkCache,
+		SkipSPAFragments:  cfg.SkipSPAFragments,
+		MaxRetries:        cfg.ThrottleRetries,
+		Robots:            robots.NewFetcher(client, parser.UserAgent, time.Duration(cfg.RobotsCacheTTL)*time.Second),
+		RobotsMode:        robotsMode,
+		Webhooks:          webhooks,
+		PublicURL:         cfg.PublicURL,
+		Batches:           batch.NewStore(time.Duration(cfg.BatchRetention) * time.Second),
+		MaxBatchURLs:      cfg.BatchMaxURLs,
+	}
+
+	jobManager.OnFinish = h.JobFinished
+
+	// Finished jobs outlive CACHE_TTL on disk, if a directory is given
+	if cfg.HistoryDir != "" {
+		history, err := storage.Open(cfg.HistoryDir, storage.Retention{
+			MaxAge:    time.Duration(cfg.HistoryMaxAge) * 24 * time.Hour,
+			MaxPerURL: cfg.HistoryMaxPerURL,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		h.History = history
+	}
+
+	scheduler := monitor.NewScheduler(h.RunMonitor)
+	scheduler.Path = cfg.MonitorsFile
+	scheduler.MinInterval = time.Duration(cfg.MonitorMinInterval) * time.Second
+	scheduler.OnAlert = h.MonitorAlert
+
+	// Lets conditions like title_changed compare with the run before a restart
+	if h.History != nil {
+		scheduler.Lookup = func(id string) (jobs.Job, bool) {
+			job, err := h.History.Get(id)
+
+			return job, err == nil
+		}
 	}
 
+	err = scheduler.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scheduler.Start()
+	h.Monitors = scheduler
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", h.IndexHandler)
 	mux.HandleFunc("/analyze", h.AnalyzeHandler)
 	mux.HandleFunc("/result", h.ResultHandler)
 	mux.HandleFunc("/status", h.JobStatus)
+	mux.HandleFunc("DELETE /jobs/{id}", h.CancelJob)
+	mux.HandleFunc("GET /jobs/{id}/events", h.JobEvents)
+	mux.HandleFunc("POST /api/v1/scans", h.APIStartScan)
+	mux.HandleFunc("GET /api/v1/scans/status", h.APIScanStatus)
+	mux.HandleFunc("GET /api/v1/scans/result", h.APIScanResult)
+	mux.HandleFunc("GET /api/v1/scans/{id}", h.APIScanStatus)
+	mux.HandleFunc("GET /api/v1/scans/{id}/result", h.APIScanResult)
+	mux.HandleFunc("GET /api/v1/scans/{id}/events", h.APIJobEvents)
+	mux.HandleFunc("DELETE /api/v1/scans/{id}", h.APICancelJob)
+	mux.HandleFunc("POST /api/v1/crawls", h.APIStartCrawl)
+	mux.HandleFunc("GET /api/v1/crawls/status", h.APICrawlStatus)
+	mux.HandleFunc("GET /api/v1/crawls/result", h.APICrawlResult)
+	mux.HandleFunc("GET /api/v1/crawls/{id}", h.APICrawlStatus)
+	mux.HandleFunc("GET /api/v1/crawls/{id}/result", h.APICrawlResult)
+	mux.HandleFunc("GET /api/v1/crawls/{id}/events", h.APIJobEvents)
+	mux.HandleFunc("DELETE /api/v1/crawls/{id}", h.APICancelJob)
+	mux.HandleFunc("GET /api/v1/link-cache", h.APILinkCacheStats)
+	mux.HandleFunc("GET /api/v1/rate-limiters", h.APIRateLimiters)
+	mux.HandleFunc("/history", h.HistoryHandler)
+	mux.HandleFunc("GET /api/v1/history", h.APIHistory)
+	mux.HandleFunc("GET /api/v1/history/{id}", h.APIHistoryJob)
+	mux.HandleFunc("/diff", h.DiffHandler)
+	mux.HandleFunc("GET /api/v1/diff", h.APIDiff)
+	mux.HandleFunc("GET /monitors", h.MonitorsHandler)
+	mux.HandleFunc("POST /monitors", h.CreateMonitorHandler)
+	mux.HandleFunc("POST /monitors/{id}/{action}", h.MonitorActionHandler)
+	mux.HandleFunc("POST /api/v1/monitors", h.APICreateMonitor)
+	mux.HandleFunc("GET /api/v1/monitors", h.APIListMonitors)
+	mux.HandleFunc("GET /api/v1/monitors/{id}", h.APIGetMonitor)
+	mux.HandleFunc("POST /api/v1/monitors/{id}/pause", h.APIPauseMonitor)
+	mux.HandleFunc("POST /api/v1/monitors/{id}/resume", h.APIResumeMonitor)
+	mux.HandleFunc("DELETE /api/v1/monitors/{id}", h.APIDeleteMonitor)
+	mux.HandleFunc("GET /api/v1/webhooks/deliveries", h.APIWebhookDeliveries)
+	mux.HandleFunc("POST /api/v1/webhooks/test", h.APITestWebhook)
+	mux.HandleFunc("GET /batch", h.BatchHandler)
+	mux.HandleFunc("POST /batch", h.CreateBatchHandler)
+	mux.HandleFunc("POST /api/v1/batches", h.APIStartBatch)
+	mux.HandleFunc("GET /api/v1/batches/{id}", h.APIBatchStatus)
+	mux.HandleFunc("GET /api/v1/batches/{id}/download", h.APIDownloadBatch)
 	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(static.FS))))
 
 	log.Printf("Starting server on %s", cfg.HTTPServe)
@@ -0,0 +1,266 @@
+package batch
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Row is the outcome of a single URL of a batch.
+type Row struct {
+	URL string `json:"url"`
+	// JobID is empty if the URL was rejected before a scan was started.
+	JobID string     `json:"job_id,omitempty"`
+	State jobs.State `json:"state"`
+	// Progress is the percentage of checked links, like jobs.Job.Progress, 100 once the scan is finished.
+	Progress float64 `json:"progress"`
+	Error    string  `json:"error,omitempty"`
+	// The rest is only set once the scan is done
+	Title        string    `json:"title,omitempty"`
+	HTMLVersion  string    `json:"html_version,omitempty"`
+	Links        int       `json:"links"`
+	BrokenLinks  int       `json:"broken_links"`
+	HasLoginForm bool      `json:"has_login_form"`
+	FinishedAt   time.Time `json:"finished_at,omitzero"`
+}
+
+// NewRow describes the current state of job.
+func NewRow(job jobs.Job) Row {
+	r := Row{
+		URL:        job.URL,
+		JobID:      job.ID,
+		State:      job.State,
+		Progress:   job.Progress,
+		Error:      job.Error,
+		FinishedAt: job.FinishedAt,
+	}
+
+	if job.State.Finished() {
+		r.Progress = 100
+	}
+
+	if job.State == jobs.StateDone {
+		r.Title = job.Page.Title
+		r.HTMLVersion = job.Page.HTMLVersion
+		r.Links = len(job.Page.HyperLinks)
+		r.BrokenLinks = len(job.Page.BrokenLinks())
+		r.HasLoginForm = job.Page.HasLoginForm
+	}
+
+	return r
+}
+
+// Batch is a group of scans started together, one per URL.
+type Batch struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// FinishedAt is set once the scans of all rows are finished.
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	Rows       []Row     `json:"rows"`
+}
+
+// Counts aggregates the rows of a batch.
+type Counts struct {
+	Total     int `json:"total"`
+	Finished  int `json:"finished"`
+	Done      int `json:"done"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+	// BrokenLinks is the sum of the broken links of all pages.
+	BrokenLinks int `json:"broken_links"`
+	// Progress is the average progress of all rows, in percent.
+	Progress float64 `json:"progress"`
+}
+
+// Counts adds up the rows of b.
+func (b Batch) Counts() Counts {
+	c := Counts{Total: len(b.Rows)}
+
+	var progress float64
+
+	for _, r := range b.Rows {
+		progress += r.Progress
+		c.BrokenLinks += r.BrokenLinks
+
+		switch r.State {
+		case jobs.StateDone:
+			c.Done++
+		case jobs.StateFailed:
+			c.Failed++
+		case jobs.StateCancelled:
+			c.Cancelled++
+		}
+	}
+
+	c.Finished = c.Done + c.Failed + c.Cancelled
+
+	if c.Total > 0 {
+		c.Progress = progress / float64(c.Total)
+	}
+
+	return c
+}
+
+// WriteCSV writes the rows of b with a header row.
+func (b Batch) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	_ = cw.Write([]string{"url", "state", "error", "title", "html_version", "links", "broken_links", "has_login_form", "job_id", "finished_at"})
+
+	for _, r := range b.Rows {
+		var finishedAt string
+		if !r.FinishedAt.IsZero() {
+			finishedAt = r.FinishedAt.Format(time.RFC3339)
+		}
+
+		// Titles, errors and doctypes come from the scanned sites
+		_ = cw.Write([]string{
+			csvCell(r.URL),
+			string(r.State),
+			csvCell(r.Error),
+			csvCell(r.Title),
+			csvCell(r.HTMLVersion),
+			strconv.Itoa(r.Links),
+			strconv.Itoa(r.BrokenLinks),
+			strconv.FormatBool(r.HasLoginForm),
+			r.JobID,
+			finishedAt,
+		})
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// csvCell keeps spreadsheets from evaluating s as a formula, which they do for cells
+// that start with =, +, -, @, a tab or a carriage return.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+// finish sets FinishedAt once every row is finished.
+func (b *Batch) finish() {
+	if !b.FinishedAt.IsZero() {
+		return
+	}
+
+	for _, r := range b.Rows {
+		if !r.State.Finished() {
+			return
+		}
+	}
+
+	b.FinishedAt = time.Now()
+}
+
+func (b *Batch) clone() Batch {
+	c := *b
+	c.Rows = slices.Clone(b.Rows)
+
+	return c
+}
+
+// Store keeps batches in memory, rows of running scans are updated by JobFinished.
+type Store struct {
+	retention time.Duration
+
+	mu      sync.Mutex
+	batches map[string]*Batch
+}
+
+// NewStore creates a Store that forgets batches retention after their last scan finished.
+func NewStore(retention time.Duration) *Store {
+	return &Store{
+		retention: retention,
+		batches:   make(map[string]*Batch),
+	}
+}
+
+// Add stores a new batch of rows.
+func (s *Store) Add(rows []Row) Batch {
+	b := &Batch{
+		ID:        newID(),
+		CreatedAt: time.Now(),
+		Rows:      slices.Clone(rows),
+	}
+	b.finish()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	s.batches[b.ID] = b
+
+	return b.clone()
+}
+
+// Get returns a batch by ID.
+func (s *Store) Get(id string) (Batch, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.batches[id]
+	if !ok || s.expired(b) {
+		return Batch{}, false
+	}
+
+	return b.clone(), true
+}
+
+// JobFinished updates the rows of a finished job in every batch, it is called from the jobs.Manager's OnFinish.
+func (s *Store) JobFinished(job jobs.Job) {
+	if !job.State.Finished() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, b := range s.batches {
+		changed := false
+
+		for i, r := range b.Rows {
+			if r.JobID == job.ID {
+				b.Rows[i] = NewRow(job)
+				changed = true
+			}
+		}
+
+		if changed {
+			b.finish()
+		}
+	}
+}
+
+// expired reports whether a finished batch outlived the retention period.
+func (s *Store) expired(b *Batch) bool {
+	return !b.FinishedAt.IsZero() && time.Since(b.FinishedAt) > s.retention
+}
+
+// prune removes expired batches, s.mu must be held.
+func (s *Store) prune() {
+	for id, b := range s.batches {
+		if s.expired(b) {
+			delete(s.batches, id)
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,131 @@
+package batch
+
+import (
+	"errors"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	want := []string{"https://a.example", "https://b.example/x?y=1,2"}
+
+	tests := []struct {
+		name  string
+		parse func(string) ([]string, error)
+		input string
+	}{
+		{"list", func(s string) ([]string, error) { return ParseList(strings.NewReader(s)) },
+			"# landing pages\r\nhttps://a.example\n\n  https://b.example/x?y=1,2  \nhttps://a.example\n"},
+		{"csv", func(s string) ([]string, error) { return ParseCSV(strings.NewReader(s)) },
+			"name,url\nA,https://a.example\nB,\"https://b.example/x?y=1,2\"\nno url here\n"},
+		{"json", func(s string) ([]string, error) { return ParseJSON(strings.NewReader(s)) },
+			`["https://a.example", " https://b.example/x?y=1,2", ""]`},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.parse(tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+
+			continue
+		}
+
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: got %q want %q", tt.name, got, want)
+		}
+	}
+
+	_, err := ParseList(strings.NewReader("\n# nothing\n"))
+	if !errors.Is(err, ErrNoURLs) {
+		t.Errorf("expected ErrNoURLs, got %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := NewStore(time.Millisecond * 10)
+
+	running := jobs.Job{ID: "a", URL: "https://a.example", State: jobs.StateCheckingLinks, Progress: 50}
+
+	b := s.Add([]Row{
+		NewRow(running),
+		{URL: "ftp://b.example", State: jobs.StateFailed, Progress: 100, Error: "unsupported scheme"},
+	})
+
+	if c := b.Counts(); c.Total != 2 || c.Finished != 1 || c.Progress != 75 || !b.FinishedAt.IsZero() {
+		t.Fatalf("unexpected counts %+v of a running batch", c)
+	}
+
+	done := running
+	done.State = jobs.StateDone
+	done.FinishedAt = time.Now()
+	done.Page = scanner.PageData{
+		Title:        "A",
+		HTMLVersion:  "HTML5",
+		HasLoginForm: true,
+		HyperLinks:   []parser.HyperLink{{Raw: "/ok", StatusCode: 200}, {Raw: "/gone", StatusCode: 404}},
+	}
+
+	s.JobFinished(done)
+
+	b, ok := s.Get(b.ID)
+	if !ok {
+		t.Fatal("expected the batch to be found")
+	}
+
+	row := b.Rows[0]
+	if row.State != jobs.StateDone || row.Title != "A" || row.HTMLVersion != "HTML5" || row.Links != 2 || row.BrokenLinks != 1 || !row.HasLoginForm {
+		t.Errorf("unexpected row %+v", row)
+	}
+
+	if c := b.Counts(); c.Done != 1 || c.Failed != 1 || c.BrokenLinks != 1 || c.Progress != 100 || b.FinishedAt.IsZero() {
+		t.Errorf("unexpected counts %+v of a finished batch", c)
+	}
+
+	var csv strings.Builder
+
+	err := b.WriteCSV(&csv)
+	if err != nil {
+		t.Fatalf("WriteCSV returned an error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(csv.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "https://a.example,done,,A,HTML5,2,1,true,a,") {
+		t.Errorf("unexpected CSV %q", csv.String())
+	}
+
+	// Titles of scanned pages must not turn into formulas
+	for title, want := range map[string]string{
+		`=HYPERLINK("https://evil.example")`: `"'=HYPERLINK(""https://evil.example"")"`,
+		"+1":                                 "'+1",
+		"-1":                                 "'-1",
+		"@SUM(A1)":                           "'@SUM(A1)",
+		"\tcmd":                              "'\tcmd",
+		"\rcmd":                              "\"'\rcmd\"",
+		"Plain":                              "Plain",
+	} {
+		var out strings.Builder
+
+		formula := Batch{Rows: []Row{{URL: "https://a.example", State: jobs.StateDone, Title: title}}}
+
+		err = formula.WriteCSV(&out)
+		if err != nil {
+			t.Fatalf("WriteCSV returned an error: %v", err)
+		}
+
+		if !strings.Contains(out.String(), ",done,,"+want+",") {
+			t.Errorf("title %q: expected the cell %q, got %q", title, want, out.String())
+		}
+	}
+
+	// Past the retention
+	time.Sleep(time.Millisecond * 15)
+
+	if _, ok := s.Get(b.ID); ok {
+		t.Error("expected the finished batch to expire")
+	}
+}
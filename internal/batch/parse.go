@@ -0,0 +1,107 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNoURLs is returned when a list has no URLs at all.
+var ErrNoURLs = errors.New("no URLs given")
+
+// ParseList reads one URL per line, blank lines and lines starting with # are skipped.
+func ParseList(r io.Reader) ([]string, error) {
+	var urls []string
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		urls = append(urls, line)
+	}
+
+	err := sc.Err()
+	if err != nil {
+		return nil, fmt.Errorf("reading URL list: %w", err)
+	}
+
+	return Clean(urls)
+}
+
+// ParseCSV reads the first field of every record that looks like an HTTP(S) URL,
+// so header rows and columns like "name,url" work without configuration.
+func ParseCSV(r io.Reader) ([]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.Comment = '#'
+
+	var urls []string
+
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV: %w", err)
+		}
+
+		for _, field := range record {
+			field = strings.TrimSpace(field)
+
+			lower := strings.ToLower(field)
+			if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+				urls = append(urls, field)
+
+				break
+			}
+		}
+	}
+
+	return Clean(urls)
+}
+
+// ParseJSON reads a JSON array of URLs.
+func ParseJSON(r io.Reader) ([]string, error) {
+	var urls []string
+
+	err := json.NewDecoder(r).Decode(&urls)
+	if err != nil {
+		return nil, fmt.Errorf("reading JSON: %w", err)
+	}
+
+	return Clean(urls)
+}
+
+// Clean trims the URLs and drops empty and repeated ones, keeping the order of the rest.
+func Clean(urls []string) ([]string, error) {
+	seen := make(map[string]bool, len(urls))
+	result := make([]string, 0, len(urls))
+
+	for _, u := range urls {
+		u = strings.TrimSpace(u)
+		if u == "" || seen[u] {
+			continue
+		}
+
+		seen[u] = true
+		result = append(result, u)
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNoURLs
+	}
+
+	return result, nil
+}
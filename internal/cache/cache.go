@@ -1,65 +1,307 @@
 package cache
 
 import (
+	"container/list"
 	"sync"
 	"time"
 )
 
+// EvictReason tells why an entry left the cache.
+type EvictReason string
+
+const (
+	// EvictExpired means the TTL of the entry ran out.
+	EvictExpired EvictReason = "expired"
+	// EvictCapacity means the least recently used entry made room for newer ones.
+	EvictCapacity EvictReason = "capacity"
+	// EvictDeleted means the entry was removed with Delete.
+	EvictDeleted EvictReason = "deleted"
+)
+
+// Options configure a Cache, every zero value turns the respective feature off.
+type Options[K comparable, V any] struct {
+	// TTL is how long entries are kept unless Set with another TTL, zero keeps them until they are evicted.
+	TTL time.Duration
+	// MaxEntries and MaxBytes bound the cache, the least recently used entries are evicted first.
+	// MaxBytes needs Size to tell how large an entry is.
+	MaxEntries int
+	MaxBytes   int64
+	Size       func(key K, value V) int64
+	// OnEvict is called for every entry that expires, is evicted or deleted,
+	// outside the lock of the cache.
+	OnEvict func(key K, value V, reason EvictReason)
+	// CleanupInterval is how often expired entries are removed, a quarter of the TTL if zero.
+	// Without a TTL, the cleanup starts with the first entry that is Set with one, every quarter of its TTL.
+	CleanupInterval time.Duration
+}
+
+// Stats are the counters and the current size of a Cache.
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Expired   int64 `json:"expired"`
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+}
+
 type Cache[K comparable, V any] struct {
-	items map[K]item[V]
+	items map[K]*list.Element
 	mu    sync.RWMutex
-	ttl   time.Duration
+	// order holds the entries, the most recently used one first
+	order *list.List
+	opts  Options[K, V]
+	bytes int64
+	stats Stats
+
+	// cleaning is set once the cleanup runs, c.mu must be held
+	cleaning  bool
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-type item[V any] struct {
+type item[K comparable, V any] struct {
+	key        K
 	value      V
 	expiration int64
+	size       int64
+}
+
+// evicted is an entry that is handed to OnEvict once the lock is released.
+type evicted[K comparable, V any] struct {
+	key    K
+	value  V
+	reason EvictReason
 }
 
+// New creates an unbounded Cache whose entries expire after ttl.
 func New[K comparable, V any](ttl time.Duration) *Cache[K, V] {
+	return NewWithOptions(Options[K, V]{TTL: ttl})
+}
+
+// NewWithOptions creates a Cache, call Close to stop its cleanup once it is no longer used.
+func NewWithOptions[K comparable, V any](opts Options[K, V]) *Cache[K, V] {
 	c := &Cache[K, V]{
-		items: make(map[K]item[V]),
-		ttl:   ttl,
+		items: make(map[K]*list.Element),
+		order: list.New(),
+		opts:  opts,
+		done:  make(chan struct{}),
 	}
 
-	// Periodically clean up
-	go c.cleanup()
+	// Expired entries are invisible right away, but hold memory until the cleanup runs
+	if opts.TTL > 0 {
+		c.startCleanup(opts.TTL)
+	}
 
 	return c
 }
 
+// startCleanup removes expired entries every CleanupInterval, or every quarter of ttl, unless it already does.
+// c.mu must be held once the cache is in use.
+func (c *Cache[K, V]) startCleanup(ttl time.Duration) {
+	if c.cleaning {
+		return
+	}
+
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	interval := c.opts.CleanupInterval
+	if interval <= 0 {
+		interval = max(ttl/4, time.Millisecond)
+	}
+
+	c.cleaning = true
+
+	go c.cleanup(interval)
+}
+
 func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.opts.TTL)
+}
+
+// SetWithTTL stores value for ttl instead of the TTL of the cache, zero keeps it until it is evicted.
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	var expiration int64
+	if ttl > 0 {
+		expiration = time.Now().Add(ttl).UnixNano()
+	}
+
+	var size int64
+	if c.opts.Size != nil {
+		size = c.opts.Size(key, value)
+	}
+
 	c.mu.Lock()
-	defer c.mu.Unlock()
 
-	c.items[key] = item[V]{
-		value:      value,
-		expiration: time.Now().Add(c.ttl).UnixNano(),
+	// Entries that expire need the cleanup, even if the cache has no TTL of its own
+	if ttl > 0 {
+		c.startCleanup(ttl)
+	}
+
+	if el, ok := c.items[key]; ok {
+		it := el.Value.(*item[K, V])
+		c.bytes += size - it.size
+		it.value, it.expiration, it.size = value, expiration, size
+		c.order.MoveToFront(el)
+	} else {
+		c.items[key] = c.order.PushFront(&item[K, V]{key: key, value: value, expiration: expiration, size: size})
+		c.bytes += size
+	}
+
+	var out []evicted[K, V]
+
+	// An entry larger than MaxBytes on its own evicts itself as well
+	for c.overCapacity() {
+		it := c.removeElement(c.order.Back())
+		c.stats.Evictions++
+		out = append(out, evicted[K, V]{it.key, it.value, EvictCapacity})
 	}
+
+	c.mu.Unlock()
+
+	c.notify(out)
+}
+
+func (c *Cache[K, V]) overCapacity() bool {
+	if c.order.Len() == 0 {
+		return false
+	}
+
+	return (c.opts.MaxEntries > 0 && c.order.Len() > c.opts.MaxEntries) ||
+		(c.opts.MaxBytes > 0 && c.bytes > c.opts.MaxBytes)
 }
 
 func (c *Cache[K, V]) Get(key K) (V, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		c.mu.Unlock()
 
-	it, ok := c.items[key]
-	if !ok || time.Now().UnixNano() > it.expiration {
 		return *new(V), false
 	}
 
+	it := el.Value.(*item[K, V])
+
+	if it.expired(time.Now().UnixNano()) {
+		c.removeElement(el)
+		c.stats.Misses++
+		c.stats.Expired++
+		c.mu.Unlock()
+
+		c.notify([]evicted[K, V]{{it.key, it.value, EvictExpired}})
+
+		return *new(V), false
+	}
+
+	c.order.MoveToFront(el)
+	c.stats.Hits++
+	c.mu.Unlock()
+
 	return it.value, true
 }
 
-func (c *Cache[K, V]) cleanup() {
+// Delete removes key from the cache.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+
+		return
+	}
+
+	it := c.removeElement(el)
+	c.mu.Unlock()
+
+	c.notify([]evicted[K, V]{{it.key, it.value, EvictDeleted}})
+}
+
+// Len returns the number of entries, including expired ones that were not cleaned up yet.
+func (c *Cache[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return len(c.items)
+}
+
+// Stats returns the counters since the cache was created and its current size.
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := c.stats
+	stats.Entries = len(c.items)
+	stats.Bytes = c.bytes
+
+	return stats
+}
+
+// Close stops the cleanup of expired entries, the cache itself keeps working.
+func (c *Cache[K, V]) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *Cache[K, V]) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
-		time.Sleep(c.ttl)
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+
+		now := time.Now().UnixNano()
+
+		var out []evicted[K, V]
 
 		c.mu.Lock()
-		for key, it := range c.items {
-			if time.Now().UnixNano() > it.expiration {
-				delete(c.items, key)
+		for el := c.order.Front(); el != nil; {
+			next := el.Next()
+
+			if it := el.Value.(*item[K, V]); it.expired(now) {
+				c.removeElement(el)
+				c.stats.Expired++
+				out = append(out, evicted[K, V]{it.key, it.value, EvictExpired})
 			}
+
+			el = next
 		}
 		c.mu.Unlock()
+
+		c.notify(out)
 	}
 }
+
+// removeElement drops el from the cache, c.mu must be held.
+func (c *Cache[K, V]) removeElement(el *list.Element) *item[K, V] {
+	it := c.order.Remove(el).(*item[K, V])
+	delete(c.items, it.key)
+	c.bytes -= it.size
+
+	return it
+}
+
+func (c *Cache[K, V]) notify(out []evicted[K, V]) {
+	if c.opts.OnEvict == nil {
+		return
+	}
+
+	for _, e := range out {
+		c.opts.OnEvict(e.key, e.value, e.reason)
+	}
+}
+
+func (it *item[K, V]) expired(now int64) bool {
+	return it.expiration > 0 && now > it.expiration
+}
@@ -35,3 +35,161 @@ func TestCache_Cleanup(t *testing.T) {
 	}
 	cache.mu.RUnlock()
 }
+
+func TestCache_MaxEntries(t *testing.T) {
+	var evicted []string
+
+	cache := NewWithOptions(Options[string, int]{
+		MaxEntries: 2,
+		OnEvict: func(key string, value int, reason EvictReason) {
+			if reason != EvictCapacity {
+				t.Errorf("Expected %s to be evicted for capacity, got %s", key, reason)
+			}
+
+			evicted = append(evicted, key)
+		},
+	})
+	defer cache.Close()
+
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Get("a")
+	cache.Set("c", 3)
+
+	if _, found := cache.Get("b"); found {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+
+	if _, found := cache.Get("a"); !found {
+		t.Error("Expected the recently used entry to be kept")
+	}
+
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("Expected b to be evicted, got %v", evicted)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 || stats.Evictions != 1 || stats.Entries != 2 || cache.Len() != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestCache_MaxBytes(t *testing.T) {
+	cache := NewWithOptions(Options[string, string]{
+		MaxBytes: 10,
+		Size: func(key, value string) int64 {
+			return int64(len(value))
+		},
+	})
+	defer cache.Close()
+
+	cache.Set("a", "12345")
+	cache.Set("b", "12345")
+	cache.Set("a", "123")
+
+	if stats := cache.Stats(); stats.Bytes != 8 || stats.Entries != 2 {
+		t.Errorf("Expected replacing an entry to update the size, got %+v", stats)
+	}
+
+	cache.Set("c", "1234")
+
+	if _, found := cache.Get("b"); found {
+		t.Error("Expected b to be evicted to stay within MaxBytes")
+	}
+
+	cache.Set("huge", "12345678901")
+
+	if cache.Len() != 0 {
+		t.Errorf("Expected an entry larger than MaxBytes to evict everything, %d entries left", cache.Len())
+	}
+}
+
+func TestCache_SetWithTTL(t *testing.T) {
+	var expired []string
+
+	cache := NewWithOptions(Options[string, int]{
+		TTL: time.Hour,
+		OnEvict: func(key string, value int, reason EvictReason) {
+			if reason == EvictExpired {
+				expired = append(expired, key)
+			}
+		},
+	})
+	defer cache.Close()
+
+	cache.SetWithTTL("short", 1, time.Millisecond*10)
+	cache.Set("long", 2)
+	cache.SetWithTTL("forever", 3, 0)
+
+	time.Sleep(time.Millisecond * 15)
+
+	if _, found := cache.Get("short"); found {
+		t.Error("Expected the entry with a short TTL to expire")
+	}
+
+	for _, key := range []string{"long", "forever"} {
+		if _, found := cache.Get(key); !found {
+			t.Errorf("Expected %s to be kept", key)
+		}
+	}
+
+	if len(expired) != 1 || expired[0] != "short" {
+		t.Errorf("Expected short to be reported as expired, got %v", expired)
+	}
+}
+
+func TestCache_CleanupWithoutTTL(t *testing.T) {
+	cache := NewWithOptions(Options[string, int]{})
+	defer cache.Close()
+
+	cache.Set("forever", 1)
+	cache.SetWithTTL("short", 2, time.Millisecond*10)
+
+	time.Sleep(time.Millisecond * 20)
+
+	// Len counts expired entries until the cleanup removed them
+	if cache.Len() != 1 {
+		t.Errorf("Expected the entry with a TTL to be cleaned up, but the cache contains %d items", cache.Len())
+	}
+}
+
+func TestCache_Delete(t *testing.T) {
+	var reasons []EvictReason
+
+	cache := NewWithOptions(Options[string, int]{
+		OnEvict: func(key string, value int, reason EvictReason) {
+			reasons = append(reasons, reason)
+		},
+	})
+	defer cache.Close()
+
+	cache.Set("key1", 1)
+	cache.Delete("key1")
+	cache.Delete("missing")
+
+	if _, found := cache.Get("key1"); found || cache.Len() != 0 {
+		t.Error("Expected key1 to be deleted")
+	}
+
+	if len(reasons) != 1 || reasons[0] != EvictDeleted {
+		t.Errorf("Expected a single deletion, got %v", reasons)
+	}
+}
+
+func TestCache_Close(t *testing.T) {
+	cache := NewWithOptions(Options[string, int]{TTL: time.Millisecond * 5})
+	cache.Close()
+	cache.Close()
+
+	cache.Set("key1", 1)
+
+	time.Sleep(time.Millisecond * 20)
+
+	if cache.Len() != 1 {
+		t.Errorf("Expected no cleanup after Close, but the cache contains %d items", cache.Len())
+	}
+
+	if _, found := cache.Get("key1"); found {
+		t.Error("Expected expired entries to stay invisible after Close")
+	}
+}
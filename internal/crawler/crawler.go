@@ -0,0 +1,457 @@
+package crawler
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"golang.org/x/net/publicsuffix"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+)
+
+// Scope limits which hosts the crawler is allowed to follow links to.
+type Scope string
+
+const (
+	// ScopeSameHost only follows links to the host of the start URL.
+	ScopeSameHost Scope = "same-host"
+	// ScopeSameSite follows links to any host that shares the registrable domain (eTLD+1) of the start URL.
+	ScopeSameSite Scope = "same-site"
+)
+
+// ErrOutOfScope is the error of pages that redirected to a host outside of the crawl scope.
+var ErrOutOfScope = errors.New("redirected out of the crawl scope")
+
+// Options control how far the crawler goes.
+type Options struct {
+	MaxDepth int      `json:"max_depth"` // 0 crawls the start page only
+	MaxPages int      `json:"max_pages"`
+	Include  []string `json:"include,omitempty"` // regular expressions matched against the URL path
+	Exclude  []string `json:"exclude,omitempty"` // regular expressions matched against the URL path
+	Scope    Scope    `json:"scope"`
+	Sitemap  bool     `json:"sitemap"` // also seed the crawl with /sitemap.xml entries
+}
+
+// DefaultOptions are used for every zero value of Options but MaxDepth,
+// decoders of Options start from them to tell unset from zero.
+var DefaultOptions = Options{
+	MaxDepth: 2,
+	MaxPages: 50,
+	Scope:    ScopeSameHost,
+}
+
+// PageReport is the analysis of a single crawled page.
+//
+// URL is where the page was loaded from after redirects, Page is nil for errors and pages that are not HTML.
+type PageReport struct {
+	URL          string            `json:"url"`
+	RequestedURL string            `json:"requested_url,omitempty"` // set if it redirected to URL
+	Depth        int               `json:"depth"`
+	ContentType  string            `json:"content_type,omitempty"`
+	Page         *scanner.PageData `json:"page,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// BrokenLink is a link that failed on at least one crawled page.
+type BrokenLink struct {
+	URL          string             `json:"url"`
+	StatusCode   int                `json:"status_code"`
+	Failure      parser.FailureKind `json:"failure,omitempty"`
+	Error        string             `json:"error,omitempty"`
+	ReferencedBy []string           `json:"referenced_by"`
+}
+
+// SiteReport aggregates the results of a crawl.
+type SiteReport struct {
+	StartURL    string       `json:"start_url"`
+	Options     Options      `json:"options"`
+	Pages       []PageReport `json:"pages"`
+	BrokenLinks []BrokenLink `json:"broken_links"`
+	// Orphans are crawled pages that no other crawled page links to.
+	Orphans []string `json:"orphans"`
+}
+
+// ProgressFunc is called every time a page is crawled.
+type ProgressFunc func(crawled, queued int)
+
+// Crawler follows internal links of a site and runs the full page analysis on every page.
+type Crawler struct {
+	Scanner *scanner.Scanner
+	Options Options
+
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// New validates opts and creates a Crawler.
+func New(s *scanner.Scanner, opts Options) (*Crawler, error) {
+	if opts.MaxDepth < 0 {
+		return nil, fmt.Errorf("negative crawl depth %d", opts.MaxDepth)
+	}
+
+	if opts.MaxPages <= 0 {
+		opts.MaxPages = DefaultOptions.MaxPages
+	}
+
+	if opts.Scope == "" {
+		opts.Scope = DefaultOptions.Scope
+	}
+
+	if opts.Scope != ScopeSameHost && opts.Scope != ScopeSameSite {
+		return nil, fmt.Errorf("unknown crawl scope %q", opts.Scope)
+	}
+
+	c := &Crawler{Scanner: s, Options: opts}
+
+	for _, pattern := range opts.Include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+
+		c.include = append(c.include, re)
+	}
+
+	for _, pattern := range opts.Exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+
+		c.exclude = append(c.exclude, re)
+	}
+
+	return c, nil
+}
+
+type queued struct {
+	url   *url.URL
+	depth int
+}
+
+// Crawl walks the site breadth-first starting at start.
+//
+// The start page is always crawled, even if it does not match the include patterns.
+// Crawling stops once ctx is done, progress may be nil.
+func (c *Crawler) Crawl(ctx context.Context, start *url.URL, progress ProgressFunc) (SiteReport, error) {
+	report := SiteReport{
+		StartURL: start.String(),
+		Options:  c.Options,
+	}
+
+	// Fail early if we can't even load the start page
+	first, err := c.Scanner.Fetch(ctx, start)
+	if err != nil {
+		return report, err
+	}
+
+	// The start page may redirect to another host, e.g. http:// -> https://www.,
+	// scope checks are done against the host the site is actually served from
+	origin := first.FinalURL
+
+	seen := map[string]bool{normalize(start): true, normalize(origin): true}
+	queue := []queued{{url: start, depth: 0}}
+
+	// Sitemap entries are a level below the start page
+	if c.Options.Sitemap && c.Options.MaxDepth > 0 {
+		for _, u := range c.sitemap(ctx, origin) {
+			key := normalize(u)
+			if !seen[key] && c.inScope(origin, u) && c.matches(u) {
+				seen[key] = true
+				queue = append(queue, queued{url: u, depth: 1})
+			}
+		}
+	}
+
+	// inbound maps a page to the set of other pages that link to it
+	inbound := make(map[string]map[string]bool)
+	broken := make(map[string]*BrokenLink)
+
+	// crawled holds the final URLs of the pages in the report
+	crawled := make(map[string]bool)
+
+	// Every fetch counts against MaxPages, whatever it turned out to be
+	for fetched := 0; len(queue) > 0 && fetched < c.Options.MaxPages && ctx.Err() == nil; fetched++ {
+		next := queue[0]
+		queue = queue[1:]
+
+		requestedURL := normalize(next.url)
+
+		document, fetchErr := first, error(nil)
+		if next.depth > 0 {
+			document, fetchErr = c.Scanner.Fetch(ctx, next.url)
+		}
+
+		if fetchErr != nil {
+			report.Pages = append(report.Pages, PageReport{URL: requestedURL, Depth: next.depth, Error: fetchErr.Error()})
+
+			if progress != nil {
+				progress(len(report.Pages), len(queue))
+			}
+
+			continue
+		}
+
+		// Links were checked against the scope, where they redirect to was not
+		if !c.inScope(origin, document.FinalURL) {
+			err := fmt.Errorf("%w: %s", ErrOutOfScope, document.FinalURL)
+			report.Pages = append(report.Pages, PageReport{URL: requestedURL, Depth: next.depth, Error: err.Error()})
+
+			if progress != nil {
+				progress(len(report.Pages), len(queue))
+			}
+
+			continue
+		}
+
+		// Pages are told apart by where they were loaded from, several URLs may redirect to the same one
+		pageURL := normalize(document.FinalURL)
+		seen[pageURL] = true
+
+		if crawled[pageURL] {
+			log.Printf("[crawl %s] Skipping %s, it redirected to the already crawled %s", start, requestedURL, pageURL)
+
+			continue
+		}
+
+		crawled[pageURL] = true
+
+		pageReport := PageReport{URL: pageURL, Depth: next.depth, ContentType: document.ContentType}
+		if requestedURL != pageURL {
+			pageReport.RequestedURL = requestedURL
+		}
+
+		if !isHTML(document.ContentType) {
+			report.Pages = append(report.Pages, pageReport)
+
+			if progress != nil {
+				progress(len(report.Pages), len(queue))
+			}
+
+			continue
+		}
+
+		page := c.Scanner.Analyze(ctx, document, nil)
+		pageReport.Page = &page
+		report.Pages = append(report.Pages, pageReport)
+
+		for _, link := range page.HyperLinks {
+			if link.Resolved == nil {
+				continue
+			}
+
+			target := normalize(link.Resolved)
+
+			if target != pageURL {
+				if inbound[target] == nil {
+					inbound[target] = make(map[string]bool)
+				}
+
+				inbound[target][pageURL] = true
+			}
+
+			if link.Broken() {
+				b, ok := broken[target]
+				if !ok {
+					b = &BrokenLink{URL: target, StatusCode: link.StatusCode, Failure: link.Failure}
+					if link.Err != nil {
+						b.Error = link.Err.Error()
+					}
+
+					broken[target] = b
+				}
+
+				b.ReferencedBy = appendUnique(b.ReferencedBy, pageURL)
+
+				continue
+			}
+
+			// Only anchors lead to other pages, images and scripts are not crawled,
+			// neither are pages that robots.txt kept us from checking
+			if !link.IsNavigation() || link.Failure == parser.FailureRobotsDisallowed || next.depth >= c.Options.MaxDepth || seen[target] {
+				continue
+			}
+
+			if link.Resolved.Scheme != "http" && link.Resolved.Scheme != "https" {
+				continue
+			}
+
+			if !c.inScope(origin, link.Resolved) || !c.matches(link.Resolved) {
+				continue
+			}
+
+			seen[target] = true
+			queue = append(queue, queued{url: stripFragment(link.Resolved), depth: next.depth + 1})
+		}
+
+		if progress != nil {
+			progress(len(report.Pages), len(queue))
+		}
+	}
+
+	startKey := normalize(origin)
+
+	for _, p := range report.Pages {
+		// Links may point to the URL that redirected, not to the page itself
+		if p.URL != startKey && p.Error == "" && len(inbound[p.URL]) == 0 && len(inbound[p.RequestedURL]) == 0 {
+			report.Orphans = append(report.Orphans, p.URL)
+		}
+	}
+
+	for _, b := range broken {
+		sort.Strings(b.ReferencedBy)
+		report.BrokenLinks = append(report.BrokenLinks, *b)
+	}
+
+	sort.Slice(report.BrokenLinks, func(i, j int) bool {
+		return report.BrokenLinks[i].URL < report.BrokenLinks[j].URL
+	})
+
+	return report, nil
+}
+
+// inScope reports whether u can be crawled when the crawl started at start.
+func (c *Crawler) inScope(start, u *url.URL) bool {
+	if u.Hostname() == start.Hostname() {
+		return true
+	}
+
+	if c.Options.Scope != ScopeSameSite {
+		return false
+	}
+
+	startSite, err := publicsuffix.EffectiveTLDPlusOne(start.Hostname())
+	if err != nil {
+		return false
+	}
+
+	site, err := publicsuffix.EffectiveTLDPlusOne(u.Hostname())
+	if err != nil {
+		return false
+	}
+
+	return site == startSite
+}
+
+// matches applies include and exclude patterns to the path of u.
+func (c *Crawler) matches(u *url.URL) bool {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	for _, re := range c.exclude {
+		if re.MatchString(path) {
+			return false
+		}
+	}
+
+	if len(c.include) == 0 {
+		return true
+	}
+
+	for _, re := range c.include {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type sitemapURLSet struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+}
+
+// sitemap returns URLs listed in /sitemap.xml of the start host, if there is one.
+func (c *Crawler) sitemap(ctx context.Context, start *url.URL) []*url.URL {
+	sitemapURL := &url.URL{Scheme: start.Scheme, Host: start.Host, Path: "/sitemap.xml"}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sitemapURL.String(), nil)
+	if err != nil {
+		return nil
+	}
+
+	resp, err := c.Scanner.Client.Do(req)
+	if err != nil {
+		log.Printf("[crawl %s] Could not fetch sitemap: %v", start, err)
+
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var set sitemapURLSet
+
+	err = xml.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		log.Printf("[crawl %s] Could not parse sitemap: %v", start, err)
+
+		return nil
+	}
+
+	urls := make([]*url.URL, 0, len(set.URLs))
+
+	for _, entry := range set.URLs {
+		u, err := url.Parse(entry.Loc)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+
+		urls = append(urls, stripFragment(u))
+	}
+
+	return urls
+}
+
+// normalize returns the string form of u without the fragment, used to tell pages apart.
+func normalize(u *url.URL) string {
+	return parser.NormalizeURL(stripFragment(u))
+}
+
+func stripFragment(u *url.URL) *url.URL {
+	out := *u
+	out.Fragment = ""
+	out.RawFragment = ""
+
+	if out.Path == "" {
+		out.Path = "/"
+	}
+
+	return &out
+}
+
+func isHTML(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+
+	return append(list, s)
+}
@@ -0,0 +1,306 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"golang.org/x/time/rate"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newTestSite(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	pages := map[string]string{
+		"/":        `<a href="/a">A</a><a href="/b#top">B</a><a href="https://example.invalid/">External</a>`,
+		"/a":       `<a href="/">Home</a><a href="/missing">Missing</a><a href="/a/deep">Deep</a>`,
+		"/b":       `<a href="/missing">Missing</a><a href="/private/secret">Secret</a>`,
+		"/a/deep":  `<a href="/a/deeper">Deeper</a>`,
+		"/orphan":  `<a href="/">Home</a>`,
+		"/private": `nothing to see here`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sitemap.xml" {
+			_, _ = fmt.Fprintf(w, `<urlset><url><loc>http://%s/orphan</loc></url></urlset>`, r.Host)
+
+			return
+		}
+
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprintf(w, `<!DOCTYPE html><html><head><title>%s</title></head><body>%s</body></html>`, r.URL.Path, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestCrawler_Crawl(t *testing.T) {
+	server := newTestSite(t)
+
+	s := &scanner.Scanner{
+		Client:      server.Client(),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(1000), 1),
+	}
+
+	c, err := New(s, Options{MaxDepth: 2, MaxPages: 10, Exclude: []string{"^/private"}, Sitemap: true})
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+
+	start, _ := url.Parse(server.URL + "/")
+
+	report, err := c.Crawl(context.Background(), start, nil)
+	if err != nil {
+		t.Fatalf("Crawl returned an error: %v", err)
+	}
+
+	crawled := make(map[string]int)
+	for _, p := range report.Pages {
+		crawled[p.URL] = p.Depth
+	}
+
+	want := map[string]int{
+		server.URL + "/":       0,
+		server.URL + "/a":      1,
+		server.URL + "/b":      1,
+		server.URL + "/orphan": 1,
+		server.URL + "/a/deep": 2,
+	}
+
+	if len(crawled) != len(want) {
+		t.Errorf("unexpected crawled pages: got %v want %v", crawled, want)
+	}
+
+	for u, depth := range want {
+		got, ok := crawled[u]
+		if !ok || got != depth {
+			t.Errorf("expected %s to be crawled at depth %d, got %d (crawled=%v)", u, depth, got, ok)
+		}
+	}
+
+	var missing *BrokenLink
+
+	for i := range report.BrokenLinks {
+		if report.BrokenLinks[i].URL == server.URL+"/missing" {
+			missing = &report.BrokenLinks[i]
+		}
+	}
+
+	if missing == nil {
+		t.Fatalf("expected /missing to be reported as broken, got %+v", report.BrokenLinks)
+	}
+
+	if len(missing.ReferencedBy) != 2 || missing.ReferencedBy[0] != server.URL+"/a" || missing.ReferencedBy[1] != server.URL+"/b" {
+		t.Errorf("unexpected referencing pages: %v", missing.ReferencedBy)
+	}
+
+	if len(report.Orphans) != 1 || report.Orphans[0] != server.URL+"/orphan" {
+		t.Errorf("unexpected orphans: %v", report.Orphans)
+	}
+}
+
+func TestCrawler_MaxPages(t *testing.T) {
+	server := newTestSite(t)
+
+	s := &scanner.Scanner{
+		Client:      server.Client(),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(1000), 1),
+	}
+
+	c, err := New(s, Options{MaxDepth: 5, MaxPages: 2})
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+
+	start, _ := url.Parse(server.URL)
+
+	report, err := c.Crawl(context.Background(), start, nil)
+	if err != nil {
+		t.Fatalf("Crawl returned an error: %v", err)
+	}
+
+	if len(report.Pages) != 2 {
+		t.Errorf("expected 2 crawled pages, got %d", len(report.Pages))
+	}
+}
+
+func TestCrawler_NonHTMLAndRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = fmt.Fprint(w, `<!DOCTYPE html><html><body><a href="/report.pdf">PDF</a><a href="/old">Old</a><a href="/moved">Moved</a></body></html>`)
+		case "/files":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = fmt.Fprint(w, `<!DOCTYPE html><html><body><a href="/report.pdf">PDF</a><a href="/slides.pdf">Slides</a></body></html>`)
+		case "/report.pdf", "/slides.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			_, _ = fmt.Fprint(w, "%PDF-1.4")
+		case "/old", "/moved":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = fmt.Fprint(w, `<!DOCTYPE html><html><body><a href="/">Home</a></body></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	s := &scanner.Scanner{
+		Client:      server.Client(),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(1000), 1),
+	}
+
+	c, err := New(s, Options{MaxDepth: 2, MaxPages: 10})
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+
+	start, _ := url.Parse(server.URL + "/")
+
+	report, err := c.Crawl(context.Background(), start, nil)
+	if err != nil {
+		t.Fatalf("Crawl returned an error: %v", err)
+	}
+
+	pages := make(map[string]PageReport)
+	for _, p := range report.Pages {
+		pages[p.URL] = p
+	}
+
+	if len(report.Pages) != 3 || len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %+v", report.Pages)
+	}
+
+	if p := pages[server.URL+"/report.pdf"]; p.Page != nil || p.ContentType != "application/pdf" {
+		t.Errorf("expected the PDF to be reported without analysis, got %+v", p)
+	}
+
+	// Only the first of /old and /moved is reported, the other one redirects to the same page
+	if p := pages[server.URL+"/new"]; p.Page == nil || (p.RequestedURL != server.URL+"/old" && p.RequestedURL != server.URL+"/moved") {
+		t.Errorf("expected the redirect to be keyed by its final URL, got %+v", p)
+	}
+
+	if len(report.Orphans) != 0 {
+		t.Errorf("expected no orphans, got %v", report.Orphans)
+	}
+
+	// Every fetched page counts, whether it is HTML or not
+	c.Options.MaxPages = 2
+	files, _ := url.Parse(server.URL + "/files")
+
+	report, err = c.Crawl(context.Background(), files, nil)
+	if err != nil {
+		t.Fatalf("Crawl returned an error: %v", err)
+	}
+
+	if len(report.Pages) != 2 || report.Pages[1].ContentType != "application/pdf" {
+		t.Errorf("expected the start page and one of the PDFs, got %+v", report.Pages)
+	}
+}
+
+func TestCrawler_RedirectOutOfScope(t *testing.T) {
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprint(w, `<!DOCTYPE html><html><body>Sign in</body></html>`)
+	}))
+	defer external.Close()
+
+	// Same server, but a host name that is out of scope of 127.0.0.1
+	_, port, _ := net.SplitHostPort(external.Listener.Addr().String())
+	login := "http://localhost:" + port + "/signin"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.Redirect(w, r, login, http.StatusFound)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprint(w, `<!DOCTYPE html><html><body><a href="/login">Log in</a></body></html>`)
+	}))
+	defer server.Close()
+
+	s := &scanner.Scanner{
+		Client:      server.Client(),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(1000), 1),
+	}
+
+	c, err := New(s, Options{MaxDepth: 2})
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+
+	start, _ := url.Parse(server.URL + "/")
+
+	report, err := c.Crawl(context.Background(), start, nil)
+	if err != nil {
+		t.Fatalf("Crawl returned an error: %v", err)
+	}
+
+	if len(report.Pages) != 2 {
+		t.Fatalf("expected 2 pages, got %+v", report.Pages)
+	}
+
+	if p := report.Pages[1]; p.URL != server.URL+"/login" || p.Page != nil || !strings.Contains(p.Error, ErrOutOfScope.Error()) {
+		t.Errorf("expected /login to be reported as out of scope without analysis, got %+v", p)
+	}
+}
+
+func TestCrawler_StartPageOnly(t *testing.T) {
+	server := newTestSite(t)
+
+	s := &scanner.Scanner{
+		Client:      server.Client(),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(1000), 1),
+	}
+
+	c, err := New(s, Options{MaxDepth: 0, Sitemap: true})
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+
+	start, _ := url.Parse(server.URL + "/")
+
+	report, err := c.Crawl(context.Background(), start, nil)
+	if err != nil {
+		t.Fatalf("Crawl returned an error: %v", err)
+	}
+
+	if len(report.Pages) != 1 || report.Pages[0].URL != server.URL+"/" {
+		t.Errorf("expected only the start page to be crawled, got %+v", report.Pages)
+	}
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	_, err := New(&scanner.Scanner{}, Options{Scope: "everything"})
+	if err == nil {
+		t.Error("expected an error for unknown scope")
+	}
+
+	_, err = New(&scanner.Scanner{}, Options{Include: []string{"("}})
+	if err == nil {
+		t.Error("expected an error for invalid include pattern")
+	}
+
+	_, err = New(&scanner.Scanner{}, Options{MaxDepth: -1})
+	if err == nil {
+		t.Error("expected an error for negative depth")
+	}
+}
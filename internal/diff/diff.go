@@ -0,0 +1,223 @@
+// Package diff compares two scans of the same page.
+package diff
+
+import (
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"slices"
+	"sort"
+)
+
+// Change is a value that differs between the two scans.
+type Change[T comparable] struct {
+	Old T `json:"old"`
+	New T `json:"new"`
+}
+
+func change[T comparable](before, after T) *Change[T] {
+	if before == after {
+		return nil
+	}
+
+	return &Change[T]{Old: before, New: after}
+}
+
+// CountDelta is a counter that differs between the two scans.
+type CountDelta struct {
+	// Name is the heading level like "h2", or the JSON name of a LinkCounters field like "external_alive".
+	// Schemes of protocol links are named "protocols.mailto" and so on.
+	Name  string `json:"name"`
+	Old   int64  `json:"old"`
+	New   int64  `json:"new"`
+	Delta int64  `json:"delta"`
+}
+
+// Link is a link as far as the diff is concerned.
+type Link struct {
+	URL        string             `json:"url"`
+	Raw        string             `json:"raw"`
+	StatusCode int                `json:"status_code"`
+	Failure    parser.FailureKind `json:"failure,omitempty"`
+	Broken     bool               `json:"broken"`
+}
+
+func newLink(l parser.HyperLink) Link {
+	return Link{
+		URL:        linkKey(l),
+		Raw:        l.Raw,
+		StatusCode: l.StatusCode,
+		Failure:    l.Failure,
+		Broken:     l.Broken(),
+	}
+}
+
+// LinkChange is a link of both scans whose check turned out differently, like 200 -> 404.
+type LinkChange struct {
+	URL string `json:"url"`
+	Old Link   `json:"old"`
+	New Link   `json:"new"`
+}
+
+// Broke reports whether the link works in the old scan but not in the new one.
+func (c LinkChange) Broke() bool {
+	return !c.Old.Broken && c.New.Broken
+}
+
+// Fixed reports whether the link was broken in the old scan and works in the new one.
+func (c LinkChange) Fixed() bool {
+	return c.Old.Broken && !c.New.Broken
+}
+
+// Report is everything that changed between two scans, unchanged parts are left out.
+type Report struct {
+	Title       *Change[string] `json:"title,omitempty"`
+	HTMLVersion *Change[string] `json:"html_version,omitempty"`
+	LoginForm   *Change[bool]   `json:"login_form,omitempty"`
+	// Headings are ordered by level, LinkCounters in the order of the LinkCounters fields.
+	Headings     []CountDelta `json:"headings,omitempty"`
+	LinkCounters []CountDelta `json:"link_counters,omitempty"`
+	// Links are identified by their resolved URL and sorted by it.
+	AddedLinks   []Link       `json:"added_links,omitempty"`
+	RemovedLinks []Link       `json:"removed_links,omitempty"`
+	ChangedLinks []LinkChange `json:"changed_links,omitempty"`
+}
+
+// Empty reports whether the two scans are the same as far as the report goes.
+func (r Report) Empty() bool {
+	return r.Title == nil && r.HTMLVersion == nil && r.LoginForm == nil &&
+		len(r.Headings) == 0 && len(r.LinkCounters) == 0 &&
+		len(r.AddedLinks) == 0 && len(r.RemovedLinks) == 0 && len(r.ChangedLinks) == 0
+}
+
+// Compare reports what changed from the old scan of a page to the new one.
+func Compare(before, after scanner.PageData) Report {
+	return Report{
+		Title:        change(before.Title, after.Title),
+		HTMLVersion:  change(before.HTMLVersion, after.HTMLVersion),
+		LoginForm:    change(before.HasLoginForm, after.HasLoginForm),
+		Headings:     compareHeadings(before.Headings, after.Headings),
+		LinkCounters: compareCounters(before.LinkCounters, after.LinkCounters),
+	}.withLinks(before.HyperLinks, after.HyperLinks)
+}
+
+func compareHeadings(before, after map[string]int) []CountDelta {
+	levels := make(map[string]struct{}, len(before)+len(after))
+	for level := range before {
+		levels[level] = struct{}{}
+	}
+
+	for level := range after {
+		levels[level] = struct{}{}
+	}
+
+	var deltas []CountDelta
+
+	for level := range levels {
+		deltas = appendDelta(deltas, level, int64(before[level]), int64(after[level]))
+	}
+
+	// h1 to h6 sort fine as strings
+	sort.Slice(deltas, func(i, j int) bool {
+		return deltas[i].Name < deltas[j].Name
+	})
+
+	return deltas
+}
+
+func compareCounters(before, after scanner.LinkCounters) []CountDelta {
+	var deltas []CountDelta
+
+	deltas = appendDelta(deltas, "internal", before.Internal, after.Internal)
+	deltas = appendDelta(deltas, "internal_alive", before.InternalAlive, after.InternalAlive)
+	deltas = appendDelta(deltas, "same_origin", before.SameOrigin, after.SameOrigin)
+	deltas = appendDelta(deltas, "same_origin_alive", before.SameOriginAlive, after.SameOriginAlive)
+	deltas = appendDelta(deltas, "subdomain", before.Subdomain, after.Subdomain)
+	deltas = appendDelta(deltas, "subdomain_alive", before.SubdomainAlive, after.SubdomainAlive)
+	deltas = appendDelta(deltas, "same_site", before.SameSite, after.SameSite)
+	deltas = appendDelta(deltas, "same_site_alive", before.SameSiteAlive, after.SameSiteAlive)
+	deltas = appendDelta(deltas, "external", before.External, after.External)
+	deltas = appendDelta(deltas, "external_alive", before.ExternalAlive, after.ExternalAlive)
+	deltas = appendDelta(deltas, "protocol", before.Protocol, after.Protocol)
+
+	var schemes []string
+
+	for scheme := range before.Protocols {
+		schemes = append(schemes, scheme)
+	}
+
+	for scheme := range after.Protocols {
+		if _, ok := before.Protocols[scheme]; !ok {
+			schemes = append(schemes, scheme)
+		}
+	}
+
+	slices.Sort(schemes)
+
+	for _, scheme := range schemes {
+		deltas = appendDelta(deltas, "protocols."+scheme, before.Protocols[scheme], after.Protocols[scheme])
+	}
+
+	return deltas
+}
+
+func appendDelta(deltas []CountDelta, name string, before, after int64) []CountDelta {
+	if before == after {
+		return deltas
+	}
+
+	return append(deltas, CountDelta{Name: name, Old: before, New: after, Delta: after - before})
+}
+
+// withLinks fills in the added, removed and changed links.
+func (r Report) withLinks(before, after []parser.HyperLink) Report {
+	beforeByURL := make(map[string]parser.HyperLink, len(before))
+	for _, l := range before {
+		beforeByURL[linkKey(l)] = l
+	}
+
+	afterByURL := make(map[string]parser.HyperLink, len(after))
+	for _, l := range after {
+		afterByURL[linkKey(l)] = l
+	}
+
+	for key, l := range afterByURL {
+		prev, ok := beforeByURL[key]
+
+		switch {
+		case !ok:
+			r.AddedLinks = append(r.AddedLinks, newLink(l))
+		case prev.StatusCode != l.StatusCode || prev.Failure != l.Failure:
+			r.ChangedLinks = append(r.ChangedLinks, LinkChange{URL: key, Old: newLink(prev), New: newLink(l)})
+		}
+	}
+
+	for key, l := range beforeByURL {
+		if _, ok := afterByURL[key]; !ok {
+			r.RemovedLinks = append(r.RemovedLinks, newLink(l))
+		}
+	}
+
+	sortLinks(r.AddedLinks)
+	sortLinks(r.RemovedLinks)
+
+	sort.Slice(r.ChangedLinks, func(i, j int) bool {
+		return r.ChangedLinks[i].URL < r.ChangedLinks[j].URL
+	})
+
+	return r
+}
+
+func sortLinks(links []Link) {
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].URL < links[j].URL
+	})
+}
+
+// linkKey identifies a link across scans, links that could not be resolved go by what the page says.
+func linkKey(l parser.HyperLink) string {
+	if l.Resolved != nil {
+		return l.Resolved.String()
+	}
+
+	return l.Raw
+}
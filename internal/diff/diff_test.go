@@ -0,0 +1,88 @@
+package diff
+
+import (
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"net/url"
+	"testing"
+)
+
+func link(raw string, status int) parser.HyperLink {
+	resolved, _ := url.Parse("https://example.com" + raw)
+
+	return parser.HyperLink{Raw: raw, Resolved: resolved, StatusCode: status}
+}
+
+func TestCompare(t *testing.T) {
+	before := scanner.PageData{
+		Title:        "Old",
+		HTMLVersion:  "HTML5",
+		Headings:     map[string]int{"h1": 1, "h2": 3},
+		LinkCounters: scanner.LinkCounters{SameOrigin: 3, SameOriginAlive: 3, Protocols: map[string]int64{"mailto": 1}},
+		HyperLinks:   []parser.HyperLink{link("/a", 200), link("/b", 200), link("/c", 200)},
+	}
+
+	after := scanner.PageData{
+		Title:        "New",
+		HTMLVersion:  "HTML5",
+		Headings:     map[string]int{"h1": 1, "h3": 2},
+		LinkCounters: scanner.LinkCounters{SameOrigin: 3, SameOriginAlive: 2, Protocols: map[string]int64{"tel": 1}},
+		HyperLinks:   []parser.HyperLink{link("/a", 200), link("/b", 404), link("/d", 200)},
+		HasLoginForm: true,
+	}
+
+	r := Compare(before, after)
+
+	if r.Title == nil || r.Title.Old != "Old" || r.Title.New != "New" {
+		t.Errorf("unexpected title change: %+v", r.Title)
+	}
+
+	if r.HTMLVersion != nil {
+		t.Errorf("expected no HTML version change, got %+v", r.HTMLVersion)
+	}
+
+	if r.LoginForm == nil || r.LoginForm.Old || !r.LoginForm.New {
+		t.Errorf("expected the login form to appear, got %+v", r.LoginForm)
+	}
+
+	if len(r.Headings) != 2 || r.Headings[0] != (CountDelta{Name: "h2", Old: 3, New: 0, Delta: -3}) ||
+		r.Headings[1] != (CountDelta{Name: "h3", Old: 0, New: 2, Delta: 2}) {
+		t.Errorf("unexpected heading deltas: %+v", r.Headings)
+	}
+
+	want := []CountDelta{
+		{Name: "same_origin_alive", Old: 3, New: 2, Delta: -1},
+		{Name: "protocols.mailto", Old: 1, New: 0, Delta: -1},
+		{Name: "protocols.tel", Old: 0, New: 1, Delta: 1},
+	}
+
+	if len(r.LinkCounters) != len(want) {
+		t.Fatalf("unexpected counter deltas: %+v", r.LinkCounters)
+	}
+
+	for i := range want {
+		if r.LinkCounters[i] != want[i] {
+			t.Errorf("counter delta %d: got %+v want %+v", i, r.LinkCounters[i], want[i])
+		}
+	}
+
+	if len(r.AddedLinks) != 1 || r.AddedLinks[0].URL != "https://example.com/d" {
+		t.Errorf("unexpected added links: %+v", r.AddedLinks)
+	}
+
+	if len(r.RemovedLinks) != 1 || r.RemovedLinks[0].URL != "https://example.com/c" {
+		t.Errorf("unexpected removed links: %+v", r.RemovedLinks)
+	}
+
+	if len(r.ChangedLinks) != 1 || !r.ChangedLinks[0].Broke() || r.ChangedLinks[0].New.StatusCode != 404 {
+		t.Errorf("unexpected changed links: %+v", r.ChangedLinks)
+	}
+
+	if r.Empty() {
+		t.Error("expected the report not to be empty")
+	}
+
+	if !Compare(after, after).Empty() {
+		t.Errorf("expected no changes between the same scans, got %+v", Compare(after, after))
+	}
+}
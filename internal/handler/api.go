@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"log"
+	"net/http"
+	"time"
+)
+
+// APIError is the machine-readable error object returned by the JSON API.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// ScanRequest is the body of POST /api/v1/scans.
+//
+// Setting any of the options starts a new scan even if there is a recent one for the URL.
+type ScanRequest struct {
+	URL string `json:"url"`
+	ScanOptions
+}
+
+// JobStatus describes the state of a scan or crawl job in the JSON API.
+type JobStatus struct {
+	ID            string     `json:"id"`
+	Kind          jobs.Kind  `json:"kind"`
+	URL           string     `json:"url"`
+	State         jobs.State `json:"state"`
+	Done          int64      `json:"done"`
+	Total         int64      `json:"total"`
+	Progress      float64    `json:"progress"`
+	QueuePosition int        `json:"queue_position,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	StartedAt     time.Time  `json:"started_at,omitzero"`
+	FinishedAt    time.Time  `json:"finished_at,omitzero"`
+	StatusURL     string     `json:"status_url"`
+	ResultURL     string     `json:"result_url"`
+}
+
+func newJobStatus(job jobs.Job) JobStatus {
+	prefix := "/api/v1/scans/"
+	if job.Kind == jobs.KindCrawl {
+		prefix = "/api/v1/crawls/"
+	}
+
+	return JobStatus{
+		ID:            job.ID,
+		Kind:          job.Kind,
+		URL:           job.URL,
+		State:         job.State,
+		Done:          job.Done,
+		Total:         job.Total,
+		Progress:      job.Progress,
+		QueuePosition: job.QueuePosition,
+		Error:         job.Error,
+		CreatedAt:     job.CreatedAt,
+		StartedAt:     job.StartedAt,
+		FinishedAt:    job.FinishedAt,
+		StatusURL:     prefix + job.ID,
+		ResultURL:     prefix + job.ID + "/result",
+	}
+}
+
+// APIStartScan starts a new scan, or returns the existing one for the same URL.
+//
+// Responds with 202 Accepted while the scan is running and 200 OK if it is already done.
+func (h *Handler) APIStartScan(w http.ResponseWriter, r *http.Request) {
+	var req ScanRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "invalid_body", "Request body must be a JSON object: "+err.Error())
+
+		return
+	}
+
+	job, err := h.startScan(req.URL, req.ScanOptions)
+	if err != nil {
+		respondWithScanError(w, err)
+
+		return
+	}
+
+	status := http.StatusAccepted
+	if job.State == jobs.StateDone {
+		status = http.StatusOK
+	}
+
+	respondWithJSON(w, status, newJobStatus(job))
+}
+
+// APIScanStatus reports the state of a scan.
+func (h *Handler) APIScanStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookupJob(w, r, jobs.KindPage)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newJobStatus(job))
+}
+
+// APIScanResult returns the full PageData of a finished scan.
+func (h *Handler) APIScanResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookupFinishedJob(w, r, jobs.KindPage)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job.Page)
+}
+
+// APICancelJob cancels a running scan or crawl.
+func (h *Handler) APICancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.Jobs.Cancel(r.PathValue("id"))
+
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		respondWithJSONError(w, http.StatusNotFound, "job_not_found", "Job does not exist")
+	case errors.Is(err, jobs.ErrFinished):
+		respondWithJSONError(w, http.StatusConflict, "job_finished", "Job is already finished")
+	default:
+		respondWithJSON(w, http.StatusOK, newJobStatus(job))
+	}
+}
+
+// APILinkCacheStats reports the hits and misses of the shared link cache.
+func (h *Handler) APILinkCacheStats(w http.ResponseWriter, r *http.Request) {
+	if h.LinkCache == nil {
+		respondWithJSONError(w, http.StatusNotFound, "link_cache_disabled", "Link cache is disabled")
+
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, h.LinkCache.Stats())
+}
+
+// APIRateLimiters lists the per-domain rate limiters, for debugging.
+func (h *Handler) APIRateLimiters(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, h.RateLimiter.Limiters())
+}
+
+// lookupJob finds the job referenced by the id path value, or the latest job for the url query parameter,
+// including the ones in the history, writing an error response if there is none.
+func (h *Handler) lookupJob(w http.ResponseWriter, r *http.Request, kind jobs.Kind) (jobs.Job, bool) {
+	var (
+		job jobs.Job
+		ok  bool
+	)
+
+	if id := r.PathValue("id"); id != "" {
+		job, ok = h.getJob(id)
+		ok = ok && job.Kind == kind
+	} else {
+		targetURL := r.URL.Query().Get("url")
+
+		_, err := parseTargetURL(targetURL)
+		if err != nil {
+			respondWithScanError(w, err)
+
+			return jobs.Job{}, false
+		}
+
+		job, ok = h.latestJob(kind, targetURL)
+	}
+
+	if !ok {
+		respondWithJSONError(w, http.StatusNotFound, "job_not_found", "Job does not exist")
+
+		return jobs.Job{}, false
+	}
+
+	return job, true
+}
+
+// lookupFinishedJob is lookupJob that also requires the job to be done.
+func (h *Handler) lookupFinishedJob(w http.ResponseWriter, r *http.Request, kind jobs.Kind) (jobs.Job, bool) {
+	job, ok := h.lookupJob(w, r, kind)
+	if !ok {
+		return jobs.Job{}, false
+	}
+
+	switch job.State {
+	case jobs.StateDone:
+		return job, true
+	case jobs.StateFailed:
+		respondWithJSONError(w, http.StatusBadGateway, "job_failed", job.Error)
+	case jobs.StateCancelled:
+		respondWithJSONError(w, http.StatusConflict, "job_cancelled", job.Error)
+	default:
+		respondWithJSONError(w, http.StatusConflict, "job_in_progress", "Job is not finished yet.")
+	}
+
+	return jobs.Job{}, false
+}
+
+func respondWithJSON(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+// respondWithJSONError is the JSON API counterpart of respondWithError.
+func respondWithJSONError(w http.ResponseWriter, statusCode int, code, message string) {
+	respondWithJSON(w, statusCode, apiErrorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+func respondWithScanError(w http.ResponseWriter, err error) {
+	var sErr *scanError
+	if errors.As(err, &sErr) {
+		respondWithJSONError(w, sErr.Status, sErr.Code, sErr.Message)
+
+		return
+	}
+
+	respondWithJSONError(w, http.StatusInternalServerError, "internal_error", err.Error())
+}
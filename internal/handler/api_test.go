@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIStartScan_InvalidURL(t *testing.T) {
+	h := &Handler{
+		Jobs: jobs.NewManager(time.Minute*1, 0),
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/scans", strings.NewReader(`{"url":"ftp://example.com"}`))
+	rr := httptest.NewRecorder()
+	h.APIStartScan(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	var body apiErrorResponse
+
+	err := json.NewDecoder(rr.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+
+	if body.Error.Code != "unsupported_scheme" {
+		t.Errorf("unexpected error code: got %q want %q", body.Error.Code, "unsupported_scheme")
+	}
+}
+
+func TestAPIScan_WithMockServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><head><title>Test</title></head><body><a href="/page2">Link</a></body></html>`)
+	}))
+	defer server.Close()
+
+	h := &Handler{
+		Client:      server.Client(),
+		Jobs:        jobs.NewManager(time.Minute*1, 0),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(10), 1),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/scans", h.APIStartScan)
+	mux.HandleFunc("GET /api/v1/scans/{id}", h.APIScanStatus)
+	mux.HandleFunc("GET /api/v1/scans/{id}/result", h.APIScanResult)
+
+	req := httptest.NewRequest("POST", "/api/v1/scans", strings.NewReader(`{"url":"`+server.URL+`"}`))
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+
+	var status JobStatus
+
+	err := json.NewDecoder(rr.Body).Decode(&status)
+	if err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+
+	if status.ID == "" || status.ResultURL != "/api/v1/scans/"+status.ID+"/result" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	_, err = h.Jobs.Wait(context.Background(), status.ID)
+	if err != nil {
+		t.Fatalf("Failed waiting for the job: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", status.ResultURL, nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, body: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var page scanner.PageData
+
+	err = json.NewDecoder(rr.Body).Decode(&page)
+	if err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+
+	if page.Title != "Test" {
+		t.Errorf("unexpected title: got %q want %q", page.Title, "Test")
+	}
+
+	if len(page.HyperLinks) != 1 || page.HyperLinks[0].Raw != "/page2" {
+		t.Errorf("unexpected hyperlinks: %+v", page.HyperLinks)
+	}
+}
+
+func TestAPIScanResult_Failed(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	h := &Handler{
+		Client: server.Client(),
+		Jobs:   jobs.NewManager(time.Minute*1, 0),
+	}
+
+	job, err := h.startScan(server.URL, ScanOptions{})
+	if err != nil {
+		t.Fatalf("startScan returned an error: %v", err)
+	}
+
+	job, _ = h.Jobs.Wait(context.Background(), job.ID)
+	if job.State != jobs.StateFailed {
+		t.Fatalf("expected job to fail, got %q", job.State)
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/scans/"+job.ID+"/result", nil)
+	req.SetPathValue("id", job.ID)
+	h.APIScanResult(rr, req)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadGateway)
+	}
+}
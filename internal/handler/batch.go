@@ -0,0 +1,347 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/batch"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/robots"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxBatchBody caps the size of a batch request, uploaded files included.
+const maxBatchBody = 4 << 20
+
+// BatchRequest is the body of POST /api/v1/batches if it is a JSON object, a JSON array of URLs works as well.
+type BatchRequest struct {
+	URLs []string `json:"urls"`
+	ScanOptions
+}
+
+// BatchStatus describes a batch and its scans in the JSON API.
+type BatchStatus struct {
+	batch.Batch
+	Counts      batch.Counts `json:"counts"`
+	StatusURL   string       `json:"status_url"`
+	DownloadURL string       `json:"download_url"`
+}
+
+// batchPage is the data of the batch template, Batch is nil for the form alone.
+type batchPage struct {
+	Batch *BatchStatus
+	Error string
+}
+
+// startBatch starts a scan of every URL and groups them in a new batch.
+//
+// URLs that can't be scanned become failed rows instead of failing the whole batch,
+// recent scans are reused like startScan does.
+func (h *Handler) startBatch(urls []string, opts ScanOptions) (BatchStatus, error) {
+	if h.MaxBatchURLs > 0 && len(urls) > h.MaxBatchURLs {
+		return BatchStatus{}, &scanError{Status: http.StatusBadRequest, Code: "too_many_urls", Message: fmt.Sprintf("A batch can have at most %d URLs, got %d.", h.MaxBatchURLs, len(urls))}
+	}
+
+	err := validateScanOptions(opts)
+	if err != nil {
+		return BatchStatus{}, err
+	}
+
+	rows := make([]batch.Row, 0, len(urls))
+
+	for _, u := range urls {
+		job, err := h.startScan(u, opts)
+		if err != nil {
+			rows = append(rows, batch.Row{URL: u, State: jobs.StateFailed, Progress: 100, Error: err.Error()})
+
+			continue
+		}
+
+		rows = append(rows, batch.NewRow(job))
+	}
+
+	b := h.Batches.Add(rows)
+
+	// Scans that finished before the batch was stored were missed by JobFinished
+	for _, r := range b.Rows {
+		if r.JobID == "" || r.State.Finished() {
+			continue
+		}
+
+		job, ok := h.Jobs.Get(r.JobID)
+		if ok && job.State.Finished() {
+			h.Batches.JobFinished(job)
+		}
+	}
+
+	status, _ := h.batchStatus(b.ID)
+
+	return status, nil
+}
+
+// batchStatus returns a batch with the current state of its running scans.
+func (h *Handler) batchStatus(id string) (BatchStatus, bool) {
+	b, ok := h.Batches.Get(id)
+	if !ok {
+		return BatchStatus{}, false
+	}
+
+	for i, r := range b.Rows {
+		if r.JobID == "" || r.State.Finished() {
+			continue
+		}
+
+		job, ok := h.Jobs.Get(r.JobID)
+		if ok {
+			b.Rows[i] = batch.NewRow(job)
+		}
+	}
+
+	return BatchStatus{
+		Batch:       b,
+		Counts:      b.Counts(),
+		StatusURL:   "/api/v1/batches/" + b.ID,
+		DownloadURL: "/api/v1/batches/" + b.ID + "/download",
+	}, true
+}
+
+// readBatch reads the URLs of a batch and its scan options from a request, one of
+//   - a form with a "urls" field of one URL per line and/or an uploaded "file", a list or CSV
+//   - a JSON array of URLs or a BatchRequest
+//   - a text/plain list or text/csv body, with the scan options in the query
+func readBatch(w http.ResponseWriter, r *http.Request) ([]string, ScanOptions, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchBody)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var (
+		urls []string
+		opts = scanOptionsFrom(r.URL.Query())
+		err  error
+	)
+
+	switch mediaType {
+	case "multipart/form-data", "application/x-www-form-urlencoded":
+		urls, opts, err = readBatchForm(r)
+	case "text/csv":
+		urls, err = batch.ParseCSV(r.Body)
+	case "text/plain":
+		urls, err = batch.ParseList(r.Body)
+	default:
+		var body []byte
+
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			break
+		}
+
+		body = bytes.TrimSpace(body)
+
+		if bytes.HasPrefix(body, []byte("[")) {
+			urls, err = batch.ParseJSON(bytes.NewReader(body))
+
+			break
+		}
+
+		var req BatchRequest
+
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			err = fmt.Errorf("reading JSON: %w", err)
+
+			break
+		}
+
+		opts = req.ScanOptions
+		urls, err = batch.Clean(req.URLs)
+	}
+
+	var tooLarge *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &tooLarge):
+		return nil, opts, &scanError{Status: http.StatusRequestEntityTooLarge, Code: "body_too_large", Message: fmt.Sprintf("Batches can be at most %d MiB.", maxBatchBody>>20)}
+	case errors.Is(err, batch.ErrNoURLs):
+		return nil, opts, &scanError{Status: http.StatusBadRequest, Code: "missing_urls", Message: "No URLs given, enter one URL per line or upload a list."}
+	case err != nil:
+		return nil, opts, &scanError{Status: http.StatusBadRequest, Code: "invalid_body", Message: err.Error()}
+	}
+
+	return urls, opts, nil
+}
+
+// readBatchForm reads the URLs of the "urls" field and the uploaded "file" of a form,
+// files are read as CSV if their name ends in .csv.
+func readBatchForm(r *http.Request) ([]string, ScanOptions, error) {
+	err := r.ParseMultipartForm(maxBatchBody)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, ScanOptions{}, err
+	}
+
+	opts := scanOptionsFrom(r.Form)
+
+	var urls []string
+
+	if list := r.FormValue("urls"); strings.TrimSpace(list) != "" {
+		urls, err = batch.ParseList(strings.NewReader(list))
+		if err != nil {
+			return nil, opts, err
+		}
+	}
+
+	file, header, err := r.FormFile("file")
+	if err == nil {
+		defer file.Close()
+
+		var fileURLs []string
+
+		if strings.EqualFold(path.Ext(header.Filename), ".csv") || header.Header.Get("Content-Type") == "text/csv" {
+			fileURLs, err = batch.ParseCSV(file)
+		} else {
+			fileURLs, err = batch.ParseList(file)
+		}
+
+		if err != nil {
+			return nil, opts, fmt.Errorf("%s: %w", header.Filename, err)
+		}
+
+		urls = append(urls, fileURLs...)
+	} else if !errors.Is(err, http.ErrMissingFile) && !errors.Is(err, http.ErrNotMultipart) {
+		return nil, opts, err
+	}
+
+	urls, err = batch.Clean(urls)
+
+	return urls, opts, err
+}
+
+// scanOptionsFrom reads the scan options of a form or query.
+func scanOptionsFrom(values url.Values) ScanOptions {
+	bypassLinkCache, _ := strconv.ParseBool(values.Get("bypass_link_cache"))
+
+	return ScanOptions{
+		BypassLinkCache: bypassLinkCache,
+		Robots:          robots.Mode(values.Get("robots")),
+		CallbackURL:     values.Get("callback_url"),
+	}
+}
+
+// APIStartBatch starts a scan of every URL of a list, see readBatch for the accepted bodies.
+//
+// Responds with 202 Accepted and the new batch.
+func (h *Handler) APIStartBatch(w http.ResponseWriter, r *http.Request) {
+	urls, opts, err := readBatch(w, r)
+	if err != nil {
+		respondWithScanError(w, err)
+
+		return
+	}
+
+	status, err := h.startBatch(urls, opts)
+	if err != nil {
+		respondWithScanError(w, err)
+
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, status)
+}
+
+// APIBatchStatus reports the progress of a batch and a row for every URL.
+func (h *Handler) APIBatchStatus(w http.ResponseWriter, r *http.Request) {
+	status, ok := h.batchStatus(r.PathValue("id"))
+	if !ok {
+		respondWithJSONError(w, http.StatusNotFound, "batch_not_found", "Batch does not exist or has expired")
+
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, status)
+}
+
+// APIDownloadBatch returns the rows of a batch as a CSV file, or the batch as a JSON file with format=json.
+func (h *Handler) APIDownloadBatch(w http.ResponseWriter, r *http.Request) {
+	status, ok := h.batchStatus(r.PathValue("id"))
+	if !ok {
+		respondWithJSONError(w, http.StatusNotFound, "batch_not_found", "Batch does not exist or has expired")
+
+		return
+	}
+
+	filename := "scan24-batch-" + status.ID
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+
+		err := status.WriteCSV(w)
+		if err != nil {
+			log.Printf("Error writing batch %s as CSV: %v", status.ID, err)
+		}
+	case "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		respondWithJSON(w, http.StatusOK, status)
+	default:
+		respondWithJSONError(w, http.StatusBadRequest, "invalid_format", "Format must be csv or json")
+	}
+}
+
+// BatchHandler shows the batch form, or a batch with ?id=.
+func (h *Handler) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		h.renderBatch(w, http.StatusOK, batchPage{})
+
+		return
+	}
+
+	status, ok := h.batchStatus(id)
+	if !ok {
+		h.renderBatch(w, http.StatusNotFound, batchPage{Error: "Batch does not exist or has expired"})
+
+		return
+	}
+
+	h.renderBatch(w, http.StatusOK, batchPage{Batch: &status})
+}
+
+// CreateBatchHandler starts a batch from the form of the batch page.
+func (h *Handler) CreateBatchHandler(w http.ResponseWriter, r *http.Request) {
+	urls, opts, err := readBatch(w, r)
+	if err == nil {
+		var status BatchStatus
+
+		status, err = h.startBatch(urls, opts)
+		if err == nil {
+			http.Redirect(w, r, "/batch?id="+status.ID, http.StatusSeeOther)
+
+			return
+		}
+	}
+
+	var sErr *scanError
+	if errors.As(err, &sErr) {
+		h.renderBatch(w, sErr.Status, batchPage{Error: sErr.Message})
+	} else {
+		h.renderBatch(w, http.StatusInternalServerError, batchPage{Error: err.Error()})
+	}
+}
+
+func (h *Handler) renderBatch(w http.ResponseWriter, status int, page batchPage) {
+	w.WriteHeader(status)
+
+	err := tmplBatch.Execute(w, page)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error executing batch template: %v", err), http.StatusInternalServerError)
+		log.Printf("Error executing batch template: %v", err)
+	}
+}
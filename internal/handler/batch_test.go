@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/batch"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"golang.org/x/time/rate"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIBatches(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `<!DOCTYPE html><html><head><title>Page %s</title></head><body><form><input type="password" autocomplete="current-password"></form></body></html>`, r.URL.Path)
+	}))
+	defer server.Close()
+
+	h := &Handler{
+		Client:       server.Client(),
+		Jobs:         jobs.NewManager(time.Minute, 0),
+		RateLimiter:  ratelimiter.NewDomainRateLimiter(rate.Limit(10), 1),
+		Batches:      batch.NewStore(time.Minute),
+		MaxBatchURLs: 3,
+	}
+	h.Jobs.OnFinish = h.JobFinished
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /batch", h.CreateBatchHandler)
+	mux.HandleFunc("GET /batch", h.BatchHandler)
+	mux.HandleFunc("POST /api/v1/batches", h.APIStartBatch)
+	mux.HandleFunc("GET /api/v1/batches/{id}", h.APIBatchStatus)
+	mux.HandleFunc("GET /api/v1/batches/{id}/download", h.APIDownloadBatch)
+
+	body := fmt.Sprintf(`["%[1]s/a", "%[1]s/b", "ftp://example.com", "%[1]s/a"]`, server.URL)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/batches", strings.NewReader(body)))
+
+	var status BatchStatus
+
+	_ = json.NewDecoder(rr.Body).Decode(&status)
+	if rr.Code != http.StatusAccepted || status.Counts.Total != 3 {
+		t.Fatalf("expected a batch of 3 URLs, got %d %+v", rr.Code, status)
+	}
+
+	for _, r := range status.Rows {
+		if r.JobID != "" {
+			_, _ = h.Jobs.Wait(context.Background(), r.JobID)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", status.StatusURL, nil))
+
+	_ = json.NewDecoder(rr.Body).Decode(&status)
+	if c := status.Counts; c.Done != 2 || c.Failed != 1 || c.Progress != 100 || status.FinishedAt.IsZero() {
+		t.Errorf("expected 2 done and 1 failed scan, got %+v", c)
+	}
+
+	if r := status.Rows[1]; r.Title != "Page /b" || !r.HasLoginForm || r.HTMLVersion == "" {
+		t.Errorf("unexpected row %+v", r)
+	}
+
+	if r := status.Rows[2]; r.State != jobs.StateFailed || r.JobID != "" || r.Error == "" {
+		t.Errorf("expected the ftp URL to be rejected, got %+v", r)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", status.DownloadURL, nil))
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(records) != 4 || records[1][0] != server.URL+"/a" || records[1][1] != "done" {
+		t.Errorf("unexpected CSV download %q: %v", records, err)
+	}
+
+	if !strings.Contains(rr.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("expected the download to be an attachment, got %q", rr.Header().Get("Content-Disposition"))
+	}
+
+	// The form of the batch page, with a CSV upload
+	var form bytes.Buffer
+
+	mw := multipart.NewWriter(&form)
+	_ = mw.WriteField("urls", server.URL+"/c")
+	fw, _ := mw.CreateFormFile("file", "pages.csv")
+	_, _ = fmt.Fprintf(fw, "name,url\nD,%s/d\n", server.URL)
+	_ = mw.Close()
+
+	req := httptest.NewRequest("POST", "/batch", &form)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	location := rr.Header().Get("Location")
+	if rr.Code != http.StatusSeeOther || !strings.HasPrefix(location, "/batch?id=") {
+		t.Fatalf("expected a redirect to the batch, got %d %q", rr.Code, location)
+	}
+
+	created, ok := h.batchStatus(strings.TrimPrefix(location, "/batch?id="))
+	if !ok || created.Counts.Total != 2 || created.Rows[1].URL != server.URL+"/d" {
+		t.Errorf("unexpected batch from the form %+v", created)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", location, nil))
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), server.URL+"/d") {
+		t.Errorf("expected the batch page to list the URLs, got %d", rr.Code)
+	}
+
+	for _, tt := range []struct {
+		body, contentType string
+		status            int
+	}{
+		{"\n\n", "text/plain", http.StatusBadRequest},
+		{"https://a.example\nhttps://b.example\nhttps://c.example\nhttps://d.example", "text/plain", http.StatusBadRequest},
+		{`{"urls": ["https://a.example"], "robots": "sometimes"}`, "application/json", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("POST", "/api/v1/batches", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", tt.contentType)
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%q: handler returned wrong status code: got %v want %v", tt.body, rr.Code, tt.status)
+		}
+	}
+}
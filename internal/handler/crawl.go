@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hugmouse/scan24/internal/crawler"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"net/http"
+	"net/url"
+)
+
+// CrawlRequest is the body of POST /api/v1/crawls.
+type CrawlRequest struct {
+	URL string `json:"url"`
+	crawler.Options
+	ScanOptions
+}
+
+// APIStartCrawl starts crawling a site in the background.
+func (h *Handler) APIStartCrawl(w http.ResponseWriter, r *http.Request) {
+	// Fields that are not in the body keep their defaults, a max_depth of 0 crawls the start page only
+	req := CrawlRequest{Options: crawler.DefaultOptions}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "invalid_body", "Request body must be a JSON object: "+err.Error())
+
+		return
+	}
+
+	startURL, err := parseTargetURL(req.URL)
+	if err != nil {
+		respondWithScanError(w, err)
+
+		return
+	}
+
+	err = validateScanOptions(req.ScanOptions)
+	if err != nil {
+		respondWithScanError(w, err)
+
+		return
+	}
+
+	c, err := crawler.New(h.scanner(req.ScanOptions), req.Options)
+	if err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "invalid_options", err.Error())
+
+		return
+	}
+
+	job := h.Jobs.Start(jobs.KindCrawl, req.URL, func(ctx context.Context, t *jobs.Tracker) error {
+		h.registerCallback(t, req.ScanOptions)
+
+		return h.doCrawl(ctx, t, c, startURL)
+	})
+
+	respondWithJSON(w, http.StatusAccepted, newJobStatus(job))
+}
+
+func (h *Handler) doCrawl(ctx context.Context, t *jobs.Tracker, c *crawler.Crawler, startURL *url.URL) error {
+	t.SetState(jobs.StateFetching)
+
+	report, err := c.Crawl(ctx, startURL, func(crawled, queued int) {
+		t.SetState(jobs.StateCheckingLinks)
+		t.SetProgress(int64(crawled), int64(crawled+queued))
+	})
+	if err != nil {
+		return err
+	}
+
+	t.SetSite(&report)
+
+	return nil
+}
+
+// APICrawlStatus reports the state of a crawl.
+func (h *Handler) APICrawlStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookupJob(w, r, jobs.KindCrawl)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newJobStatus(job))
+}
+
+// APICrawlResult returns the site report of a finished crawl.
+func (h *Handler) APICrawlResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookupFinishedJob(w, r, jobs.KindCrawl)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job.Site)
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/diff"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/storage"
+	"log"
+	"net/http"
+	"time"
+)
+
+// DiffScan identifies one of the scans of a DiffResult.
+type DiffScan struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Title      string    `json:"title"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+func newDiffScan(job jobs.Job) DiffScan {
+	return DiffScan{ID: job.ID, URL: job.URL, Title: job.Page.Title, FinishedAt: job.FinishedAt}
+}
+
+// DiffResult is what changed from one scan to another, the body of GET /api/v1/diff.
+type DiffResult struct {
+	From    DiffScan `json:"from"`
+	To      DiffScan `json:"to"`
+	Changed bool     `json:"changed"`
+	diff.Report
+}
+
+// DiffHandler shows what changed between the scans given by the from and to query parameters,
+// or between the two latest scans of the url query parameter.
+func (h *Handler) DiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed. Use GET.")
+
+		return
+	}
+
+	result, err := h.diff(r)
+	if err != nil {
+		var sErr *scanError
+		if errors.As(err, &sErr) {
+			respondWithError(w, sErr.Status, sErr.Message)
+		} else {
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+
+		return
+	}
+
+	err = tmplDiff.Execute(w, result)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error executing diff template: %v", err), http.StatusInternalServerError)
+		log.Printf("Error executing diff template: %v", err)
+	}
+}
+
+// APIDiff is the JSON counterpart of DiffHandler.
+func (h *Handler) APIDiff(w http.ResponseWriter, r *http.Request) {
+	result, err := h.diff(r)
+	if err != nil {
+		respondWithScanError(w, err)
+
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// diff compares the scans that the request asks for.
+func (h *Handler) diff(r *http.Request) (DiffResult, error) {
+	query := r.URL.Query()
+
+	var (
+		from, to jobs.Job
+		err      error
+	)
+
+	switch fromID, toID := query.Get("from"), query.Get("to"); {
+	case fromID != "" && toID != "":
+		from, err = h.diffJob(fromID)
+		if err == nil {
+			to, err = h.diffJob(toID)
+		}
+	case query.Get("url") != "":
+		from, to, err = h.latestScans(query.Get("url"))
+	default:
+		return DiffResult{}, &scanError{Status: http.StatusBadRequest, Code: "missing_scans", Message: "Pass the from and to scan IDs, or a url to compare its two latest scans."}
+	}
+
+	if err != nil {
+		return DiffResult{}, err
+	}
+
+	report := diff.Compare(from.Page, to.Page)
+
+	return DiffResult{From: newDiffScan(from), To: newDiffScan(to), Changed: !report.Empty(), Report: report}, nil
+}
+
+// diffJob looks up a finished page scan that can be compared.
+func (h *Handler) diffJob(id string) (jobs.Job, error) {
+	job, ok := h.getJob(id)
+	if !ok || job.Kind != jobs.KindPage {
+		return jobs.Job{}, &scanError{Status: http.StatusNotFound, Code: "job_not_found", Message: "Job does not exist: " + id}
+	}
+
+	if job.State != jobs.StateDone {
+		return jobs.Job{}, &scanError{Status: http.StatusConflict, Code: "job_not_done", Message: "Job has no result to compare: " + id}
+	}
+
+	return job, nil
+}
+
+// latestScans returns the two latest scans of targetURL that are done, older one first.
+func (h *Handler) latestScans(targetURL string) (jobs.Job, jobs.Job, error) {
+	_, err := parseTargetURL(targetURL)
+	if err != nil {
+		return jobs.Job{}, jobs.Job{}, err
+	}
+
+	if h.History == nil {
+		return jobs.Job{}, jobs.Job{}, &scanError{Status: http.StatusNotFound, Code: "history_disabled", Message: "Scan history is disabled, pass the from and to scan IDs instead."}
+	}
+
+	scans, err := h.History.List(storage.Query{URL: targetURL, Kind: jobs.KindPage, Limit: 2})
+	if err != nil {
+		return jobs.Job{}, jobs.Job{}, &scanError{Status: http.StatusInternalServerError, Code: "history_error", Message: err.Error()}
+	}
+
+	if len(scans) < 2 {
+		return jobs.Job{}, jobs.Job{}, &scanError{Status: http.StatusNotFound, Code: "not_enough_scans", Message: "We need two scans of the following URL to compare them: " + targetURL}
+	}
+
+	from, err := h.diffJob(scans[1].ID)
+	if err != nil {
+		return jobs.Job{}, jobs.Job{}, err
+	}
+
+	to, err := h.diffJob(scans[0].ID)
+	if err != nil {
+		return jobs.Job{}, jobs.Job{}, err
+	}
+
+	return from, to, nil
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIDiff(t *testing.T) {
+	h := &Handler{
+		Jobs: jobs.NewManager(time.Minute*1, 0),
+	}
+
+	scan := func(page scanner.PageData) jobs.Job {
+		job := h.Jobs.Start(jobs.KindPage, "https://example.com", func(ctx context.Context, tr *jobs.Tracker) error {
+			tr.SetPage(page)
+
+			return nil
+		})
+
+		job, _ = h.Jobs.Wait(context.Background(), job.ID)
+
+		return job
+	}
+
+	from := scan(scanner.PageData{Title: "Before", HyperLinks: []parser.HyperLink{{Raw: "/a", StatusCode: 200}}})
+	to := scan(scanner.PageData{Title: "After", HyperLinks: []parser.HyperLink{{Raw: "/a", StatusCode: 404}}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/diff", h.DiffHandler)
+	mux.HandleFunc("GET /api/v1/diff", h.APIDiff)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/diff?from="+from.ID+"&to="+to.ID, nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v, body: %s", rr.Code, http.StatusOK, rr.Body.String())
+	}
+
+	var result DiffResult
+
+	err := json.NewDecoder(rr.Body).Decode(&result)
+	if err != nil {
+		t.Fatalf("Failed to decode diff: %v", err)
+	}
+
+	if !result.Changed || result.From.ID != from.ID || result.To.ID != to.ID {
+		t.Errorf("unexpected diff: %+v", result)
+	}
+
+	if result.Title == nil || result.Title.New != "After" {
+		t.Errorf("unexpected title change: %+v", result.Title)
+	}
+
+	if len(result.ChangedLinks) != 1 || result.ChangedLinks[0].Old.StatusCode != 200 || result.ChangedLinks[0].New.StatusCode != 404 {
+		t.Errorf("unexpected changed links: %+v", result.ChangedLinks)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/diff?from="+from.ID+"&to="+to.ID, nil))
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Broke") {
+		t.Errorf("expected the diff page to show the broken link, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/diff?from="+from.ID+"&to=unknown", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/diff", nil))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/parser"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// eventDone is the last event of every stream, it is sent once the job is finished.
+const eventDone jobs.EventType = "done"
+
+// heartbeatInterval keeps idle streams from being closed by proxies.
+const heartbeatInterval = 15 * time.Second
+
+// eventWriter writes a job event as one or more Server-Sent Events.
+type eventWriter func(w io.Writer, ev jobs.Event) error
+
+// JobEvents handles GET /jobs/{id}/events, it streams HTML fragments for the htmx sse extension.
+//
+// "progress" events carry the progress bar, "link" events a table row of the link that was
+// just checked and the final "done" event the URL of the result page.
+func (h *Handler) JobEvents(w http.ResponseWriter, r *http.Request) {
+	h.streamJob(w, r, func(w io.Writer, ev jobs.Event) error {
+		if ev.Type == eventDone {
+			return writeSSE(w, string(eventDone), "/result?id="+ev.Job.ID)
+		}
+
+		var buf bytes.Buffer
+
+		err := tmplProgress.ExecuteTemplate(&buf, "job-progress", ev.Job)
+		if err != nil {
+			return err
+		}
+
+		err = writeSSE(w, "progress", buf.String())
+		if err != nil || ev.Link == nil {
+			return err
+		}
+
+		buf.Reset()
+
+		err = tmplProgress.ExecuteTemplate(&buf, "job-link", ev.Link)
+		if err != nil {
+			return err
+		}
+
+		return writeSSE(w, "link", buf.String())
+	})
+}
+
+// JobEvent is the data of a Server-Sent Event of the JSON API.
+type JobEvent struct {
+	Type      jobs.EventType    `json:"type"`
+	Job       JobStatus         `json:"job"`
+	Link      *parser.HyperLink `json:"link,omitempty"`
+	ResultURL string            `json:"result_url,omitempty"` // only set for the "done" event
+}
+
+// APIJobEvents streams events of a scan or crawl as JSON encoded Server-Sent Events.
+func (h *Handler) APIJobEvents(w http.ResponseWriter, r *http.Request) {
+	h.streamJob(w, r, func(w io.Writer, ev jobs.Event) error {
+		event := JobEvent{
+			Type: ev.Type,
+			Job:  newJobStatus(ev.Job),
+			Link: ev.Link,
+		}
+
+		if ev.Type == eventDone {
+			event.ResultURL = event.Job.ResultURL
+		}
+
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		return writeSSE(w, string(ev.Type), string(data))
+	})
+}
+
+// streamJob sends the current state of the job referenced by the id path value,
+// then every event of the job until it is finished or the client goes away.
+func (h *Handler) streamJob(w http.ResponseWriter, r *http.Request, write eventWriter) {
+	id := r.PathValue("id")
+
+	events, unsubscribe, err := h.Jobs.Subscribe(id)
+	if err != nil {
+		http.Error(w, "Job does not exist", http.StatusNotFound)
+
+		return
+	}
+	defer unsubscribe()
+
+	job, ok := h.Jobs.Get(id)
+	if !ok {
+		http.Error(w, "Job does not exist", http.StatusNotFound)
+
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev jobs.Event) bool {
+		err := write(w, ev)
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			log.Printf("[job %s] Could not send event: %v", id, err)
+
+			return false
+		}
+
+		return true
+	}
+
+	if !send(jobs.Event{Type: jobs.EventProgress, Job: job}) {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				job, _ = h.Jobs.Get(id)
+				send(jobs.Event{Type: eventDone, Job: job})
+
+				return
+			}
+
+			if !send(ev) {
+				return
+			}
+		case <-heartbeat.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			if err == nil {
+				err = rc.Flush()
+			}
+
+			if err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSE writes a single named event, every line of data gets its own data field.
+func writeSSE(w io.Writer, name, data string) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "event: %s\n", name)
+
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", strings.TrimSuffix(line, "\r"))
+	}
+
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/parser"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIJobEvents(t *testing.T) {
+	h := &Handler{
+		Jobs: jobs.NewManager(time.Minute*1, 0),
+	}
+
+	release := make(chan struct{})
+	job := h.Jobs.Start(jobs.KindPage, "https://example.com", func(ctx context.Context, t *jobs.Tracker) error {
+		<-release
+
+		t.SetState(jobs.StateCheckingLinks)
+		t.LinkChecked(parser.HyperLink{Raw: "/missing", StatusCode: http.StatusNotFound}, 1, 1)
+
+		return nil
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/scans/{id}/events", h.APIJobEvents)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/scans/" + job.ID + "/events")
+	if err != nil {
+		t.Fatalf("Failed to connect to the event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type: %q", ct)
+	}
+
+	close(release)
+
+	var events []JobEvent
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var ev JobEvent
+
+		err := json.Unmarshal([]byte(data), &ev)
+		if err != nil {
+			t.Fatalf("Failed to decode event %q: %v", data, err)
+		}
+
+		events = append(events, ev)
+	}
+
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d: %+v", len(events), events)
+	}
+
+	if link := events[2]; link.Type != jobs.EventLink || link.Link == nil || link.Link.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected link event: %+v", link)
+	}
+
+	done := events[3]
+	if done.Type != eventDone || done.Job.State != jobs.StateDone || done.ResultURL != "/api/v1/scans/"+job.ID+"/result" {
+		t.Errorf("unexpected done event: %+v", done)
+	}
+}
+
+func TestAPIJobEvents_NotFound(t *testing.T) {
+	h := &Handler{
+		Jobs: jobs.NewManager(time.Minute*1, 0),
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/scans/missing/events", nil)
+	req.SetPathValue("id", "missing")
+
+	rr := httptest.NewRecorder()
+	h.APIJobEvents(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestWriteSSE_Multiline(t *testing.T) {
+	var b strings.Builder
+
+	err := writeSSE(&b, "progress", "<div>\n</div>")
+	if err != nil {
+		t.Fatalf("writeSSE returned an error: %v", err)
+	}
+
+	want := "event: progress\ndata: <div>\ndata: </div>\n\n"
+	if b.String() != want {
+		t.Errorf("unexpected event: got %q want %q", b.String(), want)
+	}
+}
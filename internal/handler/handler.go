@@ -1,62 +1,72 @@
 package handler
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"github.com/PuerkitoBio/goquery"
-	"github.com/hugmouse/scan24/internal/cache"
+	"github.com/hugmouse/scan24/internal/batch"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/linkcache"
+	"github.com/hugmouse/scan24/internal/monitor"
 	"github.com/hugmouse/scan24/internal/parser"
 	"github.com/hugmouse/scan24/internal/ratelimiter"
-	"golang.org/x/net/html/charset"
+	"github.com/hugmouse/scan24/internal/robots"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"github.com/hugmouse/scan24/internal/scanopts"
+	"github.com/hugmouse/scan24/internal/storage"
+	"github.com/hugmouse/scan24/internal/webhook"
+	"github.com/hugmouse/scan24/internal/workerpool"
 	"html/template"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
 	"sync"
-	"sync/atomic"
 )
 
-type LinkCounters struct {
-	Internal      int64
-	InternalAlive int64
-	External      int64
-	ExternalAlive int64
-	Protocol      int64
-}
-
-type PageData struct {
-	URL             string
-	Title           string
-	HTMLVersion     string
-	Headings        map[string]int
-	LinkCounters    LinkCounters
-	HyperLinks      []parser.HyperLink
-	HasLoginForm    bool
-	SiteInformation string
-	Error           string
-}
-
-type GlobalMapData struct {
-	Page     PageData
-	URL      string
-	Progress float64
-	Error    string
-}
-
 var (
 	tmplIndex    *template.Template
 	tmplResult   *template.Template
 	tmplProgress *template.Template
 	tmplError    *template.Template
+	tmplHistory  *template.Template
+	tmplDiff     *template.Template
+	tmplMonitors *template.Template
+	tmplBatch    *template.Template
 )
 
 type Handler struct {
-	Client      *http.Client
-	RateLimit   int
-	Cache       *cache.Cache[string, GlobalMapData]
-	RateLimiter *ratelimiter.DomainRateLimiter
+	Client         *http.Client
+	RateLimit      int
+	Jobs           *jobs.Manager
+	RateLimiter    *ratelimiter.DomainRateLimiter
+	Pool           *workerpool.Pool
+	JobConcurrency int
+	// LongRedirectChain is the number of redirects after which a link is flagged.
+	LongRedirectChain int
+	// LinkCache is shared by all scans, nil disables it.
+	LinkCache *linkcache.Cache
+	// SkipSPAFragments turns off the anchor check of client-side routes like #!/page.
+	SkipSPAFragments bool
+	// MaxRetries is how often a throttled link is checked again.
+	MaxRetries int
+	// Robots is shared by all scans, RobotsMode is what they do with robots.txt unless they ask otherwise.
+	Robots     *robots.Fetcher
+	RobotsMode robots.Mode
+	// History keeps finished jobs after the job manager forgot them, nil disables it.
+	History storage.Store
+	// Monitors runs the scheduled scans.
+	Monitors *monitor.Scheduler
+	// Webhooks sends finished jobs and monitor alerts, nil disables webhooks and callback URLs.
+	Webhooks *webhook.Dispatcher
+	// PublicURL is prepended to the result links of webhooks, like "https://scan24.example.com".
+	PublicURL string
+	// Batches groups the scans started from a list of URLs, MaxBatchURLs limits the length of the list (0 for no limit).
+	Batches      *batch.Store
+	MaxBatchURLs int
+
+	// callbacks holds the callback URLs of running jobs by job ID
+	callbacks sync.Map
 }
 
 func (h *Handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
@@ -80,34 +90,20 @@ func (h *Handler) ResultHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	targetURL := r.URL.Query().Get("url")
-	if targetURL == "" {
-		respondWithError(w, http.StatusBadRequest, "URL parameter is missing.")
-
-		return
-	}
-
-	baseURL, err := url.ParseRequestURI(targetURL)
+	job, err := h.findJob(r)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid URL provided: %v", err))
+		respondWithError(w, http.StatusBadRequest, err.Error())
 
 		return
 	}
 
-	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
-		respondWithError(w, http.StatusBadRequest, "Only HTTP/HTTPS links are allowed. For example: https://mysh.dev")
+	if job.State != jobs.StateDone {
+		respondWithError(w, http.StatusBadRequest, "We don't have scan results for the following URL: "+job.URL)
 
 		return
 	}
 
-	val, ok := h.Cache.Get(targetURL)
-	if !ok {
-		respondWithError(w, http.StatusBadRequest, "We don't have scan results for the following URL: "+baseURL.String())
-
-		return
-	}
-
-	err = tmplResult.Execute(w, val)
+	err = tmplResult.Execute(w, job)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error executing result template: %v", err), http.StatusInternalServerError)
 		log.Printf("Error executing result template: %v", err)
@@ -121,240 +117,215 @@ func (h *Handler) AnalyzeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	targetURL := r.URL.Query().Get("url")
-	if targetURL == "" {
-		respondWithError(w, http.StatusBadRequest, "URL parameter is missing.")
-
-		return
-	}
+	query := r.URL.Query()
+	bypassLinkCache, _ := strconv.ParseBool(query.Get("bypass_link_cache"))
 
-	baseURL, err := url.ParseRequestURI(targetURL)
+	job, err := h.startScan(query.Get("url"), ScanOptions{
+		BypassLinkCache: bypassLinkCache,
+		Robots:          robots.Mode(query.Get("robots")),
+		CallbackURL:     query.Get("callback_url"),
+	})
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid URL provided: %v", err))
-
-		return
-	}
-
-	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
-		respondWithError(w, http.StatusBadRequest, "Only HTTP/HTTPS links are allowed. For example: https://mysh.dev")
-
-		return
-	}
-
-	val, ok := h.Cache.Get(targetURL)
-	if ok {
-		var err error
-		if val.Progress == 100 {
-			err = tmplResult.Execute(w, val)
+		var sErr *scanError
+		if errors.As(err, &sErr) {
+			respondWithError(w, sErr.Status, sErr.Message)
 		} else {
-			err = tmplProgress.Execute(w, val)
+			respondWithError(w, http.StatusInternalServerError, err.Error())
 		}
 
-		if err != nil {
-			http.Error(w, fmt.Sprintf("Error executing result template: %v", err), http.StatusInternalServerError)
-			log.Printf("Error executing result template: %v", err)
-		}
 		return
 	}
 
-	resp, err := h.Client.Get(targetURL)
-	if err != nil {
-		respondWithError(w, http.StatusBadGateway, fmt.Sprintf("Failed to fetch URL: %v", err))
-
-		return
-	}
-	defer resp.Body.Close()
+	h.renderJob(w, job)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		respondWithError(w, http.StatusBadGateway, fmt.Sprintf("URL that you provided failed to load, code: %d", resp.StatusCode))
+// renderJob shows the result of a finished job and the progress bar otherwise.
+func (h *Handler) renderJob(w http.ResponseWriter, job jobs.Job) {
+	if job.State != jobs.StateDone {
+		h.renderProgress(w, job)
 
 		return
 	}
 
-	ct := resp.Header.Get("Content-Type")
-
-	reader, err := charset.NewReader(resp.Body, ct)
+	err := tmplResult.Execute(w, job)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create charset reader: %v", err))
-
-		return
+		http.Error(w, fmt.Sprintf("Error executing result template: %v", err), http.StatusInternalServerError)
+		log.Printf("Error executing result template: %v", err)
 	}
+}
 
-	bodyBytes, err := io.ReadAll(reader)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to read response body: %v", err))
+// findJob looks up a page job by the id query parameter,
+// or the latest job for the url query parameter, including the ones in the history.
+func (h *Handler) findJob(r *http.Request) (jobs.Job, error) {
+	if id := r.URL.Query().Get("id"); id != "" {
+		job, ok := h.getJob(id)
+		if !ok || job.Kind != jobs.KindPage {
+			return jobs.Job{}, errors.New("Job does not exist")
+		}
 
-		return
+		return job, nil
 	}
 
-	goqueryReader := bytes.NewReader(bodyBytes)
+	targetURL := r.URL.Query().Get("url")
 
-	doc, err := goquery.NewDocumentFromReader(goqueryReader)
+	baseURL, err := parseTargetURL(targetURL)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to parse HTML: %v", err))
+		return jobs.Job{}, err
+	}
 
-		return
+	job, ok := h.latestJob(jobs.KindPage, targetURL)
+	if !ok {
+		return jobs.Job{}, errors.New("We don't have scan results for the following URL: " + baseURL.String())
 	}
 
-	// Start job, return status
-	go h.doJob(doc, bodyBytes, baseURL, targetURL)
+	return job, nil
+}
 
-	h.Cache.Set(targetURL, GlobalMapData{
-		Page:     PageData{},
-		URL:      targetURL,
-		Progress: 0.0,
-	})
+// scanError describes why a scan could not be started.
+//
+// Status is the HTTP status code to respond with, Code is a stable
+// machine-readable identifier used by the JSON API.
+type scanError struct {
+	Status  int
+	Code    string
+	Message string
+}
 
-	err = tmplProgress.Execute(w, GlobalMapData{
-		Page:     PageData{},
-		URL:      targetURL,
-		Progress: 0.0,
-	})
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error executing result template: %v", err), http.StatusInternalServerError)
-		log.Printf("Error executing result template: %v", err)
-	}
+func (e *scanError) Error() string {
+	return e.Message
 }
 
-func (h *Handler) doJob(doc *goquery.Document, bodyBytes []byte, baseURL *url.URL, targetURL string) {
-	htmlVersion, err := parser.GetHTMLVersion(string(bodyBytes))
+// parseTargetURL validates a user-supplied URL, only absolute HTTP(S) URLs are accepted.
+func parseTargetURL(targetURL string) (*url.URL, error) {
+	if targetURL == "" {
+		return nil, &scanError{Status: http.StatusBadRequest, Code: "missing_url", Message: "URL parameter is missing."}
+	}
+
+	baseURL, err := url.ParseRequestURI(targetURL)
 	if err != nil {
-		log.Printf("Could not determine HTML version: %v", err)
+		return nil, &scanError{Status: http.StatusBadRequest, Code: "invalid_url", Message: fmt.Sprintf("Invalid URL provided: %v", err)}
+	}
 
-		htmlVersion = &parser.DoctypeNode{Name: "Unknown"}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, &scanError{Status: http.StatusBadRequest, Code: "unsupported_scheme", Message: "Only HTTP/HTTPS links are allowed. For example: https://mysh.dev"}
 	}
 
-	title := getTitle(doc)
+	return baseURL, nil
+}
 
-	headings := make(map[string]int, 6)
-	for _, tag := range []string{"h1", "h2", "h3", "h4", "h5", "h6"} {
-		headings[tag] = doc.Find(tag).Length()
-	}
+// ScanOptions are the settings a single scan or crawl can change, Robots overrides Handler.RobotsMode.
+type ScanOptions = scanopts.Options
 
-	links := make([]parser.HyperLink, 0)
-
-	var (
-		jobCounter      int64
-		jobDone         int64
-		externalCounter int64
-		externalAlive   int64
-		internalCounter int64
-		internalAlive   int64
-		protocolCounter int64
-	)
-
-	var (
-		wg     sync.WaitGroup
-		linkMu sync.Mutex
-	)
-
-	// Essentially just run a goroutine for every <a> with a valid href value
-	//
-	// This also runs for href that = "#!" or "./" and etc since there might
-	// be a custom HTTP server that renders something different on those URLs
-	doc.Find("a").Each(func(_ int, s *goquery.Selection) {
-		attr, exists := s.Attr("href")
-		if !exists {
-			return
-		}
+// validateScanOptions checks the options given by the user.
+func validateScanOptions(o ScanOptions) error {
+	err := o.Validate()
 
-		wg.Add(1)
-		atomic.AddInt64(&jobCounter, 1)
+	switch {
+	case errors.Is(err, robots.ErrInvalidMode):
+		return &scanError{Status: http.StatusBadRequest, Code: "invalid_robots_mode", Message: err.Error()}
+	case errors.Is(err, scanopts.ErrInvalidCallbackURL):
+		return &scanError{Status: http.StatusBadRequest, Code: "invalid_callback_url", Message: "Callback URL must be an HTTP/HTTPS URL. For example: https://example.com/hooks/scan24"}
+	}
 
-		go func(attr string) {
-			defer wg.Done()
+	return err
+}
 
-			u, err := url.Parse(attr)
-			if err != nil {
-				log.Printf("could not parse url %s: %v", attr, err)
-				return
-			}
+// startScan starts analyzing targetURL in the background.
+//
+// If there is already a job for targetURL that did not fail, it is returned as is,
+// unless opts ask for something else than the defaults.
+func (h *Handler) startScan(targetURL string, opts ScanOptions) (jobs.Job, error) {
+	baseURL, err := parseTargetURL(targetURL)
+	if err != nil {
+		return jobs.Job{}, err
+	}
 
-			// Use the domain of the link to get a rate limiter
-			limiter := h.RateLimiter.GetLimiter(u.Hostname())
-			err = limiter.Wait(context.Background())
-			if err != nil {
-				log.Printf("rate limiter wait error: %v", err)
-				return
-			}
+	err = validateScanOptions(opts)
+	if err != nil {
+		return jobs.Job{}, err
+	}
 
-			log.Printf("[%s] Checking out: %s", baseURL, attr)
+	job, ok := h.Jobs.Latest(jobs.KindPage, targetURL)
+	if ok && opts == (ScanOptions{}) && job.State != jobs.StateFailed && job.State != jobs.StateCancelled {
+		return job, nil
+	}
 
-			link := parser.Analyze(attr, baseURL, h.Client)
+	return h.Jobs.Start(jobs.KindPage, targetURL, func(ctx context.Context, t *jobs.Tracker) error {
+		return h.doJob(ctx, t, baseURL, opts)
+	}), nil
+}
 
-			switch link.HrefType {
-			case "external":
-				atomic.AddInt64(&externalCounter, 1)
+// scanner returns a scanner.Scanner that shares the client, rate limiter,
+// worker pool, link cache and robots.txt files of the handler.
+func (h *Handler) scanner(opts ScanOptions) *scanner.Scanner {
+	robotsMode := h.RobotsMode
+	if opts.Robots != "" {
+		robotsMode = opts.Robots
+	}
 
-				if link.StatusCode == http.StatusOK {
-					atomic.AddInt64(&externalAlive, 1)
-				}
-			case "internal":
-				atomic.AddInt64(&internalCounter, 1)
+	return &scanner.Scanner{
+		Client:            h.Client,
+		RateLimiter:       h.RateLimiter,
+		Pool:              h.Pool,
+		MaxConcurrency:    h.JobConcurrency,
+		LongRedirectChain: h.LongRedirectChain,
+		LinkCache:         h.LinkCache,
+		BypassLinkCache:   opts.BypassLinkCache,
+		SkipSPAFragments:  h.SkipSPAFragments,
+		MaxRetries:        h.MaxRetries,
+		Robots:            h.Robots,
+		RobotsMode:        robotsMode,
+	}
+}
 
-				if link.StatusCode == http.StatusOK {
-					atomic.AddInt64(&internalAlive, 1)
-				}
-			case "protocol":
-				atomic.AddInt64(&protocolCounter, 1)
-			}
+func (h *Handler) doJob(ctx context.Context, t *jobs.Tracker, baseURL *url.URL, opts ScanOptions) error {
+	h.registerCallback(t, opts)
+	t.SetState(jobs.StateFetching)
 
-			linkMu.Lock()
+	s := h.scanner(opts)
 
-			links = append(links, link)
+	document, err := s.Fetch(ctx, baseURL)
+	if err != nil {
+		return err
+	}
 
-			linkMu.Unlock()
-			atomic.AddInt64(&jobDone, 1)
+	t.SetState(jobs.StateCheckingLinks)
 
-			h.Cache.Set(targetURL, GlobalMapData{
-				Page:     PageData{},
-				URL:      targetURL,
-				Progress: float64(jobDone) / float64(jobCounter) * 100,
-			})
-		}(attr)
+	page := s.Analyze(ctx, document, func(link parser.HyperLink, done, total int64) {
+		t.LinkChecked(link, done, total)
 	})
 
-	wg.Wait()
-
-	haveLoginForm := parser.HasLoginForm(doc)
-
-	h.Cache.Set(targetURL, GlobalMapData{
-		Page: PageData{
-			URL:          targetURL,
-			Title:        title,
-			HTMLVersion:  htmlVersion.Name,
-			Headings:     headings,
-			HyperLinks:   links,
-			HasLoginForm: haveLoginForm,
-			LinkCounters: LinkCounters{
-				Internal:      internalCounter,
-				InternalAlive: internalAlive,
-				External:      externalCounter,
-				ExternalAlive: externalAlive,
-				Protocol:      protocolCounter,
-			},
-		},
-		URL:      targetURL,
-		Progress: 100.0,
-	})
+	t.SetPage(page)
+
+	return nil
 }
 
 func (h *Handler) JobStatus(w http.ResponseWriter, r *http.Request) {
-	targetURL := r.URL.Query().Get("url")
-	if targetURL == "" {
-		respondWithError(w, http.StatusBadRequest, "URL parameter is missing.")
+	job, err := h.findJob(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
 
 		return
 	}
 
-	val, ok := h.Cache.Get(targetURL)
-	if !ok {
-		respondWithError(w, http.StatusBadRequest, "Job does not exist")
+	h.renderProgress(w, job)
+}
+
+// CancelJob handles DELETE /jobs/{id}, it aborts every in-flight request of the job.
+func (h *Handler) CancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.Jobs.Cancel(r.PathValue("id"))
+	if errors.Is(err, jobs.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Job does not exist")
 
 		return
 	}
 
-	err := tmplProgress.Execute(w, val)
+	h.renderProgress(w, job)
+}
+
+// renderProgress renders the progress fragment, that turns into the result once the job is done.
+func (h *Handler) renderProgress(w http.ResponseWriter, job jobs.Job) {
+	err := tmplProgress.Execute(w, job)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error executing result template: %v", err), http.StatusInternalServerError)
 		log.Printf("Error executing result template: %v", err)
@@ -365,28 +336,6 @@ func (h *Handler) JobStatus(w http.ResponseWriter, r *http.Request) {
 func respondWithError(w http.ResponseWriter, statusCode int, errMsg string) {
 	w.WriteHeader(statusCode)
 
-	data := GlobalMapData{Error: errMsg}
+	data := jobs.Job{Error: errMsg}
 	_ = tmplError.Execute(w, data)
 }
-
-// getTitle attempts to get title from passed *goquery.Document
-//
-// Based on webkit source code HTML can contain multiple <title> tags,
-// but we only care about the first one that we encounter
-//
-// Example:
-//
-// void Document::setTitleElement(const StringWithDirection& title, Element* titleElement)
-//
-//	{
-//	    if (titleElement != m_titleElement) {
-//	        if (m_titleElement || m_titleSetExplicitly)
-//	            // Only allow the first title element to change the title -- others have no effect.
-//	            return;
-//	        m_titleElement = titleElement;
-//	    }
-//	    updateTitle(title);
-//	}
-func getTitle(doc *goquery.Document) (title string) {
-	return doc.Find("title").First().Text()
-}
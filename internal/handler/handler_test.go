@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"context"
 	"fmt"
-	"github.com/hugmouse/scan24/internal/cache"
+	"github.com/hugmouse/scan24/internal/jobs"
 	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"github.com/hugmouse/scan24/internal/scanner"
 	"golang.org/x/time/rate"
 	"html/template"
 	"net/http"
@@ -16,22 +18,27 @@ import (
 func TestAnalyzeHandler_Cached(t *testing.T) {
 	initTemplates(t)
 
-	jobCache := cache.New[string, GlobalMapData](time.Minute * 1)
+	jobManager := jobs.NewManager(time.Minute*1, 0)
 
 	h := &Handler{
-		Cache: jobCache,
+		Jobs: jobManager,
 	}
 
 	// Mock completed job
 	testURL := "https://example.com"
-	jobCache.Set(testURL, GlobalMapData{
-		Page: PageData{
+	job := jobManager.Start(jobs.KindPage, testURL, func(ctx context.Context, t *jobs.Tracker) error {
+		t.SetPage(scanner.PageData{
 			Title: "Test Page",
-		},
-		URL:      testURL,
-		Progress: 100.0,
+		})
+
+		return nil
 	})
 
+	_, err := jobManager.Wait(context.Background(), job.ID)
+	if err != nil {
+		t.Fatalf("Failed waiting for the mock job: %v", err)
+	}
+
 	req := httptest.NewRequest("GET", "/analyze?url="+testURL, nil)
 	rr := httptest.NewRecorder()
 	h.AnalyzeHandler(rr, req)
@@ -56,11 +63,11 @@ func TestAnalyzeHandler_WithMockServer(t *testing.T) {
 	}))
 	defer server.Close()
 
-	jobCache := cache.New[string, GlobalMapData](time.Minute * 1)
+	jobManager := jobs.NewManager(time.Minute*1, 0)
 	limiter := ratelimiter.NewDomainRateLimiter(rate.Limit(10), 1)
 	h := &Handler{
 		Client:      server.Client(),
-		Cache:       jobCache,
+		Jobs:        jobManager,
 		RateLimiter: limiter,
 	}
 
@@ -79,10 +86,19 @@ func TestAnalyzeHandler_WithMockServer(t *testing.T) {
 			rr.Body.String())
 	}
 
-	// Check that the item was added to the cache
-	_, ok := jobCache.Get(server.URL)
+	// Check that the job was registered
+	job, ok := jobManager.Latest(jobs.KindPage, server.URL)
 	if !ok {
-		t.Errorf("expected item to be added to cache for url: %s", server.URL)
+		t.Fatalf("expected a job to be started for url: %s", server.URL)
+	}
+
+	job, err := jobManager.Wait(context.Background(), job.ID)
+	if err != nil {
+		t.Fatalf("Failed waiting for the job: %v", err)
+	}
+
+	if job.State != jobs.StateDone || job.Page.Title != "Test" {
+		t.Errorf("unexpected job state %q with title %q", job.State, job.Page.Title)
 	}
 }
 
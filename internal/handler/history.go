@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/storage"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// maxHistoryLimit caps the limit query parameter of the history endpoints.
+const maxHistoryLimit = 1000
+
+// historyPage is the data of the history template.
+type historyPage struct {
+	URL   string
+	Scans []historyScan
+}
+
+// historyScan is a row of the history template.
+type historyScan struct {
+	storage.Summary
+	// PreviousID is the scan of the same page before this one, if there is one to compare with.
+	PreviousID string
+}
+
+// newHistoryScans pairs every page scan with the one before it, scans are listed newest first.
+func newHistoryScans(summaries []storage.Summary) []historyScan {
+	scans := make([]historyScan, len(summaries))
+	previous := make(map[string]string)
+
+	for i := len(summaries) - 1; i >= 0; i-- {
+		s := summaries[i]
+		scans[i] = historyScan{Summary: s}
+
+		if s.Kind == jobs.KindPage {
+			scans[i].PreviousID = previous[s.URL]
+			previous[s.URL] = s.ID
+		}
+	}
+
+	return scans
+}
+
+// SaveHistory stores a finished job in the history, JobFinished calls it for every job.
+//
+// Only jobs that are done are kept, failed and cancelled ones have nothing worth looking at later.
+func (h *Handler) SaveHistory(job jobs.Job) {
+	if h.History == nil || job.State != jobs.StateDone {
+		return
+	}
+
+	err := h.History.Save(job)
+	if err != nil {
+		log.Printf("Error saving scan %s of %s to the history: %v", job.ID, job.URL, err)
+	}
+}
+
+// getJob returns a job by ID, falling back to the history once the job manager forgot about it.
+func (h *Handler) getJob(id string) (jobs.Job, bool) {
+	job, ok := h.Jobs.Get(id)
+	if ok || h.History == nil {
+		return job, ok
+	}
+
+	job, err := h.History.Get(id)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Error loading scan %s from the history: %v", id, err)
+		}
+
+		return jobs.Job{}, false
+	}
+
+	return job, true
+}
+
+// latestJob returns the most recent job of the given kind for targetURL, from the history if there is no recent one.
+func (h *Handler) latestJob(kind jobs.Kind, targetURL string) (jobs.Job, bool) {
+	job, ok := h.Jobs.Latest(kind, targetURL)
+	if ok || h.History == nil {
+		return job, ok
+	}
+
+	scans, err := h.History.List(storage.Query{URL: targetURL, Kind: kind, Limit: 1})
+	if err != nil || len(scans) == 0 {
+		return jobs.Job{}, false
+	}
+
+	return h.getJob(scans[0].ID)
+}
+
+// HistoryHandler lists the past scans of the url query parameter, or of every URL without it.
+func (h *Handler) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondWithError(w, http.StatusMethodNotAllowed, "Method not allowed. Use GET.")
+
+		return
+	}
+
+	if h.History == nil {
+		respondWithError(w, http.StatusNotFound, "Scan history is disabled")
+
+		return
+	}
+
+	targetURL := r.URL.Query().Get("url")
+	if targetURL != "" {
+		_, err := parseTargetURL(targetURL)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+
+			return
+		}
+	}
+
+	scans, err := h.History.List(storage.Query{URL: targetURL})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, err.Error())
+
+		return
+	}
+
+	err = tmplHistory.Execute(w, historyPage{URL: targetURL, Scans: newHistoryScans(scans)})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error executing history template: %v", err), http.StatusInternalServerError)
+		log.Printf("Error executing history template: %v", err)
+	}
+}
+
+// APIHistory lists the summaries of past scans, newest first.
+//
+// They can be narrowed down with the url, kind, since and until (RFC 3339) and limit query parameters.
+func (h *Handler) APIHistory(w http.ResponseWriter, r *http.Request) {
+	if h.History == nil {
+		respondWithJSONError(w, http.StatusNotFound, "history_disabled", "Scan history is disabled")
+
+		return
+	}
+
+	q, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		respondWithScanError(w, err)
+
+		return
+	}
+
+	scans, err := h.History.List(q)
+	if err != nil {
+		respondWithJSONError(w, http.StatusInternalServerError, "history_error", err.Error())
+
+		return
+	}
+
+	if scans == nil {
+		scans = []storage.Summary{}
+	}
+
+	respondWithJSON(w, http.StatusOK, scans)
+}
+
+// APIHistoryJob returns a past scan or crawl in full.
+func (h *Handler) APIHistoryJob(w http.ResponseWriter, r *http.Request) {
+	if h.History == nil {
+		respondWithJSONError(w, http.StatusNotFound, "history_disabled", "Scan history is disabled")
+
+		return
+	}
+
+	job, err := h.History.Get(r.PathValue("id"))
+
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		respondWithJSONError(w, http.StatusNotFound, "job_not_found", "Job does not exist")
+	case err != nil:
+		respondWithJSONError(w, http.StatusInternalServerError, "history_error", err.Error())
+	default:
+		respondWithJSON(w, http.StatusOK, job)
+	}
+}
+
+// parseHistoryQuery reads the filters of APIHistory.
+func parseHistoryQuery(values url.Values) (storage.Query, error) {
+	q := storage.Query{URL: values.Get("url"), Kind: jobs.Kind(values.Get("kind"))}
+
+	if q.URL != "" {
+		_, err := parseTargetURL(q.URL)
+		if err != nil {
+			return storage.Query{}, err
+		}
+	}
+
+	if q.Kind != "" && q.Kind != jobs.KindPage && q.Kind != jobs.KindCrawl {
+		return storage.Query{}, &scanError{Status: http.StatusBadRequest, Code: "invalid_kind", Message: "kind must be page or crawl"}
+	}
+
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		value := values.Get(name)
+		if value == "" {
+			continue
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return storage.Query{}, &scanError{Status: http.StatusBadRequest, Code: "invalid_" + name, Message: fmt.Sprintf("%s must be an RFC 3339 time: %v", name, err)}
+		}
+
+		*t = parsed
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return storage.Query{}, &scanError{Status: http.StatusBadRequest, Code: "invalid_limit", Message: "limit must be a positive number"}
+		}
+
+		q.Limit = min(limit, maxHistoryLimit)
+	}
+
+	return q, nil
+}
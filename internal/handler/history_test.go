@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"github.com/hugmouse/scan24/internal/storage"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory_OutlivesJobManager(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><head><title>Test</title></head><body></body></html>`)
+	}))
+	defer server.Close()
+
+	history, err := storage.Open(t.TempDir(), storage.Retention{})
+	if err != nil {
+		t.Fatalf("Failed to open the history: %v", err)
+	}
+	defer history.Close()
+
+	h := &Handler{
+		Client:      server.Client(),
+		Jobs:        jobs.NewManager(time.Millisecond*10, 0),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(10), 1),
+		History:     history,
+	}
+	h.Jobs.OnFinish = h.SaveHistory
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/history", h.HistoryHandler)
+	mux.HandleFunc("GET /api/v1/history", h.APIHistory)
+	mux.HandleFunc("GET /api/v1/history/{id}", h.APIHistoryJob)
+	mux.HandleFunc("GET /api/v1/scans/{id}/result", h.APIScanResult)
+
+	job, err := h.startScan(server.URL, ScanOptions{})
+	if err != nil {
+		t.Fatalf("startScan returned an error: %v", err)
+	}
+
+	_, _ = h.Jobs.Wait(context.Background(), job.ID)
+
+	// Past the retention of the job manager
+	time.Sleep(time.Millisecond * 15)
+
+	if _, ok := h.Jobs.Get(job.ID); ok {
+		t.Fatal("expected the job manager to forget the job")
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/scans/"+job.ID+"/result", nil))
+
+	var page scanner.PageData
+
+	_ = json.NewDecoder(rr.Body).Decode(&page)
+	if rr.Code != http.StatusOK || page.Title != "Test" {
+		t.Errorf("expected the result from the history, got %d %+v", rr.Code, page)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/history?url="+url.QueryEscape(server.URL), nil))
+
+	var scans []storage.Summary
+
+	_ = json.NewDecoder(rr.Body).Decode(&scans)
+	if rr.Code != http.StatusOK || len(scans) != 1 || scans[0].ID != job.ID {
+		t.Errorf("expected the scan to be listed, got %d %+v", rr.Code, scans)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/history/"+job.ID, nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/history?url="+url.QueryEscape(server.URL), nil))
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "/result?id="+job.ID) {
+		t.Errorf("expected the history page to link to the scan, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/history?limit=0", nil))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
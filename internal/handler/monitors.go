@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/monitor"
+	"github.com/hugmouse/scan24/internal/robots"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// MonitorRequest is the body of POST /api/v1/monitors.
+type MonitorRequest struct {
+	URL      string `json:"url"`
+	Schedule string `json:"schedule"`
+	// Conditions raise alerts, monitor.DefaultConditions if empty.
+	Conditions []monitor.Condition `json:"conditions"`
+	Paused     bool                `json:"paused"`
+	ScanOptions
+}
+
+// monitorsPage is the data of the monitors template.
+type monitorsPage struct {
+	Monitors   []monitor.Monitor
+	Conditions []monitor.Condition
+	Error      string
+}
+
+// allConditions are offered by the monitors page.
+var allConditions = []monitor.Condition{
+	monitor.ConditionScanFailed,
+	monitor.ConditionInternalLinkBroken,
+	monitor.ConditionAnyLinkBroken,
+	monitor.ConditionNewBrokenLinks,
+	monitor.ConditionTitleChanged,
+	monitor.ConditionLoginFormDisappeared,
+}
+
+// RunMonitor scans the URL of a monitor through the job manager and waits for the result,
+// it is meant to be the monitor.Scheduler's RunFunc.
+//
+// Every run is a new scan, recent scans of the same URL are not reused.
+func (h *Handler) RunMonitor(ctx context.Context, m monitor.Monitor) (jobs.Job, error) {
+	baseURL, err := parseTargetURL(m.URL)
+	if err != nil {
+		return jobs.Job{}, err
+	}
+
+	job := h.Jobs.Start(jobs.KindPage, m.URL, func(ctx context.Context, t *jobs.Tracker) error {
+		return h.doJob(ctx, t, baseURL, m.Options)
+	})
+
+	finished, err := h.Jobs.Wait(ctx, job.ID)
+	if err != nil {
+		_, _ = h.Jobs.Cancel(job.ID)
+
+		return job, err
+	}
+
+	return finished, nil
+}
+
+// addMonitor validates a request and registers the monitor.
+func (h *Handler) addMonitor(req MonitorRequest) (monitor.Monitor, error) {
+	_, err := parseTargetURL(req.URL)
+	if err != nil {
+		return monitor.Monitor{}, err
+	}
+
+	err = validateScanOptions(req.ScanOptions)
+	if err != nil {
+		return monitor.Monitor{}, err
+	}
+
+	m, err := h.Monitors.Add(monitor.Monitor{
+		URL:        req.URL,
+		Schedule:   req.Schedule,
+		Options:    req.ScanOptions,
+		Conditions: req.Conditions,
+		Paused:     req.Paused,
+	})
+
+	switch {
+	case errors.Is(err, monitor.ErrInvalidSchedule), errors.Is(err, monitor.ErrTooFrequent), errors.Is(err, monitor.ErrNeverRuns):
+		return monitor.Monitor{}, &scanError{Status: http.StatusBadRequest, Code: "invalid_schedule", Message: err.Error()}
+	case errors.Is(err, monitor.ErrInvalidCondition):
+		return monitor.Monitor{}, &scanError{Status: http.StatusBadRequest, Code: "invalid_condition", Message: err.Error()}
+	case err != nil:
+		return monitor.Monitor{}, err
+	}
+
+	return m, nil
+}
+
+// APICreateMonitor registers a monitor that scans a URL on a schedule.
+func (h *Handler) APICreateMonitor(w http.ResponseWriter, r *http.Request) {
+	var req MonitorRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		respondWithJSONError(w, http.StatusBadRequest, "invalid_body", "Request body must be a JSON object: "+err.Error())
+
+		return
+	}
+
+	m, err := h.addMonitor(req)
+	if err != nil {
+		respondWithScanError(w, err)
+
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, m)
+}
+
+// APIListMonitors lists all monitors with their latest runs.
+func (h *Handler) APIListMonitors(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, h.Monitors.List())
+}
+
+// APIGetMonitor returns a single monitor.
+func (h *Handler) APIGetMonitor(w http.ResponseWriter, r *http.Request) {
+	m, err := h.Monitors.Get(r.PathValue("id"))
+	respondWithMonitor(w, m, err)
+}
+
+// APIPauseMonitor stops scheduling runs of a monitor.
+func (h *Handler) APIPauseMonitor(w http.ResponseWriter, r *http.Request) {
+	m, err := h.Monitors.Pause(r.PathValue("id"))
+	respondWithMonitor(w, m, err)
+}
+
+// APIResumeMonitor schedules the runs of a paused monitor again.
+func (h *Handler) APIResumeMonitor(w http.ResponseWriter, r *http.Request) {
+	m, err := h.Monitors.Resume(r.PathValue("id"))
+	respondWithMonitor(w, m, err)
+}
+
+// APIDeleteMonitor removes a monitor, the scans it made are kept.
+func (h *Handler) APIDeleteMonitor(w http.ResponseWriter, r *http.Request) {
+	err := h.Monitors.Delete(r.PathValue("id"))
+	if errors.Is(err, monitor.ErrNotFound) {
+		respondWithJSONError(w, http.StatusNotFound, "monitor_not_found", "Monitor does not exist")
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func respondWithMonitor(w http.ResponseWriter, m monitor.Monitor, err error) {
+	if errors.Is(err, monitor.ErrNotFound) {
+		respondWithJSONError(w, http.StatusNotFound, "monitor_not_found", "Monitor does not exist")
+
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, m)
+}
+
+// MonitorsHandler shows the monitors page.
+func (h *Handler) MonitorsHandler(w http.ResponseWriter, r *http.Request) {
+	h.renderMonitors(w, http.StatusOK, "")
+}
+
+// CreateMonitorHandler registers a monitor from the form of the monitors page.
+func (h *Handler) CreateMonitorHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		h.renderMonitors(w, http.StatusBadRequest, err.Error())
+
+		return
+	}
+
+	bypassLinkCache, _ := strconv.ParseBool(r.PostForm.Get("bypass_link_cache"))
+
+	req := MonitorRequest{
+		URL:      r.PostForm.Get("url"),
+		Schedule: r.PostForm.Get("schedule"),
+		ScanOptions: ScanOptions{
+			BypassLinkCache: bypassLinkCache,
+			Robots:          robots.Mode(r.PostForm.Get("robots")),
+		},
+	}
+
+	for _, c := range r.PostForm["conditions"] {
+		req.Conditions = append(req.Conditions, monitor.Condition(c))
+	}
+
+	_, err = h.addMonitor(req)
+	if err != nil {
+		var sErr *scanError
+		if errors.As(err, &sErr) {
+			h.renderMonitors(w, sErr.Status, sErr.Message)
+		} else {
+			h.renderMonitors(w, http.StatusInternalServerError, err.Error())
+		}
+
+		return
+	}
+
+	http.Redirect(w, r, "/monitors", http.StatusSeeOther)
+}
+
+// MonitorActionHandler pauses, resumes or deletes a monitor from the monitors page.
+func (h *Handler) MonitorActionHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	var err error
+
+	switch r.PathValue("action") {
+	case "pause":
+		_, err = h.Monitors.Pause(id)
+	case "resume":
+		_, err = h.Monitors.Resume(id)
+	case "delete":
+		err = h.Monitors.Delete(id)
+	default:
+		h.renderMonitors(w, http.StatusNotFound, "Unknown action")
+
+		return
+	}
+
+	if errors.Is(err, monitor.ErrNotFound) {
+		h.renderMonitors(w, http.StatusNotFound, "Monitor does not exist")
+
+		return
+	}
+
+	http.Redirect(w, r, "/monitors", http.StatusSeeOther)
+}
+
+func (h *Handler) renderMonitors(w http.ResponseWriter, status int, errMsg string) {
+	w.WriteHeader(status)
+
+	err := tmplMonitors.Execute(w, monitorsPage{Monitors: h.Monitors.List(), Conditions: allConditions, Error: errMsg})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error executing monitors template: %v", err), http.StatusInternalServerError)
+		log.Printf("Error executing monitors template: %v", err)
+	}
+}
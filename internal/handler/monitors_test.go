@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/monitor"
+	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIMonitors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><head><title>Test</title></head><body><a href="/gone">Gone</a></body></html>`)
+	}))
+	defer server.Close()
+
+	h := &Handler{
+		Client:      server.Client(),
+		Jobs:        jobs.NewManager(time.Minute*1, 0),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(100), 1),
+	}
+	h.Monitors = monitor.NewScheduler(h.RunMonitor)
+	h.Monitors.Start()
+	defer h.Monitors.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /monitors", h.CreateMonitorHandler)
+	mux.HandleFunc("POST /api/v1/monitors", h.APICreateMonitor)
+	mux.HandleFunc("GET /api/v1/monitors/{id}", h.APIGetMonitor)
+	mux.HandleFunc("POST /api/v1/monitors/{id}/pause", h.APIPauseMonitor)
+	mux.HandleFunc("DELETE /api/v1/monitors/{id}", h.APIDeleteMonitor)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/monitors", strings.NewReader(`{"url":"`+server.URL+`","schedule":"@every 50ms"}`)))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v, body: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+
+	var m monitor.Monitor
+
+	err := json.NewDecoder(rr.Body).Decode(&m)
+	if err != nil {
+		t.Fatalf("Failed to decode monitor: %v", err)
+	}
+
+	// The first run finds the broken internal link
+	deadline := time.Now().Add(5 * time.Second)
+	for len(m.Runs) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+
+		m, _ = h.Monitors.Get(m.ID)
+	}
+
+	if len(m.Runs) == 0 {
+		t.Fatal("expected the monitor to run")
+	}
+
+	run := m.Runs[0]
+	if run.State != jobs.StateDone || len(run.Alerts) != 1 || run.Alerts[0].Condition != monitor.ConditionInternalLinkBroken {
+		t.Errorf("unexpected run: %+v", run)
+	}
+
+	if job, ok := h.Jobs.Get(run.JobID); !ok || job.Page.Title != "Test" {
+		t.Errorf("expected the run to go through the job manager, got %+v", job)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("POST", "/api/v1/monitors/"+m.ID+"/pause", nil))
+
+	_ = json.NewDecoder(rr.Body).Decode(&m)
+	if rr.Code != http.StatusOK || !m.Paused {
+		t.Errorf("expected the monitor to be paused, got %d %+v", rr.Code, m)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("DELETE", "/api/v1/monitors/"+m.ID, nil))
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/monitors/"+m.ID, nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	form := url.Values{"url": {server.URL}, "schedule": {"every hour"}}
+	req := httptest.NewRequest("POST", "/monitors", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "schedule must be") {
+		t.Errorf("expected the monitors page to show the invalid schedule, got %d", rr.Code)
+	}
+}
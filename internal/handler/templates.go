@@ -1,10 +1,12 @@
 package handler
 
 import (
+	"fmt"
 	"github.com/hugmouse/scan24/static"
 	"github.com/hugmouse/scan24/templates"
 	"html/template"
 	"log"
+	"time"
 )
 
 func init() {
@@ -17,6 +19,9 @@ func init() {
 
 			return template.HTML(bb)
 		},
+		"ms": func(d time.Duration) string {
+			return fmt.Sprintf("%.1f ms", float64(d)/float64(time.Millisecond))
+		},
 		"includeJS": func(path string) template.JS {
 			bb, err := static.FS.ReadFile(path)
 			if err != nil {
@@ -31,6 +36,12 @@ func init() {
 
 	var err error
 
+	// Partials shared by the templates below
+	_, err = baseTmpl.New("links.gohtml").ParseFS(templates.FS, "links.gohtml")
+	if err != nil {
+		log.Fatalf("Failed to parse links.gohtml: %v", err)
+	}
+
 	tmplIndex, err = baseTmpl.New("index.gohtml").ParseFS(templates.FS, "index.gohtml")
 	if err != nil {
 		log.Fatalf("Failed to parse index.gohtml: %v", err)
@@ -50,4 +61,24 @@ func init() {
 	if err != nil {
 		log.Fatalf("Failed to parse error.gohtml: %v", err)
 	}
+
+	tmplHistory, err = baseTmpl.New("history.gohtml").ParseFS(templates.FS, "history.gohtml")
+	if err != nil {
+		log.Fatalf("Failed to parse history.gohtml: %v", err)
+	}
+
+	tmplDiff, err = baseTmpl.New("diff.gohtml").ParseFS(templates.FS, "diff.gohtml")
+	if err != nil {
+		log.Fatalf("Failed to parse diff.gohtml: %v", err)
+	}
+
+	tmplMonitors, err = baseTmpl.New("monitors.gohtml").ParseFS(templates.FS, "monitors.gohtml")
+	if err != nil {
+		log.Fatalf("Failed to parse monitors.gohtml: %v", err)
+	}
+
+	tmplBatch, err = baseTmpl.New("batch.gohtml").ParseFS(templates.FS, "batch.gohtml")
+	if err != nil {
+		log.Fatalf("Failed to parse batch.gohtml: %v", err)
+	}
 }
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/monitor"
+	"github.com/hugmouse/scan24/internal/storage"
+	"github.com/hugmouse/scan24/internal/webhook"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// WebhookTestRequest is the optional body of POST /api/v1/webhooks/test.
+type WebhookTestRequest struct {
+	// URL receives the ping besides the configured webhooks.
+	URL string `json:"url"`
+}
+
+// registerCallback remembers the callback URL of a job until it is finished,
+// it is called by the job itself so that the URL is known before OnFinish.
+func (h *Handler) registerCallback(t *jobs.Tracker, opts ScanOptions) {
+	if opts.CallbackURL != "" {
+		h.callbacks.Store(t.ID(), opts.CallbackURL)
+	}
+}
+
+// JobFinished saves a finished job to the history, updates its batches and sends its webhooks,
+// it is meant to be the jobs.Manager's OnFinish.
+func (h *Handler) JobFinished(job jobs.Job) {
+	h.SaveHistory(job)
+
+	if h.Batches != nil {
+		h.Batches.JobFinished(job)
+	}
+
+	callback, _ := h.callbacks.LoadAndDelete(job.ID)
+	callbackURL, _ := callback.(string)
+
+	if h.Webhooks == nil {
+		return
+	}
+
+	var event webhook.Event
+
+	switch job.State {
+	case jobs.StateDone:
+		event = webhook.EventJobCompleted
+	case jobs.StateFailed:
+		event = webhook.EventJobFailed
+	default:
+		return
+	}
+
+	p := webhook.Payload{Event: event, Error: job.Error}
+	h.setPayloadJob(&p, job)
+
+	h.Webhooks.Send(p, callbackURL)
+}
+
+// MonitorAlert sends the alerts of a monitor run, it is meant to be the monitor.Scheduler's OnAlert.
+//
+// The callback URL of the monitor gets them as well as the configured webhooks.
+func (h *Handler) MonitorAlert(m monitor.Monitor, run monitor.Run) {
+	if h.Webhooks == nil {
+		return
+	}
+
+	p := webhook.Payload{
+		Event:   webhook.EventMonitorAlert,
+		Error:   run.Error,
+		Monitor: &webhook.Monitor{ID: m.ID, URL: m.URL, Schedule: m.Schedule},
+		Alerts:  run.Alerts,
+	}
+
+	if run.JobID != "" {
+		job, ok := h.getJob(run.JobID)
+		if ok {
+			h.setPayloadJob(&p, job)
+		}
+	}
+
+	h.Webhooks.Send(p, m.Options.CallbackURL)
+}
+
+// setPayloadJob adds the summary of job to a payload, and the link to its result if it is done.
+func (h *Handler) setPayloadJob(p *webhook.Payload, job jobs.Job) {
+	summary := storage.NewSummary(job)
+	p.Job = &summary
+
+	if job.State == jobs.StateDone {
+		p.ResultURL = strings.TrimSuffix(h.PublicURL, "/") + newJobStatus(job).ResultURL
+	}
+}
+
+// APIWebhookDeliveries lists the latest webhook deliveries with their attempts, newest first.
+func (h *Handler) APIWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if h.Webhooks == nil {
+		respondWithJSONError(w, http.StatusNotFound, "webhooks_disabled", "Webhooks are disabled")
+
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, h.Webhooks.Deliveries())
+}
+
+// APITestWebhook sends a ping to the configured webhooks and to the URL of the request, if any.
+//
+// Responds with 202 Accepted and the new deliveries, their outcome is listed by APIWebhookDeliveries.
+func (h *Handler) APITestWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.Webhooks == nil {
+		respondWithJSONError(w, http.StatusNotFound, "webhooks_disabled", "Webhooks are disabled")
+
+		return
+	}
+
+	var req WebhookTestRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithJSONError(w, http.StatusBadRequest, "invalid_body", "Request body must be a JSON object: "+err.Error())
+
+		return
+	}
+
+	err = validateScanOptions(ScanOptions{CallbackURL: req.URL})
+	if err != nil {
+		respondWithScanError(w, err)
+
+		return
+	}
+
+	// Pings go to every webhook, whatever events it subscribed to
+	targets := append([]string{req.URL}, h.Webhooks.URLs...)
+
+	deliveries := h.Webhooks.Send(webhook.Payload{Event: webhook.EventPing}, targets...)
+	if len(deliveries) == 0 {
+		respondWithJSONError(w, http.StatusBadRequest, "no_webhooks", "No webhooks are configured, pass a URL to ping instead")
+
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, deliveries)
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"github.com/hugmouse/scan24/internal/webhook"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhooks_JobFinished(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><head><title>Test</title></head><body></body></html>`)
+	}))
+	defer site.Close()
+
+	var (
+		mu       sync.Mutex
+		received = make(map[string][]webhook.Payload)
+	)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhook.Payload
+
+		_ = json.NewDecoder(r.Body).Decode(&p)
+
+		mu.Lock()
+		received[r.URL.Path] = append(received[r.URL.Path], p)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	webhooks := webhook.New(receiver.Client())
+	webhooks.URLs = []string{receiver.URL + "/failures"}
+	webhooks.Events = []webhook.Event{webhook.EventJobFailed}
+	defer webhooks.Close()
+
+	h := &Handler{
+		Client:      site.Client(),
+		Jobs:        jobs.NewManager(time.Minute, 0),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(10), 1),
+		Webhooks:    webhooks,
+		PublicURL:   "https://scan24.example.com/",
+	}
+	h.Jobs.OnFinish = h.JobFinished
+
+	job, err := h.startScan(site.URL, ScanOptions{CallbackURL: receiver.URL + "/callback"})
+	if err != nil {
+		t.Fatalf("startScan returned an error: %v", err)
+	}
+
+	_, _ = h.Jobs.Wait(context.Background(), job.ID)
+
+	failed, err := h.startScan("http://127.0.0.1:1/", ScanOptions{})
+	if err != nil {
+		t.Fatalf("startScan returned an error: %v", err)
+	}
+
+	_, _ = h.Jobs.Wait(context.Background(), failed.ID)
+	webhooks.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	callback := received["/callback"]
+	if len(callback) != 1 || callback[0].Event != webhook.EventJobCompleted || callback[0].Job.Title != "Test" ||
+		callback[0].ResultURL != "https://scan24.example.com/api/v1/scans/"+job.ID+"/result" {
+		t.Errorf("Expected the callback to get the completed scan, got %+v", callback)
+	}
+
+	failures := received["/failures"]
+	if len(failures) != 1 || failures[0].Event != webhook.EventJobFailed || failures[0].Job.ID != failed.ID || failures[0].Error == "" {
+		t.Errorf("Expected the webhook to get only the failed scan, got %+v", failures)
+	}
+
+	rr := httptest.NewRecorder()
+	h.APIWebhookDeliveries(rr, httptest.NewRequest("GET", "/api/v1/webhooks/deliveries", nil))
+
+	var deliveries []webhook.Delivery
+
+	_ = json.NewDecoder(rr.Body).Decode(&deliveries)
+	if rr.Code != http.StatusOK || len(deliveries) != 2 || deliveries[0].State != webhook.StateDelivered {
+		t.Errorf("Expected 2 deliveries, got %d %+v", rr.Code, deliveries)
+	}
+
+	_, err = h.startScan(site.URL, ScanOptions{CallbackURL: "ftp://example.com"})
+	if err == nil || !strings.Contains(err.Error(), "Callback URL") {
+		t.Errorf("Expected an invalid callback URL to be rejected, got %v", err)
+	}
+}
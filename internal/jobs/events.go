@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"github.com/hugmouse/scan24/internal/parser"
+)
+
+// EventType tells what happened to a job.
+type EventType string
+
+const (
+	// EventPhase is sent when the job moves to another state.
+	EventPhase EventType = "phase"
+	// EventProgress is sent when the job progress changes without a checked link, e.g. a crawled page.
+	EventProgress EventType = "progress"
+	// EventLink is sent every time a link is checked.
+	EventLink EventType = "link"
+)
+
+// subscriberBuffer is how many events a slow subscriber may lag behind before events are dropped.
+const subscriberBuffer = 256
+
+// Event is a progress notification of a job.
+type Event struct {
+	Type EventType
+	// Job is the state of the job right after the event.
+	Job Job
+	// Link is the link that was just checked, only set for EventLink.
+	Link *parser.HyperLink
+}
+
+// Subscribe returns a channel that receives events of the job until it is finished,
+// after that the channel is closed. unsubscribe must be called once the caller is done.
+//
+// If the job is already finished the returned channel is closed right away.
+func (m *Manager) Subscribe(id string) (events <-chan Event, unsubscribe func(), err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok || m.expired(e) {
+		return nil, nil, ErrNotFound
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+
+	if e.job.State.Finished() {
+		close(ch)
+
+		return ch, func() {}, nil
+	}
+
+	if e.subscribers == nil {
+		e.subscribers = make(map[chan Event]struct{})
+	}
+
+	e.subscribers[ch] = struct{}{}
+
+	unsubscribe = func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if _, ok := e.subscribers[ch]; ok {
+			delete(e.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return ch, unsubscribe, nil
+}
+
+// publish sends ev to every subscriber of the job, m.mu must be held.
+//
+// Subscribers that are too slow miss events instead of blocking the job.
+func (m *Manager) publish(e *entry, ev Event) {
+	if len(e.subscribers) == 0 {
+		return
+	}
+
+	ev.Job = m.snapshot(e)
+
+	for ch := range e.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// closeSubscribers disconnects every subscriber, m.mu must be held.
+func (e *entry) closeSubscribers() {
+	for ch := range e.subscribers {
+		close(ch)
+	}
+
+	e.subscribers = nil
+}
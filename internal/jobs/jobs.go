@@ -0,0 +1,439 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/hugmouse/scan24/internal/crawler"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is a step of the job lifecycle.
+//
+// Jobs go queued -> fetching -> checking-links and end up done, failed or cancelled.
+type State string
+
+const (
+	StateQueued        State = "queued"
+	StateFetching      State = "fetching"
+	StateCheckingLinks State = "checking-links"
+	StateDone          State = "done"
+	StateFailed        State = "failed"
+	StateCancelled     State = "cancelled"
+)
+
+// Finished reports whether the state is terminal.
+func (s State) Finished() bool {
+	return s == StateDone || s == StateFailed || s == StateCancelled
+}
+
+// Kind tells what a job does.
+type Kind string
+
+const (
+	KindPage  Kind = "page"
+	KindCrawl Kind = "crawl"
+)
+
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrFinished = errors.New("job is already finished")
+)
+
+// Job is a snapshot of a scan job.
+type Job struct {
+	ID            string              `json:"id"`
+	Kind          Kind                `json:"kind"`
+	URL           string              `json:"url"`
+	State         State               `json:"state"`
+	Done          int64               `json:"done"`
+	Total         int64               `json:"total"`
+	Progress      float64             `json:"progress"`
+	QueuePosition int                 `json:"queue_position,omitempty"` // 1-based, only set while queued
+	Page          scanner.PageData    `json:"page"`
+	Site          *crawler.SiteReport `json:"site,omitempty"`
+	Error         string              `json:"error,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	StartedAt     time.Time           `json:"started_at,omitzero"`
+	FinishedAt    time.Time           `json:"finished_at,omitzero"`
+}
+
+// RunFunc does the actual work of a job.
+//
+// ctx is cancelled when the job is cancelled, a returned error marks the job as failed.
+type RunFunc func(ctx context.Context, t *Tracker) error
+
+type entry struct {
+	job    Job
+	ctx    context.Context
+	cancel context.CancelFunc
+	run    RunFunc
+	done   chan struct{}
+
+	subscribers map[chan Event]struct{}
+}
+
+// Manager keeps track of running and recently finished jobs.
+//
+// At most maxConcurrent jobs run at the same time, the rest wait in a FIFO queue.
+type Manager struct {
+	mu            sync.Mutex
+	jobs          map[string]*entry
+	queue         []*entry
+	running       int
+	maxConcurrent int
+	retention     time.Duration
+
+	// OnFinish is called with every job that reaches a terminal state, outside the lock of the Manager.
+	// It has to be set before the first job is started.
+	OnFinish func(Job)
+}
+
+// NewManager creates a Manager that forgets finished jobs after retention.
+//
+// maxConcurrent limits the number of jobs running at once, 0 means no limit.
+func NewManager(retention time.Duration, maxConcurrent int) *Manager {
+	return &Manager{
+		jobs:          make(map[string]*entry),
+		retention:     retention,
+		maxConcurrent: maxConcurrent,
+	}
+}
+
+// Start registers a new job and runs it in the background.
+func (m *Manager) Start(kind Kind, targetURL string, run RunFunc) Job {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	e := &entry{
+		job: Job{
+			ID:        newID(),
+			Kind:      kind,
+			URL:       targetURL,
+			State:     StateQueued,
+			CreatedAt: time.Now(),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+		run:    run,
+		done:   make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+	m.jobs[e.job.ID] = e
+
+	if m.maxConcurrent > 0 && m.running >= m.maxConcurrent {
+		m.queue = append(m.queue, e)
+	} else {
+		m.running++
+
+		go m.run(e)
+	}
+
+	return m.snapshot(e)
+}
+
+func (m *Manager) run(e *entry) {
+	defer m.next()
+	defer close(e.done)
+	defer e.cancel()
+
+	ctx := e.ctx
+
+	m.update(e, func(j *Job) {
+		j.StartedAt = time.Now()
+	})
+
+	err := e.run(ctx, &Tracker{m: m, e: e})
+
+	m.finish(e, func(j *Job) {
+		j.FinishedAt = time.Now()
+
+		switch {
+		case ctx.Err() != nil:
+			j.State = StateCancelled
+			j.Error = "Job was cancelled"
+		case err != nil:
+			j.State = StateFailed
+			j.Error = err.Error()
+		default:
+			j.State = StateDone
+			j.Progress = 100
+		}
+	})
+}
+
+// next frees the slot of a finished job and starts queued jobs.
+func (m *Manager) next() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.running--
+
+	for len(m.queue) > 0 && (m.maxConcurrent <= 0 || m.running < m.maxConcurrent) {
+		e := m.queue[0]
+		m.queue = m.queue[1:]
+		m.running++
+
+		go m.run(e)
+	}
+}
+
+// snapshot returns a copy of the job with its queue position filled in, m.mu must be held.
+func (m *Manager) snapshot(e *entry) Job {
+	job := e.job
+
+	for i, queued := range m.queue {
+		if queued == e {
+			job.QueuePosition = i + 1
+
+			break
+		}
+	}
+
+	return job
+}
+
+func (m *Manager) update(e *entry, fn func(j *Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fn(&e.job)
+}
+
+// finish applies the final update to a job and disconnects its subscribers.
+func (m *Manager) finish(e *entry, fn func(j *Job)) {
+	m.mu.Lock()
+	fn(&e.job)
+	e.closeSubscribers()
+	job := e.job
+	m.mu.Unlock()
+
+	m.finished(job)
+}
+
+// finished hands a job that just finished to OnFinish.
+func (m *Manager) finished(job Job) {
+	if m.OnFinish != nil {
+		m.OnFinish(job)
+	}
+}
+
+// Get returns a job by ID.
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok || m.expired(e) {
+		return Job{}, false
+	}
+
+	return m.snapshot(e), true
+}
+
+// Latest returns the most recent job of the given kind for targetURL.
+func (m *Manager) Latest(kind Kind, targetURL string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var (
+		latest Job
+		found  bool
+	)
+
+	for _, e := range m.jobs {
+		if e.job.Kind != kind || e.job.URL != targetURL || m.expired(e) {
+			continue
+		}
+
+		if !found || e.job.CreatedAt.After(latest.CreatedAt) {
+			latest = m.snapshot(e)
+			found = true
+		}
+	}
+
+	return latest, found
+}
+
+// List returns all known jobs, newest first.
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Job, 0, len(m.jobs))
+
+	for _, e := range m.jobs {
+		if !m.expired(e) {
+			list = append(list, m.snapshot(e))
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+
+	return list
+}
+
+// Cancel stops a running job, every in-flight request of the job is aborted.
+func (m *Manager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	e, ok := m.jobs[id]
+
+	if !ok || m.expired(e) {
+		m.mu.Unlock()
+
+		return Job{}, ErrNotFound
+	}
+
+	if e.job.State.Finished() {
+		job := e.job
+		m.mu.Unlock()
+
+		return job, ErrFinished
+	}
+
+	// Queued jobs never started, so they can be finished right away
+	for i, queued := range m.queue {
+		if queued == e {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			e.cancel()
+			e.job.State = StateCancelled
+			e.job.Error = "Job was cancelled"
+			e.job.FinishedAt = time.Now()
+			e.closeSubscribers()
+			close(e.done)
+
+			job := e.job
+			m.mu.Unlock()
+
+			m.finished(job)
+
+			return job, nil
+		}
+	}
+	m.mu.Unlock()
+
+	e.cancel()
+	<-e.done
+
+	job, _ := m.Get(id)
+
+	return job, nil
+}
+
+// Wait blocks until the job is finished or ctx is done.
+func (m *Manager) Wait(ctx context.Context, id string) (Job, error) {
+	m.mu.Lock()
+	e, ok := m.jobs[id]
+	m.mu.Unlock()
+
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return e.job, nil
+}
+
+// expired reports whether a finished job outlived the retention period.
+func (m *Manager) expired(e *entry) bool {
+	return e.job.State.Finished() && time.Since(e.job.FinishedAt) > m.retention
+}
+
+// prune removes expired jobs, m.mu must be held.
+func (m *Manager) prune() {
+	for id, e := range m.jobs {
+		if m.expired(e) {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// Tracker lets a RunFunc report the progress of its job.
+type Tracker struct {
+	m *Manager
+	e *entry
+}
+
+// ID returns the ID of the tracked job.
+func (t *Tracker) ID() string {
+	return t.e.job.ID
+}
+
+// SetState moves the job to the given non-terminal state.
+func (t *Tracker) SetState(state State) {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+
+	if t.e.job.State == state {
+		return
+	}
+
+	t.e.job.State = state
+	t.m.publish(t.e, Event{Type: EventPhase})
+}
+
+// SetProgress records that done out of total units of work are finished.
+func (t *Tracker) SetProgress(done, total int64) {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+
+	t.e.job.setProgress(done, total)
+	t.m.publish(t.e, Event{Type: EventProgress})
+}
+
+// LinkChecked records that link was checked, done out of total links are finished.
+func (t *Tracker) LinkChecked(link parser.HyperLink, done, total int64) {
+	t.m.mu.Lock()
+	defer t.m.mu.Unlock()
+
+	t.e.job.setProgress(done, total)
+	t.m.publish(t.e, Event{Type: EventLink, Link: &link})
+}
+
+func (j *Job) setProgress(done, total int64) {
+	j.Done = done
+	j.Total = total
+
+	if total > 0 {
+		j.Progress = float64(done) / float64(total) * 100
+	}
+}
+
+// SetPage stores the page analysis result.
+func (t *Tracker) SetPage(page scanner.PageData) {
+	t.m.update(t.e, func(j *Job) {
+		j.Page = page
+	})
+}
+
+// SetSite stores the crawl result.
+func (t *Tracker) SetSite(site *crawler.SiteReport) {
+	t.m.update(t.e, func(j *Job) {
+		j.Site = site
+	})
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
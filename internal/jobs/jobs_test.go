@@ -0,0 +1,232 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"testing"
+	"time"
+)
+
+func TestManager_Lifecycle(t *testing.T) {
+	m := NewManager(time.Minute, 0)
+
+	job := m.Start(KindPage, "https://example.com", func(ctx context.Context, tr *Tracker) error {
+		tr.SetState(StateCheckingLinks)
+		tr.SetProgress(1, 2)
+		tr.SetPage(scanner.PageData{Title: "Example"})
+
+		return nil
+	})
+
+	if job.ID == "" || job.State != StateQueued {
+		t.Fatalf("unexpected new job: %+v", job)
+	}
+
+	job, err := m.Wait(context.Background(), job.ID)
+	if err != nil {
+		t.Fatalf("Wait returned an error: %v", err)
+	}
+
+	if job.State != StateDone || job.Progress != 100 || job.Page.Title != "Example" {
+		t.Errorf("unexpected finished job: %+v", job)
+	}
+
+	if job.StartedAt.IsZero() || job.FinishedAt.IsZero() {
+		t.Errorf("expected timestamps to be recorded: %+v", job)
+	}
+
+	latest, ok := m.Latest(KindPage, "https://example.com")
+	if !ok || latest.ID != job.ID {
+		t.Errorf("expected Latest to return %s, got %s (found=%v)", job.ID, latest.ID, ok)
+	}
+
+	_, err = m.Cancel(job.ID)
+	if !errors.Is(err, ErrFinished) {
+		t.Errorf("expected ErrFinished, got %v", err)
+	}
+}
+
+func TestManager_Failed(t *testing.T) {
+	m := NewManager(time.Minute, 0)
+
+	job := m.Start(KindPage, "https://example.com", func(ctx context.Context, tr *Tracker) error {
+		return errors.New("boom")
+	})
+
+	job, _ = m.Wait(context.Background(), job.ID)
+	if job.State != StateFailed || job.Error != "boom" {
+		t.Errorf("unexpected failed job: %+v", job)
+	}
+}
+
+func TestManager_Cancel(t *testing.T) {
+	m := NewManager(time.Minute, 0)
+
+	started := make(chan struct{})
+	job := m.Start(KindPage, "https://example.com", func(ctx context.Context, tr *Tracker) error {
+		close(started)
+		<-ctx.Done()
+
+		return ctx.Err()
+	})
+
+	<-started
+
+	job, err := m.Cancel(job.ID)
+	if err != nil {
+		t.Fatalf("Cancel returned an error: %v", err)
+	}
+
+	if job.State != StateCancelled {
+		t.Errorf("expected job to be cancelled, got %q", job.State)
+	}
+
+	_, err = m.Cancel("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestManager_Retention(t *testing.T) {
+	m := NewManager(time.Millisecond*10, 0)
+
+	job := m.Start(KindPage, "https://example.com", func(ctx context.Context, tr *Tracker) error {
+		return nil
+	})
+
+	_, _ = m.Wait(context.Background(), job.ID)
+
+	time.Sleep(time.Millisecond * 15)
+
+	if _, ok := m.Get(job.ID); ok {
+		t.Error("expected finished job to be forgotten after the retention period")
+	}
+}
+
+func TestManager_OnFinish(t *testing.T) {
+	m := NewManager(time.Minute, 1)
+
+	var finished []Job
+	m.OnFinish = func(job Job) {
+		finished = append(finished, job)
+	}
+
+	release := make(chan struct{})
+	running := m.Start(KindPage, "https://example.com/1", func(ctx context.Context, tr *Tracker) error {
+		<-release
+
+		return nil
+	})
+	queued := m.Start(KindPage, "https://example.com/2", func(ctx context.Context, tr *Tracker) error {
+		return nil
+	})
+
+	_, _ = m.Cancel(queued.ID)
+	close(release)
+	_, _ = m.Wait(context.Background(), running.ID)
+
+	if len(finished) != 2 {
+		t.Fatalf("expected OnFinish to be called twice, got %d", len(finished))
+	}
+
+	if finished[0].ID != queued.ID || finished[0].State != StateCancelled {
+		t.Errorf("expected the cancelled queued job first, got %+v", finished[0])
+	}
+
+	if finished[1].ID != running.ID || finished[1].State != StateDone {
+		t.Errorf("expected the finished job second, got %+v", finished[1])
+	}
+}
+
+func TestManager_Queue(t *testing.T) {
+	m := NewManager(time.Minute, 1)
+
+	release := make(chan struct{})
+	blocking := func(ctx context.Context, tr *Tracker) error {
+		<-release
+
+		return nil
+	}
+
+	first := m.Start(KindPage, "https://example.com/1", blocking)
+	second := m.Start(KindPage, "https://example.com/2", blocking)
+	third := m.Start(KindPage, "https://example.com/3", blocking)
+
+	if first.QueuePosition != 0 {
+		t.Errorf("expected first job to run right away, got position %d", first.QueuePosition)
+	}
+
+	if second.QueuePosition != 1 || third.QueuePosition != 2 {
+		t.Errorf("unexpected queue positions: %d and %d", second.QueuePosition, third.QueuePosition)
+	}
+
+	second, err := m.Cancel(second.ID)
+	if err != nil || second.State != StateCancelled {
+		t.Fatalf("expected queued job to be cancelled, got %q (%v)", second.State, err)
+	}
+
+	third, _ = m.Get(third.ID)
+	if third.QueuePosition != 1 {
+		t.Errorf("expected third job to move up the queue, got position %d", third.QueuePosition)
+	}
+
+	close(release)
+
+	third, _ = m.Wait(context.Background(), third.ID)
+	if third.State != StateDone {
+		t.Errorf("expected queued job to run once a slot is free, got %q", third.State)
+	}
+}
+
+func TestManager_Subscribe(t *testing.T) {
+	m := NewManager(time.Minute, 0)
+	release := make(chan struct{})
+
+	job := m.Start(KindPage, "https://example.com", func(ctx context.Context, tr *Tracker) error {
+		<-release
+
+		tr.SetState(StateCheckingLinks)
+		tr.LinkChecked(parser.HyperLink{Raw: "/a", StatusCode: 200}, 1, 1)
+
+		return nil
+	})
+
+	events, unsubscribe, err := m.Subscribe(job.ID)
+	if err != nil {
+		t.Fatalf("Subscribe returned an error: %v", err)
+	}
+	defer unsubscribe()
+
+	close(release)
+
+	var types []EventType
+
+	for ev := range events {
+		types = append(types, ev.Type)
+
+		if ev.Type == EventLink && (ev.Link == nil || ev.Link.Raw != "/a" || ev.Job.Done != 1) {
+			t.Errorf("unexpected link event: %+v", ev)
+		}
+	}
+
+	if len(types) != 2 || types[0] != EventPhase || types[1] != EventLink {
+		t.Errorf("unexpected events: %v", types)
+	}
+
+	// Finished jobs have nothing left to send
+	events, _, err = m.Subscribe(job.ID)
+	if err != nil {
+		t.Fatalf("Subscribe returned an error: %v", err)
+	}
+
+	if _, ok := <-events; ok {
+		t.Errorf("expected the channel of a finished job to be closed")
+	}
+
+	_, _, err = m.Subscribe("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
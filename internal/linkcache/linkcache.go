@@ -0,0 +1,143 @@
+package linkcache
+
+import (
+	"github.com/hugmouse/scan24/internal/cache"
+	"github.com/hugmouse/scan24/internal/parser"
+	"sync/atomic"
+	"time"
+)
+
+// Cache remembers link check results across scans, keyed by parser.LinkKey.
+//
+// Working links are kept for the success TTL, broken ones for the (usually shorter) failure TTL,
+// so that a site that was down a minute ago gets another chance soon.
+type Cache struct {
+	successes *cache.Cache[string, entry]
+	failures  *cache.Cache[string, entry]
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type entry struct {
+	link      parser.HyperLink
+	checkedAt time.Time
+}
+
+// Stats are the lookup counters and the size of a Cache.
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+}
+
+// New creates a Cache with separate TTLs for working and broken links,
+// a TTL of zero means that such links are not cached at all.
+//
+// Working and broken links are each bounded to maxEntries, the least recently used are dropped first.
+// Zero leaves them unbounded.
+func New(successTTL, failureTTL time.Duration, maxEntries int) *Cache {
+	c := &Cache{}
+
+	if successTTL > 0 {
+		c.successes = cache.NewWithOptions(cache.Options[string, entry]{TTL: successTTL, MaxEntries: maxEntries})
+	}
+
+	if failureTTL > 0 {
+		c.failures = cache.NewWithOptions(cache.Options[string, entry]{TTL: failureTTL, MaxEntries: maxEntries})
+	}
+
+	return c
+}
+
+// Get returns the cached result of the link with the given key.
+//
+// Only the result of the check is cached, Raw, HrefType and where the link
+// was found are up to the caller.
+func (c *Cache) Get(key string) (parser.HyperLink, bool) {
+	var (
+		success, failure     entry
+		okSuccess, okFailure bool
+	)
+
+	if c.successes != nil {
+		success, okSuccess = c.successes.Get(key)
+	}
+
+	if c.failures != nil {
+		failure, okFailure = c.failures.Get(key)
+	}
+
+	// A link may have been checked again with the cache bypassed, the latest result wins
+	e, ok := success, okSuccess
+	if okFailure && (!okSuccess || failure.checkedAt.After(success.checkedAt)) {
+		e, ok = failure, true
+	}
+
+	if !ok {
+		c.misses.Add(1)
+
+		return parser.HyperLink{}, false
+	}
+
+	c.hits.Add(1)
+
+	return e.link, true
+}
+
+// Set stores the result of a link check.
+//
+// Links that were not actually checked, because the scan was cancelled,
+// robots.txt disallows them or the URL can not be requested at all, are not stored.
+// Neither are throttled links, a 429 or 503 says nothing about the link but about how busy its host was.
+func (c *Cache) Set(key string, link parser.HyperLink) {
+	switch link.Failure {
+	case parser.FailureCancelled, parser.FailureUnsupportedScheme, parser.FailureInvalidURL, parser.FailureRobotsDisallowed:
+		return
+	}
+
+	if parser.IsThrottled(link.StatusCode) {
+		return
+	}
+
+	link.Element, link.Attr, link.Occurrences, link.Retries = "", "", nil, 0
+	e := entry{link: link, checkedAt: time.Now()}
+
+	store := c.successes
+	if link.Broken() {
+		store = c.failures
+	}
+
+	if store != nil {
+		store.Set(key, e)
+	}
+}
+
+// Stats returns the number of hits, misses and evictions since the cache was created
+// and the number of links it holds.
+func (c *Cache) Stats() Stats {
+	stats := Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+
+	for _, store := range []*cache.Cache[string, entry]{c.successes, c.failures} {
+		if store != nil {
+			s := store.Stats()
+			stats.Evictions += s.Evictions
+			stats.Entries += s.Entries
+		}
+	}
+
+	return stats
+}
+
+// Close stops the cleanup of expired links.
+func (c *Cache) Close() {
+	for _, store := range []*cache.Cache[string, entry]{c.successes, c.failures} {
+		if store != nil {
+			store.Close()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package linkcache
+
+import (
+	"github.com/hugmouse/scan24/internal/parser"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCache_TTLs(t *testing.T) {
+	c := New(time.Minute, 10*time.Millisecond, 0)
+	defer c.Close()
+
+	c.Set("https://ok.example/", parser.HyperLink{StatusCode: 200})
+	c.Set("https://down.example/", parser.HyperLink{StatusCode: 500})
+
+	if link, ok := c.Get("https://ok.example/"); !ok || link.StatusCode != 200 {
+		t.Errorf("expected a cached success, got %+v (found=%v)", link, ok)
+	}
+
+	if link, ok := c.Get("https://down.example/"); !ok || link.StatusCode != 500 {
+		t.Errorf("expected a cached failure, got %+v (found=%v)", link, ok)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+
+	if _, ok := c.Get("https://down.example/"); ok {
+		t.Error("expected the failure to expire before the success")
+	}
+
+	if _, ok := c.Get("https://ok.example/"); !ok {
+		t.Error("expected the success to still be cached")
+	}
+
+	if stats := c.Stats(); stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCache_LatestResultWins(t *testing.T) {
+	c := New(time.Minute, time.Minute, 0)
+	defer c.Close()
+
+	c.Set("https://flaky.example/", parser.HyperLink{StatusCode: 200})
+	c.Set("https://flaky.example/", parser.HyperLink{StatusCode: 500})
+
+	if link, _ := c.Get("https://flaky.example/"); link.StatusCode != 500 {
+		t.Errorf("expected the latest result, got %d", link.StatusCode)
+	}
+
+	c.Set("https://flaky.example/", parser.HyperLink{StatusCode: 200})
+
+	if link, _ := c.Get("https://flaky.example/"); link.StatusCode != 200 {
+		t.Errorf("expected the latest result, got %d", link.StatusCode)
+	}
+}
+
+func TestCache_SkipsUncheckedLinks(t *testing.T) {
+	c := New(time.Minute, time.Minute, 0)
+	defer c.Close()
+
+	c.Set("https://slow.example/", parser.HyperLink{Failure: parser.FailureCancelled})
+
+	if _, ok := c.Get("https://slow.example/"); ok {
+		t.Error("expected links of cancelled scans not to be cached")
+	}
+
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		c.Set("https://busy.example/", parser.HyperLink{StatusCode: status})
+
+		if _, ok := c.Get("https://busy.example/"); ok {
+			t.Errorf("expected a throttled link (%d) not to be cached", status)
+		}
+	}
+}
+
+func TestCache_ZeroFailureTTL(t *testing.T) {
+	c := New(time.Minute, 0, 0)
+	defer c.Close()
+
+	c.Set("https://down.example/", parser.HyperLink{Failure: parser.FailureConnectionRefused})
+
+	if _, ok := c.Get("https://down.example/"); ok {
+		t.Error("expected failures not to be cached")
+	}
+}
+
+func TestCache_MaxEntries(t *testing.T) {
+	c := New(time.Minute, time.Minute, 2)
+	defer c.Close()
+
+	c.Set("https://a.example/", parser.HyperLink{StatusCode: 200})
+	c.Set("https://b.example/", parser.HyperLink{StatusCode: 200})
+	c.Get("https://a.example/")
+	c.Set("https://c.example/", parser.HyperLink{StatusCode: 200})
+
+	if _, ok := c.Get("https://b.example/"); ok {
+		t.Error("expected the least recently used link to be evicted")
+	}
+
+	if _, ok := c.Get("https://a.example/"); !ok {
+		t.Error("expected the recently used link to be kept")
+	}
+
+	if stats := c.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
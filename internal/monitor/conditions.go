@@ -0,0 +1,151 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/diff"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/parser"
+	"strings"
+)
+
+// Condition is something about a scan that raises an alert.
+type Condition string
+
+const (
+	// ConditionScanFailed alerts when the page itself could not be scanned.
+	ConditionScanFailed Condition = "scan_failed"
+	// ConditionInternalLinkBroken alerts when any internal link did not end up with a 2xx response.
+	ConditionInternalLinkBroken Condition = "internal_link_broken"
+	// ConditionAnyLinkBroken alerts when any link is broken, see parser.HyperLink.Broken.
+	ConditionAnyLinkBroken Condition = "any_link_broken"
+	// ConditionNewBrokenLinks alerts when links are broken that were not in the previous run.
+	ConditionNewBrokenLinks Condition = "new_broken_links"
+	// ConditionTitleChanged alerts when the title differs from the previous run.
+	ConditionTitleChanged Condition = "title_changed"
+	// ConditionLoginFormDisappeared alerts when the previous run found a login form and this one did not.
+	ConditionLoginFormDisappeared Condition = "login_form_disappeared"
+)
+
+// DefaultConditions are evaluated for monitors that don't pick their own.
+var DefaultConditions = []Condition{ConditionScanFailed, ConditionInternalLinkBroken}
+
+// ErrInvalidCondition is returned for unknown conditions.
+var ErrInvalidCondition = errors.New("unknown alert condition")
+
+// ParseCondition validates a condition given by the user.
+func ParseCondition(s string) (Condition, error) {
+	switch c := Condition(s); c {
+	case ConditionScanFailed, ConditionInternalLinkBroken, ConditionAnyLinkBroken,
+		ConditionNewBrokenLinks, ConditionTitleChanged, ConditionLoginFormDisappeared:
+		return c, nil
+	}
+
+	return "", fmt.Errorf("%q: %w", s, ErrInvalidCondition)
+}
+
+// Alert is a condition that was met by a run.
+type Alert struct {
+	Condition Condition `json:"condition"`
+	Message   string    `json:"message"`
+}
+
+// maxListedLinks is the number of links an alert message names before it just counts the rest.
+const maxListedLinks = 5
+
+// Evaluate checks the condition against a finished scan, previous is the scan of the run before, if any.
+//
+// Conditions that compare two scans are never met by the first run.
+func (c Condition) Evaluate(previous *jobs.Job, job jobs.Job) (Alert, bool) {
+	if job.State != jobs.StateDone {
+		if c == ConditionScanFailed {
+			return Alert{Condition: c, Message: "Scan " + string(job.State) + ": " + job.Error}, true
+		}
+
+		return Alert{}, false
+	}
+
+	page := job.Page
+
+	switch c {
+	case ConditionInternalLinkBroken:
+		var links []string
+
+		for _, l := range page.HyperLinks {
+			if parser.IsInternal(parser.HrefType(l.HrefType)) && checked(l) && (l.Broken() || l.StatusCode/100 != 2) {
+				links = append(links, describe(l.Raw, l.StatusCode, l.Failure))
+			}
+		}
+
+		return linksAlert(c, "internal links are not 2xx", links)
+	case ConditionAnyLinkBroken:
+		var links []string
+
+		for _, l := range page.BrokenLinks() {
+			links = append(links, describe(l.Raw, l.StatusCode, l.Failure))
+		}
+
+		return linksAlert(c, "links are broken", links)
+	}
+
+	if previous == nil || previous.State != jobs.StateDone {
+		return Alert{}, false
+	}
+
+	switch c {
+	case ConditionNewBrokenLinks:
+		report := diff.Compare(previous.Page, page)
+
+		var links []string
+
+		for _, l := range report.AddedLinks {
+			if l.Broken {
+				links = append(links, describe(l.Raw, l.StatusCode, l.Failure))
+			}
+		}
+
+		for _, l := range report.ChangedLinks {
+			if l.Broke() {
+				links = append(links, describe(l.New.Raw, l.New.StatusCode, l.New.Failure))
+			}
+		}
+
+		return linksAlert(c, "links broke since the previous run", links)
+	case ConditionTitleChanged:
+		if previous.Page.Title != page.Title {
+			return Alert{Condition: c, Message: fmt.Sprintf("Title changed from %q to %q", previous.Page.Title, page.Title)}, true
+		}
+	case ConditionLoginFormDisappeared:
+		if previous.Page.HasLoginForm && !page.HasLoginForm {
+			return Alert{Condition: c, Message: "Login form disappeared"}, true
+		}
+	}
+
+	return Alert{}, false
+}
+
+// checked reports whether the link was requested at all.
+func checked(l parser.HyperLink) bool {
+	return l.Failure != parser.FailureUnsupportedScheme && l.Failure != parser.FailureRobotsDisallowed
+}
+
+func describe(raw string, status int, failure parser.FailureKind) string {
+	if failure != "" {
+		return fmt.Sprintf("%s (%s)", raw, failure)
+	}
+
+	return fmt.Sprintf("%s (%d)", raw, status)
+}
+
+func linksAlert(c Condition, what string, links []string) (Alert, bool) {
+	if len(links) == 0 {
+		return Alert{}, false
+	}
+
+	msg := fmt.Sprintf("%d %s: %s", len(links), what, strings.Join(links[:min(len(links), maxListedLinks)], ", "))
+	if len(links) > maxListedLinks {
+		msg += ", …"
+	}
+
+	return Alert{Condition: c, Message: msg}, true
+}
@@ -0,0 +1,552 @@
+// Package monitor scans pages on a schedule and raises alerts about the results.
+package monitor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/scanopts"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("monitor not found")
+	// ErrTooFrequent is returned for schedules that run more often than Scheduler.MinInterval.
+	ErrTooFrequent = errors.New("schedule runs too often")
+	// ErrNeverRuns is returned for schedules without any run time, like "0 0 30 2 *".
+	ErrNeverRuns = errors.New("schedule never runs")
+)
+
+// maxRuns is the number of runs a monitor remembers.
+const maxRuns = 10
+
+// Monitor scans a URL on a schedule.
+type Monitor struct {
+	ID         string           `json:"id"`
+	URL        string           `json:"url"`
+	Schedule   string           `json:"schedule"`
+	Options    scanopts.Options `json:"options"` // handed to the scan of every run
+	Conditions []Condition      `json:"conditions"`
+	Paused     bool             `json:"paused"`
+	CreatedAt  time.Time        `json:"created_at"`
+	// NextRun is zero while the monitor is paused.
+	NextRun time.Time `json:"next_run,omitzero"`
+	// Runs are the latest runs, newest first.
+	Runs []Run `json:"runs,omitempty"`
+}
+
+// clone copies the slices of m, so that callers can't change the monitors of the Scheduler.
+func (m Monitor) clone() Monitor {
+	m.Conditions = slices.Clone(m.Conditions)
+	m.Runs = slices.Clone(m.Runs)
+
+	for i := range m.Runs {
+		m.Runs[i].Alerts = slices.Clone(m.Runs[i].Alerts)
+	}
+
+	return m
+}
+
+// Run is a single scan of a monitor.
+type Run struct {
+	JobID      string     `json:"job_id,omitempty"`
+	State      jobs.State `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt time.Time  `json:"finished_at"`
+	Alerts     []Alert    `json:"alerts,omitempty"`
+}
+
+// RunFunc scans the URL of a monitor and returns the finished job.
+//
+// ctx is cancelled when the Scheduler is closed.
+type RunFunc func(ctx context.Context, m Monitor) (jobs.Job, error)
+
+// Scheduler runs monitors, its exported fields have to be set before Load or Start are called.
+type Scheduler struct {
+	run RunFunc
+
+	mu       sync.Mutex
+	monitors map[string]*entry
+	// wake tells the loop that the next run time may have changed
+	wake chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// Path is the JSON file that monitors are kept in, they only live in memory if empty.
+	Path string
+	// MinInterval rejects schedules that run more often.
+	MinInterval time.Duration
+	// Lookup finds the job of an earlier run, so that conditions comparing two runs work after a restart.
+	Lookup func(id string) (jobs.Job, bool)
+	// OnAlert is called for every run that raised alerts.
+	OnAlert func(m Monitor, run Run)
+}
+
+type entry struct {
+	monitor  Monitor
+	schedule Schedule
+	running  bool
+	// last is the job of the latest run, loaded with Lookup if needed
+	last *jobs.Job
+}
+
+// NewScheduler creates a Scheduler that scans with run.
+func NewScheduler(run RunFunc) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Scheduler{
+		run:      run,
+		monitors: make(map[string]*entry),
+		wake:     make(chan struct{}, 1),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Load reads the monitors from Path, a missing file is not an error.
+func (s *Scheduler) Load() error {
+	if s.Path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("loading monitors: %w", err)
+	}
+
+	var monitors []Monitor
+
+	err = json.Unmarshal(data, &monitors)
+	if err != nil {
+		return fmt.Errorf("loading monitors: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	for _, m := range monitors {
+		schedule, err := ParseSchedule(m.Schedule)
+		if err != nil {
+			return fmt.Errorf("loading monitor %s: %w", m.ID, err)
+		}
+
+		// Runs that were missed while the server was down are not made up for
+		if !m.Paused && m.NextRun.Before(now) {
+			m.NextRun = schedule.Next(now)
+		}
+
+		s.monitors[m.ID] = &entry{monitor: m, schedule: schedule}
+	}
+
+	return nil
+}
+
+// Start runs the monitors in the background until Close is called.
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+
+	go s.loop()
+}
+
+// Close stops the scheduler, cancels the runs in progress and waits for them to finish.
+func (s *Scheduler) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Add validates and registers a new monitor, only its URL, Schedule, Options, Conditions and Paused are used.
+func (s *Scheduler) Add(m Monitor) (Monitor, error) {
+	schedule, err := s.parseSchedule(m.Schedule)
+	if err != nil {
+		return Monitor{}, err
+	}
+
+	if len(m.Conditions) == 0 {
+		m.Conditions = DefaultConditions
+	}
+
+	for _, c := range m.Conditions {
+		_, err = ParseCondition(string(c))
+		if err != nil {
+			return Monitor{}, err
+		}
+	}
+
+	now := time.Now()
+
+	m = Monitor{
+		ID:         newID(),
+		URL:        m.URL,
+		Schedule:   m.Schedule,
+		Options:    m.Options,
+		Conditions: m.Conditions,
+		Paused:     m.Paused,
+		CreatedAt:  now,
+	}
+
+	if !m.Paused {
+		m.NextRun = schedule.Next(now)
+	}
+
+	s.mu.Lock()
+	s.monitors[m.ID] = &entry{monitor: m.clone(), schedule: schedule}
+	s.save()
+	s.mu.Unlock()
+
+	s.notify()
+
+	return m, nil
+}
+
+// parseSchedule parses a schedule and checks that it runs, but not more often than MinInterval.
+func (s *Scheduler) parseSchedule(value string) (Schedule, error) {
+	schedule, err := ParseSchedule(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// The shortest gap of the next few runs is good enough for cron expressions like "*/5 9-17 * * *"
+	t := schedule.Next(time.Now())
+	if t.IsZero() {
+		return nil, ErrNeverRuns
+	}
+
+	for range 10 {
+		next := schedule.Next(t)
+		if next.IsZero() {
+			break
+		}
+
+		if next.Sub(t) < s.MinInterval {
+			return nil, fmt.Errorf("%w, at most once every %s", ErrTooFrequent, s.MinInterval)
+		}
+
+		t = next
+	}
+
+	return schedule, nil
+}
+
+// Get returns a monitor by ID.
+func (s *Scheduler) Get(id string) (Monitor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.monitors[id]
+	if !ok {
+		return Monitor{}, ErrNotFound
+	}
+
+	return e.monitor.clone(), nil
+}
+
+// List returns all monitors, oldest first.
+func (s *Scheduler) List() []Monitor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Monitor, 0, len(s.monitors))
+	for _, e := range s.monitors {
+		list = append(list, e.monitor.clone())
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+
+	return list
+}
+
+// Pause stops scheduling runs of a monitor, a run in progress still finishes.
+func (s *Scheduler) Pause(id string) (Monitor, error) {
+	return s.setPaused(id, true)
+}
+
+// Resume schedules the next run of a paused monitor.
+func (s *Scheduler) Resume(id string) (Monitor, error) {
+	return s.setPaused(id, false)
+}
+
+func (s *Scheduler) setPaused(id string, paused bool) (Monitor, error) {
+	s.mu.Lock()
+
+	e, ok := s.monitors[id]
+	if !ok {
+		s.mu.Unlock()
+
+		return Monitor{}, ErrNotFound
+	}
+
+	if e.monitor.Paused != paused {
+		e.monitor.Paused = paused
+		e.monitor.NextRun = time.Time{}
+
+		if !paused {
+			e.monitor.NextRun = e.schedule.Next(time.Now())
+		}
+
+		s.save()
+	}
+
+	m := e.monitor.clone()
+	s.mu.Unlock()
+
+	s.notify()
+
+	return m, nil
+}
+
+// Delete removes a monitor, a run in progress still finishes but is not recorded.
+func (s *Scheduler) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.monitors[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(s.monitors, id)
+	s.save()
+
+	return nil
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop starts the runs that are due and sleeps until the next one.
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.ctx.Done():
+			return
+		}
+
+		next := s.startDue(time.Now())
+
+		timer.Stop()
+
+		if next.IsZero() {
+			// Nothing is scheduled, only a change can bring something up
+			continue
+		}
+
+		timer.Reset(max(time.Until(next), 0))
+	}
+}
+
+// startDue starts the monitors whose run is due and returns the earliest next run time.
+func (s *Scheduler) startDue(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+
+	for _, e := range s.monitors {
+		m := &e.monitor
+		if m.Paused || m.NextRun.IsZero() {
+			continue
+		}
+
+		if !m.NextRun.After(now) {
+			m.NextRun = e.schedule.Next(now)
+
+			// A monitor that is still running when its next run is due skips it
+			if e.running {
+				log.Printf("[monitor] Skipping a run of %s, the previous one is still going", m.URL)
+			} else {
+				e.running = true
+				s.wg.Add(1)
+
+				go s.runMonitor(e, m.clone())
+			}
+		}
+
+		if !m.NextRun.IsZero() && (next.IsZero() || m.NextRun.Before(next)) {
+			next = m.NextRun
+		}
+	}
+
+	return next
+}
+
+// runMonitor scans the URL of a monitor and records the run.
+func (s *Scheduler) runMonitor(e *entry, m Monitor) {
+	defer s.wg.Done()
+
+	run := Run{StartedAt: time.Now()}
+
+	job, err := s.run(s.ctx, m)
+
+	run.FinishedAt = time.Now()
+	run.JobID = job.ID
+	run.State = job.State
+
+	if err != nil {
+		run.State = jobs.StateFailed
+		run.Error = err.Error()
+		job.State = jobs.StateFailed
+		job.Error = err.Error()
+	} else {
+		run.Error = job.Error
+	}
+
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	previous := s.previous(e)
+
+	for _, c := range m.Conditions {
+		if alert, ok := c.Evaluate(previous, job); ok {
+			run.Alerts = append(run.Alerts, alert)
+		}
+	}
+
+	s.mu.Lock()
+
+	e.running = false
+
+	if job.State == jobs.StateDone {
+		e.last = &job
+	}
+
+	// Deleted while running
+	if _, ok := s.monitors[m.ID]; !ok {
+		s.mu.Unlock()
+
+		return
+	}
+
+	e.monitor.Runs = append([]Run{run}, e.monitor.Runs[:min(len(e.monitor.Runs), maxRuns-1)]...)
+	s.save()
+	m = e.monitor.clone()
+	s.mu.Unlock()
+
+	if len(run.Alerts) > 0 {
+		log.Printf("[monitor] %d alerts for %s: %v", len(run.Alerts), m.URL, run.Alerts)
+
+		if s.OnAlert != nil {
+			s.OnAlert(m, run)
+		}
+	}
+}
+
+// previous returns the job of the latest successful run of a monitor, if it is still known.
+func (s *Scheduler) previous(e *entry) *jobs.Job {
+	s.mu.Lock()
+	last := e.last
+
+	var id string
+
+	if last == nil {
+		for _, r := range e.monitor.Runs {
+			if r.State == jobs.StateDone && r.JobID != "" {
+				id = r.JobID
+
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if last != nil || id == "" || s.Lookup == nil {
+		return last
+	}
+
+	job, ok := s.Lookup(id)
+	if !ok {
+		return nil
+	}
+
+	return &job
+}
+
+// save writes the monitors to Path, s.mu must be held.
+//
+// Failing to save is logged only, the monitors keep running from memory.
+func (s *Scheduler) save() {
+	if s.Path == "" {
+		return
+	}
+
+	monitors := make([]Monitor, 0, len(s.monitors))
+	for _, e := range s.monitors {
+		monitors = append(monitors, e.monitor)
+	}
+
+	sort.Slice(monitors, func(i, j int) bool {
+		return monitors[i].CreatedAt.Before(monitors[j].CreatedAt)
+	})
+
+	err := writeFile(s.Path, monitors)
+	if err != nil {
+		log.Printf("[monitor] Error saving monitors: %v", err)
+	}
+}
+
+// writeFile replaces path with the JSON of v through a temporary file.
+func writeFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,200 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	start := time.Date(2025, time.January, 31, 10, 7, 30, 0, time.UTC) // a Friday
+
+	tests := []struct {
+		schedule string
+		want     time.Time
+	}{
+		{"@every 90s", start.Add(90 * time.Second)},
+		{"*/15 * * * *", time.Date(2025, time.January, 31, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2025, time.February, 3, 9, 0, 0, 0, time.UTC)},
+		{"30 8 1 * *", time.Date(2025, time.February, 1, 8, 30, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"5/20 10 * * *", time.Date(2025, time.January, 31, 10, 25, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2025, time.February, 2, 12, 0, 0, 0, time.UTC)},
+		// Restricted day of month and day of week match either
+		{"0 0 15 * sat", time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2025, time.January, 31, 11, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.schedule)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) returned an error: %v", tt.schedule, err)
+
+			continue
+		}
+
+		if got := schedule.Next(start); !got.Equal(tt.want) {
+			t.Errorf("Next of %q: got %v want %v", tt.schedule, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "@every -1m", "@sometimes"} {
+		_, err := ParseSchedule(invalid)
+		if !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("ParseSchedule(%q): expected ErrInvalidSchedule, got %v", invalid, err)
+		}
+	}
+}
+
+func TestCondition_Evaluate(t *testing.T) {
+	previous := jobs.Job{State: jobs.StateDone, Page: scanner.PageData{
+		Title:        "Shop",
+		HasLoginForm: true,
+		HyperLinks:   []parser.HyperLink{{Raw: "/a", HrefType: string(parser.SameOrigin), StatusCode: 200}},
+	}}
+
+	job := jobs.Job{State: jobs.StateDone, Page: scanner.PageData{
+		Title: "Shop!",
+		HyperLinks: []parser.HyperLink{
+			{Raw: "/a", HrefType: string(parser.SameOrigin), StatusCode: 404},
+			{Raw: "/moved", HrefType: string(parser.SameOrigin), StatusCode: 301},
+			{Raw: "https://other.example", HrefType: string(parser.External), StatusCode: 500},
+			{Raw: "mailto:a@example.com", HrefType: string(parser.Protocol), Failure: parser.FailureUnsupportedScheme},
+		},
+	}}
+
+	tests := []struct {
+		condition Condition
+		previous  *jobs.Job
+		want      bool
+	}{
+		{ConditionInternalLinkBroken, nil, true},
+		{ConditionAnyLinkBroken, nil, true},
+		{ConditionNewBrokenLinks, &previous, true},
+		{ConditionNewBrokenLinks, nil, false},
+		{ConditionTitleChanged, &previous, true},
+		{ConditionLoginFormDisappeared, &previous, true},
+		{ConditionScanFailed, &previous, false},
+	}
+
+	for _, tt := range tests {
+		alert, ok := tt.condition.Evaluate(tt.previous, job)
+		if ok != tt.want {
+			t.Errorf("%s: got %v want %v (%+v)", tt.condition, ok, tt.want, alert)
+		}
+	}
+
+	alert, _ := ConditionInternalLinkBroken.Evaluate(nil, job)
+	if alert.Message != "2 internal links are not 2xx: /a (404), /moved (301)" {
+		t.Errorf("unexpected message: %q", alert.Message)
+	}
+
+	_, ok := ConditionScanFailed.Evaluate(nil, jobs.Job{State: jobs.StateFailed, Error: "boom"})
+	if !ok {
+		t.Error("expected a failed scan to raise an alert")
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	var runs atomic.Int32
+
+	s := NewScheduler(func(ctx context.Context, m Monitor) (jobs.Job, error) {
+		n := runs.Add(1)
+
+		title := "Before"
+		if n > 1 {
+			title = "After"
+		}
+
+		return jobs.Job{ID: newID(), State: jobs.StateDone, Page: scanner.PageData{Title: title}}, nil
+	})
+	s.Path = filepath.Join(t.TempDir(), "monitors.json")
+
+	alerts := make(chan Run, 10)
+	s.OnAlert = func(m Monitor, run Run) {
+		alerts <- run
+	}
+
+	s.Start()
+	defer s.Close()
+
+	_, err := s.Add(Monitor{URL: "https://example.com", Schedule: "* * * * 8"})
+	if !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("expected ErrInvalidSchedule, got %v", err)
+	}
+
+	_, err = s.Add(Monitor{URL: "https://example.com", Schedule: "@every 1s", Conditions: []Condition{"sometimes"}})
+	if !errors.Is(err, ErrInvalidCondition) {
+		t.Errorf("expected ErrInvalidCondition, got %v", err)
+	}
+
+	m, err := s.Add(Monitor{URL: "https://example.com", Schedule: "@every 20ms", Conditions: []Condition{ConditionTitleChanged}})
+	if err != nil {
+		t.Fatalf("Add returned an error: %v", err)
+	}
+
+	select {
+	case run := <-alerts:
+		if len(run.Alerts) != 1 || run.Alerts[0].Condition != ConditionTitleChanged {
+			t.Errorf("unexpected alerts: %+v", run.Alerts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the title change to raise an alert")
+	}
+
+	m, err = s.Pause(m.ID)
+	if err != nil || !m.Paused || !m.NextRun.IsZero() {
+		t.Fatalf("unexpected paused monitor: %+v (%v)", m, err)
+	}
+
+	// Monitors are saved and scheduled again after a restart
+	loaded := NewScheduler(nil)
+	loaded.Path = s.Path
+
+	err = loaded.Load()
+	if err != nil {
+		t.Fatalf("Load returned an error: %v", err)
+	}
+
+	list := loaded.List()
+	if len(list) != 1 || list[0].ID != m.ID || !list[0].Paused || len(list[0].Runs) == 0 {
+		t.Errorf("unexpected loaded monitors: %+v", list)
+	}
+
+	err = s.Delete(m.ID)
+	if err != nil {
+		t.Errorf("Delete returned an error: %v", err)
+	}
+
+	_, err = s.Get(m.ID)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestScheduler_MinInterval(t *testing.T) {
+	s := NewScheduler(nil)
+	s.MinInterval = time.Hour
+
+	_, err := s.Add(Monitor{URL: "https://example.com", Schedule: "*/5 * * * *"})
+	if !errors.Is(err, ErrTooFrequent) {
+		t.Errorf("expected ErrTooFrequent, got %v", err)
+	}
+
+	_, err = s.Add(Monitor{URL: "https://example.com", Schedule: "0 0 30 2 *"})
+	if !errors.Is(err, ErrNeverRuns) {
+		t.Errorf("expected ErrNeverRuns, got %v", err)
+	}
+
+	_, err = s.Add(Monitor{URL: "https://example.com", Schedule: "@daily"})
+	if err != nil {
+		t.Errorf("Add returned an error: %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSchedule is returned by ParseSchedule for schedules it can't read.
+var ErrInvalidSchedule = errors.New("schedule must be a cron expression like \"*/30 * * * *\" or an interval like \"@every 1h\"")
+
+// Schedule tells when a monitor runs next.
+type Schedule interface {
+	// Next returns the first run time after t, the zero time if there is none.
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule reads a schedule given by the user, one of
+//   - a cron expression with the five fields minute, hour, day of month, month and day of week,
+//     like "0 9 * * mon-fri", evaluated in the local time zone
+//   - "@every" and a duration, like "@every 15m"
+//   - "@hourly", "@daily" (or "@midnight"), "@weekly", "@monthly" and "@yearly" (or "@annually")
+func ParseSchedule(s string) (Schedule, error) {
+	s = strings.TrimSpace(s)
+
+	if rest, ok := strings.CutPrefix(s, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%q: %w", s, ErrInvalidSchedule)
+		}
+
+		return interval(d), nil
+	}
+
+	switch s {
+	case "@hourly":
+		s = "0 * * * *"
+	case "@daily", "@midnight":
+		s = "0 0 * * *"
+	case "@weekly":
+		s = "0 0 * * 0"
+	case "@monthly":
+		s = "0 0 1 * *"
+	case "@yearly", "@annually":
+		s = "0 0 1 1 *"
+	}
+
+	c, err := parseCron(s)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", s, ErrInvalidSchedule)
+	}
+
+	return c, nil
+}
+
+// interval runs a fixed time after the previous run.
+type interval time.Duration
+
+func (i interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// cron is a parsed cron expression, every field is a bit set of the values it matches.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// Like in crontab, a day matches either field if both of them are restricted.
+	domAny, dowAny bool
+}
+
+// field describes a cron field, names are the values from min on.
+type field struct {
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField = field{min: 0, max: 59}
+	hourField   = field{min: 0, max: 23}
+	domField    = field{min: 1, max: 31}
+	monthField  = field{min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// 7 is Sunday as well
+	dowField = field{min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+func parseCron(s string) (*cron, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, ErrInvalidSchedule
+	}
+
+	var (
+		c   cron
+		err error
+	)
+
+	for i, target := range []*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow} {
+		f := []field{minuteField, hourField, domField, monthField, dowField}[i]
+
+		*target, err = f.parse(fields[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	c.domAny = fields[2] == "*" || fields[2] == "?"
+	c.dowAny = fields[4] == "*" || fields[4] == "?"
+
+	return &c, nil
+}
+
+// parse reads a comma-separated list of values, ranges like 1-5 and steps like */15 or 10-50/10.
+func (f field) parse(s string) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(s, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, ErrInvalidSchedule
+			}
+		}
+
+		var lo, hi int
+
+		switch {
+		case rangePart == "*" || rangePart == "?":
+			lo, hi = f.min, f.max
+		case strings.Contains(rangePart, "-"):
+			loPart, hiPart, _ := strings.Cut(rangePart, "-")
+
+			var err error
+
+			lo, err = f.value(loPart)
+			if err != nil {
+				return 0, err
+			}
+
+			hi, err = f.value(hiPart)
+			if err != nil {
+				return 0, err
+			}
+
+			if lo > hi {
+				return 0, ErrInvalidSchedule
+			}
+		default:
+			var err error
+
+			lo, err = f.value(rangePart)
+			if err != nil {
+				return 0, err
+			}
+
+			// "5/15" means from 5 on, every 15
+			hi = lo
+			if hasStep {
+				hi = f.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, ErrInvalidSchedule
+	}
+
+	return v, nil
+}
+
+// Next finds the next matching minute by skipping months, days and hours that don't match as a whole.
+func (c *cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// Expressions like "0 0 30 2 *" never match, give up after a few years
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
@@ -0,0 +1,225 @@
+// Package netguard keeps outgoing connections away from internal networks.
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+)
+
+// ErrBlocked is wrapped by every BlockedError.
+var ErrBlocked = errors.New("blocked by SSRF protection")
+
+var (
+	// sharedNet is the carrier-grade NAT range, cloud providers put services like metadata endpoints there.
+	sharedNet = netip.MustParsePrefix("100.64.0.0/10")
+	// nat64Net maps IPv4 addresses into IPv6, including internal ones.
+	nat64Net = netip.MustParsePrefix("64:ff9b::/96")
+)
+
+// BlockedError is returned when the Guard refuses a connection.
+type BlockedError struct {
+	Host   string
+	Addr   netip.Addr // zero if the connection was refused before resolving Host
+	Reason string
+}
+
+func (e *BlockedError) Error() string {
+	if !e.Addr.IsValid() || e.Addr.String() == e.Host {
+		return fmt.Sprintf("connection to %s is not allowed: %s", e.Host, e.Reason)
+	}
+
+	return fmt.Sprintf("connection to %s (%s) is not allowed: %s", e.Host, e.Addr, e.Reason)
+}
+
+func (e *BlockedError) Unwrap() error {
+	return ErrBlocked
+}
+
+// Guard decides which hosts and addresses can be connected to.
+//
+// Loopback, private, shared (CGNAT), NAT64, link-local, multicast and unspecified addresses are blocked by default.
+// Allowed networks and hosts lift that restriction, denied ones are blocked in any case.
+type Guard struct {
+	allowNets  []netip.Prefix
+	denyNets   []netip.Prefix
+	allowHosts []string
+	denyHosts  []string
+}
+
+// New creates a Guard from allow and deny lists.
+//
+// Every entry is either an IP address, a CIDR like 10.0.0.0/8 or a hostname.
+// Hostnames starting with a dot, like .corp.example, also match every subdomain.
+func New(allow, deny []string) (*Guard, error) {
+	g := &Guard{}
+
+	var err error
+
+	g.allowNets, g.allowHosts, err = parseList(allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allow list: %w", err)
+	}
+
+	g.denyNets, g.denyHosts, err = parseList(deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deny list: %w", err)
+	}
+
+	return g, nil
+}
+
+func parseList(entries []string) (nets []netip.Prefix, hosts []string, err error) {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			nets = append(nets, prefix.Masked())
+
+			continue
+		}
+
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			addr = addr.Unmap()
+			nets = append(nets, netip.PrefixFrom(addr, addr.BitLen()))
+
+			continue
+		}
+
+		hosts = append(hosts, normalizeHost(entry))
+	}
+
+	return nets, hosts, nil
+}
+
+// DialContext wraps d so that every connection it makes is checked by the Guard.
+//
+// The check runs on the address that is actually connected to, after DNS resolution,
+// so it holds for redirects and hostnames that resolve to a different address every time.
+func (g *Guard) DialContext(d *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		hostAllowed, err := g.checkHost(host)
+		if err != nil {
+			return nil, err
+		}
+
+		guarded := *d
+		guarded.Control = func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+
+			return g.checkAddr(host, addrPort.Addr(), hostAllowed)
+		}
+
+		return guarded.DialContext(ctx, network, address)
+	}
+}
+
+// CheckAddr reports whether a connection to host resolved to addr is allowed.
+func (g *Guard) CheckAddr(host string, addr netip.Addr) error {
+	hostAllowed, err := g.checkHost(host)
+	if err != nil {
+		return err
+	}
+
+	return g.checkAddr(host, addr, hostAllowed)
+}
+
+// checkHost applies the host lists, it reports whether the host is explicitly allowed.
+func (g *Guard) checkHost(host string) (bool, error) {
+	name := normalizeHost(host)
+
+	if matchHost(g.denyHosts, name) {
+		return false, &BlockedError{Host: host, Reason: "host is denied"}
+	}
+
+	return matchHost(g.allowHosts, name), nil
+}
+
+func (g *Guard) checkAddr(host string, addr netip.Addr, hostAllowed bool) error {
+	addr = addr.Unmap()
+
+	if matchNet(g.denyNets, addr) {
+		return &BlockedError{Host: host, Addr: addr, Reason: "address is denied"}
+	}
+
+	if hostAllowed || matchNet(g.allowNets, addr) {
+		return nil
+	}
+
+	reason := blockedReason(addr)
+	if reason != "" {
+		return &BlockedError{Host: host, Addr: addr, Reason: reason}
+	}
+
+	return nil
+}
+
+// blockedReason tells why addr is blocked by default, or returns an empty string if it is not.
+func blockedReason(addr netip.Addr) string {
+	switch {
+	case addr.IsUnspecified():
+		return "unspecified address"
+	case addr.IsLoopback():
+		return "loopback address"
+	case addr.IsLinkLocalUnicast():
+		return "link-local address"
+	case addr.IsPrivate():
+		return "private address"
+	case sharedNet.Contains(addr):
+		return "shared address"
+	case nat64Net.Contains(addr):
+		return "NAT64 address"
+	case addr.IsMulticast():
+		return "multicast address"
+	}
+
+	return ""
+}
+
+func matchNet(nets []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range nets {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchHost(hosts []string, name string) bool {
+	for _, h := range hosts {
+		if h == name {
+			return true
+		}
+
+		if strings.HasPrefix(h, ".") && (name == h[1:] || strings.HasSuffix(name, h)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+}
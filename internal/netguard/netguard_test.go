@@ -0,0 +1,87 @@
+package netguard
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestGuard_CheckAddr(t *testing.T) {
+	g, err := New([]string{"10.1.0.0/16", ".corp.example"}, []string{"203.0.113.7", "evil.example", "10.1.2.0/24"})
+	if err != nil {
+		t.Fatalf("New returned an error: %v", err)
+	}
+
+	tests := []struct {
+		host    string
+		addr    string
+		blocked bool
+	}{
+		{"example.com", "93.184.215.14", false},
+		{"localhost", "127.0.0.1", true},
+		{"localhost", "::1", true},
+		{"mapped.example", "::ffff:127.0.0.1", true},
+		{"metadata", "169.254.169.254", true},
+		{"alibaba-metadata", "100.100.100.200", true},
+		{"cgnat", "100.64.0.1", true},
+		{"nat64-localhost", "64:ff9b::7f00:1", true},
+		{"nat64-public", "64:ff9b::5db8:d70e", true},
+		{"intranet", "192.168.1.1", true},
+		{"intranet", "fd00::1", true},
+		{"multicast", "224.0.0.1", true},
+		{"any", "0.0.0.0", true},
+		{"allowed-net", "10.1.0.5", false},
+		{"denied-in-allowed-net", "10.1.2.5", true},
+		{"wiki.corp.example", "10.9.9.9", false},
+		{"corp.example", "127.0.0.1", false},
+		{"denied.example", "203.0.113.7", true},
+		{"evil.example", "93.184.215.14", true},
+		{"EVIL.example.", "93.184.215.14", true},
+	}
+
+	for _, tt := range tests {
+		err := g.CheckAddr(tt.host, netip.MustParseAddr(tt.addr))
+		if blocked := errors.Is(err, ErrBlocked); blocked != tt.blocked {
+			t.Errorf("CheckAddr(%q, %s): got blocked=%v want %v (err: %v)", tt.host, tt.addr, blocked, tt.blocked, err)
+		}
+	}
+}
+
+func TestGuard_New(t *testing.T) {
+	_, err := New([]string{"10.0.0.0/33"}, nil)
+	if err == nil {
+		t.Errorf("expected an invalid CIDR to be rejected")
+	}
+}
+
+func TestGuard_DialContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	newClient := func(allow []string) *http.Client {
+		g, err := New(allow, nil)
+		if err != nil {
+			t.Fatalf("New returned an error: %v", err)
+		}
+
+		return &http.Client{
+			Transport: &http.Transport{DialContext: g.DialContext(&net.Dialer{Timeout: time.Second})},
+		}
+	}
+
+	_, err := newClient(nil).Get(server.URL)
+	if !errors.Is(err, ErrBlocked) {
+		t.Errorf("expected the loopback test server to be blocked, got %v", err)
+	}
+
+	resp, err := newClient([]string{"127.0.0.0/8"}).Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected the allowed test server to be reachable: %v", err)
+	}
+
+	_ = resp.Body.Close()
+}
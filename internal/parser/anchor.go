@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+)
+
+// ErrMissingAnchor is returned when the target document of a link has no element for its fragment.
+var ErrMissingAnchor = errors.New("anchor not found")
+
+// HasAnchor reports whether doc has an element that fragment scrolls to:
+// one with a matching id, or an <a> with a matching name.
+//
+// See https://html.spec.whatwg.org/multipage/browsing-the-web.html#find-a-potential-indicated-element
+func HasAnchor(doc *goquery.Document, fragment string) bool {
+	found := false
+
+	doc.Find("[id]").EachWithBreak(func(_ int, sel *goquery.Selection) bool {
+		found = sel.AttrOr("id", "") == fragment
+
+		return !found
+	})
+
+	if found {
+		return true
+	}
+
+	doc.Find("a[name]").EachWithBreak(func(_ int, sel *goquery.Selection) bool {
+		found = sel.AttrOr("name", "") == fragment
+
+		return !found
+	})
+
+	return found
+}
+
+// IsSPAFragment reports whether fragment is a client-side route of a single-page app,
+// like "#!/users" or "#/users", rather than an anchor.
+func IsSPAFragment(fragment string) bool {
+	return strings.HasPrefix(fragment, "!") || strings.HasPrefix(fragment, "/")
+}
+
+// needsAnchor reports whether fragment has to point to an element of the document.
+//
+// An empty fragment and "top" scroll to the top of any document,
+// text fragments ("#:~:text=...") highlight text instead.
+func needsAnchor(fragment string) bool {
+	return fragment != "" && !strings.EqualFold(fragment, "top") && !strings.HasPrefix(fragment, ":~:")
+}
@@ -0,0 +1,437 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	allowedSchemes = map[string]struct{}{
+		"http":  {},
+		"https": {},
+	}
+)
+
+// UserAgent is sent with every request Scan24 makes.
+const UserAgent = "Scan24 (+https://github.com/hugmouse/scan24)"
+
+// DefaultLongRedirectChain is the number of redirects after which a chain is flagged as long.
+const DefaultLongRedirectChain = 2
+
+// defaultMaxRedirects mirrors the limit of http.Client without CheckRedirect.
+const defaultMaxRedirects = 10
+
+// ErrRedirectLoop is returned when a redirect leads back to a URL that was already visited.
+var ErrRedirectLoop = errors.New("redirect loop")
+
+// RedirectHop is a single redirect response of a link check.
+type RedirectHop struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Location   string `json:"location"`
+}
+
+// RedirectIssue is something worth fixing about the redirect chain of a link.
+type RedirectIssue string
+
+const (
+	// RedirectLoop means the chain leads back to a URL it already visited.
+	RedirectLoop RedirectIssue = "loop"
+	// RedirectDowngrade means one of the redirects goes from HTTPS to plain HTTP.
+	RedirectDowngrade RedirectIssue = "https_downgrade"
+	// RedirectLongChain means the chain has more redirects than Checker.LongRedirectChain.
+	RedirectLongChain RedirectIssue = "long_chain"
+	// RedirectUpdatable means the link only goes through permanent redirects
+	// and could point to its final destination instead.
+	RedirectUpdatable RedirectIssue = "update_link"
+)
+
+var redirectIssueLabels = map[RedirectIssue]string{
+	RedirectLoop:      "Redirect loop",
+	RedirectDowngrade: "HTTPS downgrade",
+	RedirectLongChain: "Long redirect chain",
+	RedirectUpdatable: "Link can be updated",
+}
+
+// Label is a short human-readable description of the issue.
+func (i RedirectIssue) Label() string {
+	if label, ok := redirectIssueLabels[i]; ok {
+		return label
+	}
+
+	return string(i)
+}
+
+// Checker checks links and records how they responded.
+type Checker struct {
+	Client *http.Client
+	// LongRedirectChain is the number of redirects a link may take before
+	// it is flagged with RedirectLongChain, DefaultLongRedirectChain if zero.
+	LongRedirectChain int
+
+	// Document is the page the links were found on, fragments of links to
+	// DocumentURL are looked up in it instead of fetching the page again.
+	Document    *goquery.Document
+	DocumentURL *url.URL
+	// SkipSPAFragments turns off the anchor check of client-side routes, see IsSPAFragment.
+	SkipSPAFragments bool
+}
+
+func (c *Checker) longRedirectChain() int {
+	if c.LongRedirectChain > 0 {
+		return c.LongRedirectChain
+	}
+
+	return DefaultLongRedirectChain
+}
+
+// Check inspects rawHref, resolves it against baseURL (if relative),
+// where baseURL is the document base URL of the page the link was found on,
+// then attempts a HEAD fetch while falling back to GET on 405 (Method Not Allowed).
+//
+// Returns following HTTP codes:
+// -1 for unsupported schemes,
+// 0 if any error occurred during fetching,
+// or the actual HTTP status code otherwise.
+//
+// Failed checks have Failure set and Err wrapped into a *LinkError.
+// Every redirect on the way is recorded in Redirects.
+//
+// Links with a fragment are fetched with GET, and the anchor is looked up in the
+// returned document. Links to an anchor of Document are not fetched at all.
+func (c *Checker) Check(ctx context.Context, rawHref string, baseURL *url.URL) HyperLink {
+	// 1) parse & resolve
+	resolved, err := resolveURL(rawHref, baseURL)
+	if err != nil {
+		return HyperLink{
+			Raw:      rawHref,
+			HrefType: string(Classify(rawHref)),
+			Failure:  FailureInvalidURL,
+			Err:      &LinkError{Kind: FailureInvalidURL, Err: err},
+		}
+	}
+
+	hrefType := ClassifyURL(resolved, baseURL)
+
+	// 2) check scheme
+	if _, ok := allowedSchemes[resolved.Scheme]; !ok {
+		return HyperLink{
+			Raw:        rawHref,
+			Resolved:   resolved,
+			HrefType:   string(hrefType),
+			Failure:    FailureUnsupportedScheme,
+			Err:        &LinkError{Kind: FailureUnsupportedScheme, Err: ErrUnsupportedScheme},
+			StatusCode: -1,
+		}
+	}
+
+	link := HyperLink{
+		Raw:      rawHref,
+		Resolved: resolved,
+		HrefType: string(hrefType),
+	}
+
+	fragment := resolved.Fragment
+	checkAnchor := c.checksAnchor(fragment)
+
+	// 3) same-document anchors only need a look at the page we already have
+	if checkAnchor && c.isDocument(resolved) {
+		link.StatusCode = http.StatusOK
+
+		if !HasAnchor(c.Document, fragment) {
+			linkErr := &LinkError{Kind: FailureMissingAnchor, Err: missingAnchorError(fragment)}
+			link.Failure = linkErr.Kind
+			link.Err = linkErr
+		}
+
+		return link
+	}
+
+	// 4) and fetch it
+	traced, stop := trace(ctx)
+	res, err := c.fetchStatus(traced, resolved.String(), checkAnchor)
+
+	var reached phase
+
+	link.Timing, reached = stop()
+
+	if err == nil && res.doc != nil && !HasAnchor(res.doc, fragment) {
+		err = missingAnchorError(fragment)
+	}
+
+	link.StatusCode = res.status
+	link.RetryAfter = res.retryAfter
+	link.Redirects = res.hops
+
+	if len(res.hops) > 0 {
+		link.FinalURL = res.finalURL
+	}
+
+	if err != nil {
+		log.Printf("Analyze: failed fetching %q: %v", resolved.String(), err)
+
+		linkErr := newLinkError(err)
+
+		// The error can't tell, http.Client's Timeout claims to be awaiting headers in any phase
+		if linkErr.Kind.IsTimeout() {
+			linkErr = &LinkError{Kind: reached.timeoutKind(), Err: linkErr.Err}
+		}
+
+		link.Failure = linkErr.Kind
+		link.Err = linkErr
+	}
+
+	link.RedirectIssues = c.redirectIssues(link)
+
+	return link
+}
+
+// Requests reports whether Check sends a request for rawHref, it does not for links that can't
+// be resolved, unsupported schemes like mailto: and anchors of the document itself.
+func (c *Checker) Requests(rawHref string, baseURL *url.URL) bool {
+	resolved, err := resolveURL(rawHref, baseURL)
+	if err != nil {
+		return false
+	}
+
+	if _, ok := allowedSchemes[resolved.Scheme]; !ok {
+		return false
+	}
+
+	return !(c.checksAnchor(resolved.Fragment) && c.isDocument(resolved))
+}
+
+// checksAnchor reports whether the target of a link has to have an element for fragment.
+func (c *Checker) checksAnchor(fragment string) bool {
+	return needsAnchor(fragment) && !(c.SkipSPAFragments && IsSPAFragment(fragment))
+}
+
+// redirectIssues looks for problems in the redirect chain of a checked link.
+func (c *Checker) redirectIssues(link HyperLink) []RedirectIssue {
+	if len(link.Redirects) == 0 {
+		return nil
+	}
+
+	var issues []RedirectIssue
+
+	if link.Failure == FailureRedirectLoop {
+		issues = append(issues, RedirectLoop)
+	}
+
+	permanent := true
+
+	for _, hop := range link.Redirects {
+		if hop.StatusCode != http.StatusMovedPermanently && hop.StatusCode != http.StatusPermanentRedirect {
+			permanent = false
+		}
+
+		from, err := url.Parse(hop.URL)
+		if err != nil {
+			continue
+		}
+
+		to, err := from.Parse(hop.Location)
+		if err != nil {
+			continue
+		}
+
+		if from.Scheme == "https" && to.Scheme == "http" && (len(issues) == 0 || issues[len(issues)-1] != RedirectDowngrade) {
+			issues = append(issues, RedirectDowngrade)
+		}
+	}
+
+	if len(link.Redirects) > c.longRedirectChain() {
+		issues = append(issues, RedirectLongChain)
+	}
+
+	if permanent && link.Failure == "" && link.StatusCode >= 200 && link.StatusCode < 300 {
+		issues = append(issues, RedirectUpdatable)
+	}
+
+	return issues
+}
+
+// isDocument reports whether u points to Document, ignoring the fragment.
+func (c *Checker) isDocument(u *url.URL) bool {
+	if c.Document == nil || c.DocumentURL == nil {
+		return false
+	}
+
+	withoutFragment := func(u *url.URL) string {
+		out := *u
+		out.Fragment = ""
+		out.RawFragment = ""
+
+		return NormalizeURL(&out)
+	}
+
+	return withoutFragment(u) == withoutFragment(c.DocumentURL)
+}
+
+func missingAnchorError(fragment string) error {
+	return fmt.Errorf("no element with id or name %q: %w", fragment, ErrMissingAnchor)
+}
+
+// resolveURL parses rawHref and, if relative, resolves it against baseURL.
+func resolveURL(rawHref string, baseURL *url.URL) (*url.URL, error) {
+	_url, err := url.Parse(rawHref)
+	if err != nil {
+		return nil, fmt.Errorf("parse error: %w", err)
+	}
+
+	if _url.IsAbs() {
+		return _url, nil
+	}
+
+	if baseURL == nil {
+		return nil, fmt.Errorf("relative URL %q with nil base", rawHref)
+	}
+
+	return baseURL.ResolveReference(_url), nil
+}
+
+// maxAnchorDocumentSize caps how much of a document is read to look for an anchor.
+const maxAnchorDocumentSize = 5 << 20
+
+// fetchResult is what a link check request found out.
+type fetchResult struct {
+	status   int
+	hops     []RedirectHop
+	finalURL string
+	// doc is the parsed response, only set when the body was asked for and is HTML.
+	doc *goquery.Document
+	// retryAfter is the Retry-After of a throttling response.
+	retryAfter time.Duration
+}
+
+// fetchStatus does HEAD first; if it returns 405 Method Not Allowed,
+// it retries with GET. With withBody it does GET right away and parses the HTML response.
+//
+// A redirect response means the client gave up following redirects,
+// it is returned along with ErrTooManyRedirects.
+func (c *Checker) fetchStatus(ctx context.Context, url string, withBody bool) (fetchResult, error) {
+	method := http.MethodHead
+	if withBody {
+		method = http.MethodGet
+	}
+
+	res, err := c.fetch(ctx, method, url, withBody)
+	if err != nil {
+		return res, fmt.Errorf("failed to %s the url '%s': %w", method, url, err)
+	}
+
+	// retry with GET instead
+	if method == http.MethodHead && (res.status == http.StatusMethodNotAllowed || res.status == http.StatusBadRequest) {
+		res, err = c.fetch(ctx, http.MethodGet, url, false)
+		if err != nil {
+			return res, fmt.Errorf("failed to GET the url '%s': %w", url, err)
+		}
+	}
+
+	if n := len(res.hops); n > 0 && res.hops[n-1].URL == res.finalURL {
+		return res, fmt.Errorf("'%s' still redirects to '%s': %w", url, res.hops[n-1].Location, ErrTooManyRedirects)
+	}
+
+	return res, nil
+}
+
+// fetch sends a single request and records every redirect on the way.
+//
+// Redirects are followed as the client would, with its CheckRedirect policy.
+// With withBody a successful HTML response is parsed into fetchResult.doc.
+func (c *Checker) fetch(ctx context.Context, method, url string, withBody bool) (fetchResult, error) {
+	var res fetchResult
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return res, err
+	}
+
+	req.Header.Set("User-Agent", UserAgent)
+
+	// A copy of the client, so that concurrent checks don't share the redirect log
+	client := *c.Client
+	checkRedirect := client.CheckRedirect
+
+	client.CheckRedirect = func(next *http.Request, via []*http.Request) error {
+		prev := next.Response
+		res.hops = append(res.hops, RedirectHop{
+			URL:        prev.Request.URL.String(),
+			StatusCode: prev.StatusCode,
+			Location:   prev.Header.Get("Location"),
+		})
+
+		for _, visited := range via {
+			if visited.URL.String() == next.URL.String() {
+				return ErrRedirectLoop
+			}
+		}
+
+		if checkRedirect != nil {
+			return checkRedirect(next, via)
+		}
+
+		if len(via) >= defaultMaxRedirects {
+			return fmt.Errorf("stopped after %d redirects: %w", defaultMaxRedirects, ErrTooManyRedirects)
+		}
+
+		return nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+
+	res.status = resp.StatusCode
+	res.finalURL = resp.Request.URL.String()
+
+	if IsThrottled(resp.StatusCode) {
+		res.retryAfter, _ = ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+
+	if !withBody || resp.StatusCode < 200 || resp.StatusCode >= 300 || !isHTML(resp.Header.Get("Content-Type")) {
+		return res, nil
+	}
+
+	ct := resp.Header.Get("Content-Type")
+
+	// The link itself works, a document we can't read just doesn't get its anchor checked
+	reader, err := charset.NewReader(io.LimitReader(resp.Body, maxAnchorDocumentSize), ct)
+	if err != nil {
+		log.Printf("Analyze: not checking the anchor of %q: %v", url, err)
+
+		return res, nil
+	}
+
+	res.doc, err = goquery.NewDocumentFromReader(reader)
+	if err != nil {
+		log.Printf("Analyze: not checking the anchor of %q: %v", url, err)
+	}
+
+	return res, nil
+}
+
+// isHTML reports whether contentType is an HTML document, anchors of other documents are not checked.
+func isHTML(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Link types relative to the page a link was found on, see ClassifyURL.
+const (
+	// SameOrigin links share the scheme, host and port of the page.
+	SameOrigin HrefType = "same-origin"
+	// Subdomain links point to a subdomain of the page host, e.g. blog.example.com from example.com.
+	Subdomain HrefType = "subdomain"
+	// SameSite links share the registrable domain (eTLD+1) of the page, but not its origin.
+	SameSite HrefType = "same-site"
+)
+
+// ClassifyURL classifies a resolved link relative to the base URL of the page it was found on.
+//
+// Links with a scheme other than http or https are Protocol links, everything
+// that is not same-origin, a subdomain or same-site is External.
+func ClassifyURL(link, base *url.URL) HrefType {
+	scheme := strings.ToLower(link.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return Protocol
+	}
+
+	if base == nil {
+		return External
+	}
+
+	linkHost := strings.ToLower(link.Hostname())
+	baseHost := strings.ToLower(base.Hostname())
+
+	if linkHost == baseHost {
+		if scheme == strings.ToLower(base.Scheme) && port(link) == port(base) {
+			return SameOrigin
+		}
+
+		return SameSite
+	}
+
+	// IP addresses have no subdomains nor a registrable domain
+	if net.ParseIP(linkHost) != nil || net.ParseIP(baseHost) != nil {
+		return External
+	}
+
+	if strings.HasSuffix(linkHost, "."+baseHost) {
+		return Subdomain
+	}
+
+	linkSite, err := publicsuffix.EffectiveTLDPlusOne(linkHost)
+	if err != nil {
+		return External
+	}
+
+	baseSite, err := publicsuffix.EffectiveTLDPlusOne(baseHost)
+	if err != nil || linkSite != baseSite {
+		return External
+	}
+
+	return SameSite
+}
+
+// IsInternal reports whether links of type t stay within the site of the page.
+func IsInternal(t HrefType) bool {
+	return t == Internal || t == SameOrigin || t == Subdomain || t == SameSite
+}
+
+// port returns the port of u, falling back to the default port of its scheme.
+func port(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+
+	if strings.EqualFold(u.Scheme, "https") {
+		return "443"
+	}
+
+	return "80"
+}
@@ -0,0 +1,290 @@
+package parser
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LinkRef is a URL found in a document, along with where it was found.
+type LinkRef struct {
+	URL string `json:"url"`
+	// Element is the lowercase tag name, e.g. "img".
+	Element string `json:"element"`
+	// Attr is the attribute that holds the URL, e.g. "srcset",
+	// or "style" for url() references in inline styles and <style> blocks.
+	Attr string `json:"attr"`
+	// Text is the anchor text, or the alt text of images.
+	Text string `json:"text,omitempty"`
+	// Path is the CSS selector path of the element, e.g. "html > body > nav > a:nth-of-type(2)".
+	Path string `json:"path"`
+	// Position is the 1-based position of the link among all links of the document.
+	Position int `json:"position"`
+}
+
+// IsNavigation reports whether the link is something a visitor can follow,
+// rather than a resource loaded by the page.
+func (r LinkRef) IsNavigation() bool {
+	return r.Element == "a" || r.Element == "area"
+}
+
+// urlAttr is an attribute that holds a URL.
+type urlAttr struct {
+	name   string
+	srcset bool
+}
+
+// urlAttrs lists the URL-bearing attributes of every element that is checked.
+var urlAttrs = map[string][]urlAttr{
+	"a":      {{name: "href"}},
+	"area":   {{name: "href"}},
+	"link":   {{name: "href"}},
+	"img":    {{name: "src"}, {name: "srcset", srcset: true}},
+	"source": {{name: "src"}, {name: "srcset", srcset: true}},
+	"script": {{name: "src"}},
+	"iframe": {{name: "src"}},
+	"frame":  {{name: "src"}},
+	"embed":  {{name: "src"}},
+	"object": {{name: "data"}},
+	"video":  {{name: "src"}, {name: "poster"}},
+	"audio":  {{name: "src"}},
+	"track":  {{name: "src"}},
+	"input":  {{name: "src"}, {name: "formaction"}},
+	"form":   {{name: "action"}},
+	"button": {{name: "formaction"}},
+}
+
+// hintRels are <link rel> values that point to an origin rather than a resource.
+var hintRels = map[string]struct{}{
+	"preconnect":   {},
+	"dns-prefetch": {},
+}
+
+// cssURL matches url() references in CSS, quoted or not.
+var cssURL = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+
+// ExtractLinks returns every URL referenced by the document: anchors, images, scripts,
+// stylesheets, frames, media, form targets and url() references in CSS.
+//
+// Anchors keep their href as is, even when it is empty, since the server might render something there.
+// Resource URLs that are empty, inline data: URIs or fragment-only references are skipped.
+func ExtractLinks(doc *goquery.Document) []LinkRef {
+	var refs []LinkRef
+
+	doc.Find("*").Each(func(_ int, sel *goquery.Selection) {
+		element := goquery.NodeName(sel)
+		found := len(refs)
+
+		for _, attr := range urlAttrs[element] {
+			value, ok := sel.Attr(attr.name)
+			if !ok {
+				continue
+			}
+
+			if element == "link" && isHintLink(sel) {
+				continue
+			}
+
+			if element == "input" && attr.name == "src" && !strings.EqualFold(sel.AttrOr("type", ""), "image") {
+				continue
+			}
+
+			if attr.srcset {
+				for _, candidate := range ParseSrcset(value) {
+					refs = appendResource(refs, LinkRef{URL: candidate, Element: element, Attr: attr.name})
+				}
+
+				continue
+			}
+
+			ref := LinkRef{URL: value, Element: element, Attr: attr.name}
+			if ref.IsNavigation() {
+				refs = append(refs, ref)
+
+				continue
+			}
+
+			refs = appendResource(refs, ref)
+		}
+
+		if style, ok := sel.Attr("style"); ok {
+			for _, u := range CSSURLs(style) {
+				refs = appendResource(refs, LinkRef{URL: u, Element: element, Attr: "style"})
+			}
+		}
+
+		if element == "style" {
+			for _, u := range CSSURLs(sel.Text()) {
+				refs = appendResource(refs, LinkRef{URL: u, Element: element, Attr: "style"})
+			}
+		}
+
+		if len(refs) == found {
+			return
+		}
+
+		path := domPath(sel)
+		text := linkText(sel, element)
+
+		for i := found; i < len(refs); i++ {
+			refs[i].Text = text
+			refs[i].Path = path
+			refs[i].Position = i + 1
+		}
+	})
+
+	return refs
+}
+
+// linkText returns the text a visitor sees for a link element.
+func linkText(sel *goquery.Selection, element string) string {
+	var text string
+
+	switch element {
+	case "a":
+		text = sel.Text()
+		if strings.TrimSpace(text) == "" {
+			text = sel.AttrOr("aria-label", sel.AttrOr("title", sel.Find("img[alt]").AttrOr("alt", "")))
+		}
+	case "area", "img", "input":
+		text = sel.AttrOr("alt", "")
+	case "button":
+		text = sel.Text()
+	}
+
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// domPath returns a CSS selector that points to the element, using :nth-of-type where needed.
+func domPath(sel *goquery.Selection) string {
+	var parts []string
+
+	for n := sel.Get(0); n != nil && n.Type == html.ElementNode; n = n.Parent {
+		part := n.Data
+
+		index, count := 0, 0
+		if n.Parent != nil {
+			for sibling := n.Parent.FirstChild; sibling != nil; sibling = sibling.NextSibling {
+				if sibling.Type != html.ElementNode || sibling.Data != n.Data {
+					continue
+				}
+
+				count++
+				if sibling == n {
+					index = count
+				}
+			}
+		}
+
+		if count > 1 {
+			part += ":nth-of-type(" + strconv.Itoa(index) + ")"
+		}
+
+		parts = append(parts, part)
+	}
+
+	slices.Reverse(parts)
+
+	return strings.Join(parts, " > ")
+}
+
+// appendResource appends ref unless it does not point to anything worth checking.
+func appendResource(refs []LinkRef, ref LinkRef) []LinkRef {
+	ref.URL = strings.TrimSpace(ref.URL)
+
+	if ref.URL == "" || strings.HasPrefix(ref.URL, "#") || hasScheme(ref.URL, "data") {
+		return refs
+	}
+
+	return append(refs, ref)
+}
+
+// isHintLink reports whether a <link> is only a resource hint, like preconnect.
+func isHintLink(sel *goquery.Selection) bool {
+	rels := strings.Fields(strings.ToLower(sel.AttrOr("rel", "")))
+	if len(rels) == 0 {
+		return false
+	}
+
+	for _, rel := range rels {
+		if _, ok := hintRels[rel]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasScheme(rawURL, scheme string) bool {
+	return len(rawURL) > len(scheme) && rawURL[len(scheme)] == ':' && strings.EqualFold(rawURL[:len(scheme)], scheme)
+}
+
+// ParseSrcset returns the image URLs of a srcset attribute, without their descriptors.
+//
+// Follows the candidate parsing rules of the HTML spec, so URLs may contain commas:
+// https://html.spec.whatwg.org/multipage/images.html#parsing-a-srcset-attribute
+func ParseSrcset(srcset string) []string {
+	var urls []string
+
+	s := srcset
+
+	for {
+		s = strings.TrimLeft(s, " \t\n\r\f,")
+		if s == "" {
+			return urls
+		}
+
+		end := strings.IndexAny(s, " \t\n\r\f")
+		if end == -1 {
+			end = len(s)
+		}
+
+		candidate := s[:end]
+		s = s[end:]
+
+		// A URL ending with commas has no descriptors
+		if trimmed := strings.TrimRight(candidate, ","); trimmed != candidate {
+			urls = append(urls, trimmed)
+
+			continue
+		}
+
+		urls = append(urls, candidate)
+
+		// Skip the descriptors, commas inside parentheses don't end them
+		depth := 0
+		i := 0
+
+	descriptors:
+		for ; i < len(s); i++ {
+			switch s[i] {
+			case '(':
+				depth++
+			case ')':
+				if depth > 0 {
+					depth--
+				}
+			case ',':
+				if depth == 0 {
+					break descriptors
+				}
+			}
+		}
+
+		s = s[i:]
+	}
+}
+
+// CSSURLs returns the url() references of a style sheet or a style attribute.
+func CSSURLs(css string) []string {
+	var urls []string
+
+	for _, m := range cssURL.FindAllStringSubmatch(css, -1) {
+		urls = append(urls, m[1]+m[2]+m[3])
+	}
+
+	return urls
+}
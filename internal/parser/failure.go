@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/hugmouse/scan24/internal/netguard"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// FailureKind tells why a link could not be checked.
+//
+// FailureKind implements error, so errors.Is(link.Err, parser.FailureTimeoutDial) works on LinkError.
+type FailureKind string
+
+const (
+	FailureDNSNotFound          FailureKind = "dns_not_found"
+	FailureDNS                  FailureKind = "dns"
+	FailureConnectionRefused    FailureKind = "connection_refused"
+	FailureConnectionReset      FailureKind = "connection_reset"
+	FailureTimeoutDial          FailureKind = "timeout_dial"
+	FailureTimeoutTLS           FailureKind = "timeout_tls"
+	FailureTimeoutHeaders       FailureKind = "timeout_headers"
+	FailureTimeout              FailureKind = "timeout"
+	FailureCertExpired          FailureKind = "tls_cert_expired"
+	FailureCertHostname         FailureKind = "tls_cert_hostname"
+	FailureCertUnknownAuthority FailureKind = "tls_cert_unknown_authority"
+	FailureTLS                  FailureKind = "tls"
+	FailureTooManyRedirects     FailureKind = "too_many_redirects"
+	FailureRedirectLoop         FailureKind = "redirect_loop"
+	FailureMissingAnchor        FailureKind = "missing_anchor"
+	FailureRobotsDisallowed     FailureKind = "robots_disallowed"
+	FailureUnsupportedScheme    FailureKind = "unsupported_scheme"
+	FailureBlocked              FailureKind = "blocked"
+	FailureCancelled            FailureKind = "cancelled"
+	FailureInvalidURL           FailureKind = "invalid_url"
+	FailureUnknown              FailureKind = "unknown"
+)
+
+var failureLabels = map[FailureKind]string{
+	FailureDNSNotFound:          "DNS: no such host",
+	FailureDNS:                  "DNS lookup failed",
+	FailureConnectionRefused:    "Connection refused",
+	FailureConnectionReset:      "Connection reset",
+	FailureTimeoutDial:          "Timeout: connecting",
+	FailureTimeoutTLS:           "Timeout: TLS handshake",
+	FailureTimeoutHeaders:       "Timeout: awaiting headers",
+	FailureTimeout:              "Timeout",
+	FailureCertExpired:          "TLS: certificate expired",
+	FailureCertHostname:         "TLS: hostname mismatch",
+	FailureCertUnknownAuthority: "TLS: unknown authority",
+	FailureTLS:                  "TLS error",
+	FailureTooManyRedirects:     "Too many redirects",
+	FailureRedirectLoop:         "Redirect loop",
+	FailureMissingAnchor:        "Anchor not found",
+	FailureRobotsDisallowed:     "Disallowed by robots.txt",
+	FailureUnsupportedScheme:    "Unsupported scheme",
+	FailureBlocked:              "Blocked",
+	FailureCancelled:            "Cancelled",
+	FailureInvalidURL:           "Invalid URL",
+	FailureUnknown:              "Failed",
+}
+
+func (k FailureKind) Error() string {
+	return string(k)
+}
+
+// Label is a short human-readable description of the failure.
+func (k FailureKind) Label() string {
+	if label, ok := failureLabels[k]; ok {
+		return label
+	}
+
+	return string(k)
+}
+
+// IsTimeout reports whether the check failed because one of our timeouts ran out,
+// rather than because the site is down.
+func (k FailureKind) IsTimeout() bool {
+	return k == FailureTimeoutDial || k == FailureTimeoutTLS || k == FailureTimeoutHeaders || k == FailureTimeout
+}
+
+// ErrRobotsDisallowed is the error of links that were not checked because robots.txt disallows them.
+var ErrRobotsDisallowed = errors.New("disallowed by robots.txt")
+
+// ErrTooManyRedirects is returned when a link still redirects after the redirect limit was reached.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// LinkError is the error of a failed link check.
+type LinkError struct {
+	Kind FailureKind
+	Err  error
+}
+
+func (e *LinkError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is match the FailureKind of the error.
+func (e *LinkError) Is(target error) bool {
+	kind, ok := target.(FailureKind)
+
+	return ok && kind == e.Kind
+}
+
+// newLinkError wraps err into a LinkError with the matching FailureKind.
+func newLinkError(err error) *LinkError {
+	var linkErr *LinkError
+	if errors.As(err, &linkErr) {
+		return linkErr
+	}
+
+	return &LinkError{Kind: classifyError(err), Err: err}
+}
+
+// classifyError maps an error returned by http.Client to a FailureKind, timeouts are FailureTimeout.
+func classifyError(err error) FailureKind {
+	var (
+		dnsErr       *net.DNSError
+		hostnameErr  x509.HostnameError
+		authorityErr x509.UnknownAuthorityError
+		certErr      x509.CertificateInvalidError
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		netErr       net.Error
+		isNetTimeout = errors.As(err, &netErr) && netErr.Timeout()
+		message      = err.Error()
+	)
+
+	switch {
+	case errors.Is(err, ErrRedirectLoop):
+		return FailureRedirectLoop
+	case errors.Is(err, ErrRobotsDisallowed):
+		return FailureRobotsDisallowed
+	case errors.Is(err, ErrMissingAnchor):
+		return FailureMissingAnchor
+	case errors.Is(err, ErrTooManyRedirects) || strings.Contains(message, "stopped after") && strings.Contains(message, "redirects"):
+		return FailureTooManyRedirects
+	case errors.Is(err, ErrUnsupportedScheme):
+		return FailureUnsupportedScheme
+	case errors.Is(err, netguard.ErrBlocked):
+		return FailureBlocked
+	case errors.Is(err, context.Canceled):
+		return FailureCancelled
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsNotFound {
+			return FailureDNSNotFound
+		}
+
+		if dnsErr.IsTimeout {
+			return FailureTimeoutDial
+		}
+
+		return FailureDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET):
+		return FailureConnectionReset
+	case errors.As(err, &certErr) && certErr.Reason == x509.Expired:
+		return FailureCertExpired
+	case errors.As(err, &hostnameErr):
+		return FailureCertHostname
+	case errors.As(err, &authorityErr):
+		return FailureCertUnknownAuthority
+	case errors.As(err, &verifyErr), errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return FailureTLS
+	case isNetTimeout, errors.Is(err, context.DeadlineExceeded):
+		// Check tells which timeout it was by how far the request got
+		return FailureTimeout
+	}
+
+	return FailureUnknown
+}
@@ -1,11 +1,13 @@
 package parser
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
-	"fmt"
-	"log"
+	"github.com/hugmouse/scan24/internal/robots"
 	"net/http"
 	"net/url"
+	"time"
 )
 
 type HrefType string
@@ -60,120 +62,179 @@ func isExternal(href string) bool {
 }
 
 // HyperLink holds the analysis result.
+//
+// HrefType is one of the ClassifyURL types, or the result of Classify if the link could not be resolved.
 type HyperLink struct {
 	Raw        string
 	Resolved   *url.URL
 	HrefType   string
 	StatusCode int
-	Err        error
+	// Failure tells why the check failed, Err is a *LinkError of the same kind.
+	Failure FailureKind
+	Err     error
+
+	// Redirects are the redirect responses that led from Resolved to FinalURL, in order.
+	Redirects      []RedirectHop
+	FinalURL       string
+	RedirectIssues []RedirectIssue
+
+	// Timing is zero for links that were never requested.
+	Timing Timing
+
+	// Element and Attr tell where the link was found, e.g. "img" and "srcset", see ExtractLinks.
+	Element string
+	Attr    string
+	// Occurrences are all the places of the page that link to the same URL,
+	// the link itself is only checked once.
+	Occurrences []LinkRef
+
+	// Cached is set when the result was taken from an earlier check of the same URL.
+	Cached bool
+	// Robots tells whether robots.txt allows the link, empty if it was not consulted.
+	Robots robots.Status
+
+	// RetryAfter is what the Retry-After header of a 429 or 503 response asked for.
+	RetryAfter time.Duration
+	// Retries is the number of times the link was checked again after such a response.
+	Retries int
 }
 
-var (
-	allowedSchemes = map[string]struct{}{
-		"http":  {},
-		"https": {},
-	}
-)
+// IsNavigation reports whether the link came from an anchor rather than a page resource.
+//
+// Links without an Element are treated as anchors.
+func (l HyperLink) IsNavigation() bool {
+	return l.Element == "" || LinkRef{Element: l.Element}.IsNavigation()
+}
 
-// Analyze inspects rawHref, resolves it against baseURL (if relative),
-// then attempts a HEAD fetch while falling back to GET on 405 (Method Not Allowed).
+// Broken reports whether the link was checked and either failed to load,
+// responded with a 4xx/5xx status code, kept redirecting or points to a missing anchor.
 //
-// Returns following HTTP codes:
-// -1 for unsupported schemes,
-// 0 if any error occurred during fetching,
-// or the actual HTTP status code otherwise.
-func Analyze(rawHref string, baseURL *url.URL, httpClient *http.Client) HyperLink {
-	hrefType := Classify(rawHref)
-
-	// 1) parse & resolve
-	resolved, err := resolveURL(rawHref, baseURL)
-	if err != nil {
-		return HyperLink{Raw: rawHref, HrefType: string(hrefType), Err: err}
+// Links with unsupported schemes or disallowed by robots.txt are not considered broken,
+// since they were never checked.
+func (l HyperLink) Broken() bool {
+	if l.Failure == FailureUnsupportedScheme || l.Failure == FailureRobotsDisallowed {
+		return false
 	}
 
-	// 2) check scheme
-	if _, ok := allowedSchemes[resolved.Scheme]; !ok {
-		return HyperLink{
-			Raw:        rawHref,
-			Resolved:   resolved,
-			HrefType:   string(hrefType),
-			Err:        ErrUnsupportedScheme,
-			StatusCode: -1,
-		}
-	}
+	return l.StatusCode == 0 || l.StatusCode >= http.StatusBadRequest ||
+		l.Failure == FailureTooManyRedirects || l.Failure == FailureMissingAnchor
+}
 
-	// 3) and fetch it
-	status, fetchErr := fetchStatus(resolved.String(), httpClient)
-	if fetchErr != nil {
-		log.Printf("Analyze: failed fetching %q: %v", resolved.String(), fetchErr)
-
-		return HyperLink{
-			Raw:        rawHref,
-			Resolved:   resolved,
-			HrefType:   string(hrefType),
-			Err:        fetchErr,
-			StatusCode: 0,
-		}
-	}
+// hyperLinkJSON is the stable JSON representation of HyperLink.
+type hyperLinkJSON struct {
+	Raw        string      `json:"raw"`
+	Resolved   string      `json:"resolved,omitempty"`
+	HrefType   string      `json:"href_type"`
+	StatusCode int         `json:"status_code"`
+	Failure    FailureKind `json:"failure,omitempty"`
+	Error      string      `json:"error,omitempty"`
 
-	return HyperLink{
-		Raw:        rawHref,
-		Resolved:   resolved,
-		HrefType:   string(hrefType),
-		StatusCode: status,
-	}
+	Redirects      []RedirectHop   `json:"redirects,omitempty"`
+	FinalURL       string          `json:"final_url,omitempty"`
+	RedirectIssues []RedirectIssue `json:"redirect_issues,omitempty"`
+
+	Timing Timing `json:"timing,omitzero"`
+
+	Element     string    `json:"element,omitempty"`
+	Attr        string    `json:"attr,omitempty"`
+	Occurrences []LinkRef `json:"occurrences,omitempty"`
+	Cached      bool      `json:"cached,omitempty"`
+
+	Robots robots.Status `json:"robots,omitempty"`
+
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+	Retries    int           `json:"retries,omitempty"`
 }
 
-// resolveURL parses rawHref and, if relative, resolves it against baseURL.
-func resolveURL(rawHref string, baseURL *url.URL) (*url.URL, error) {
-	_url, err := url.Parse(rawHref)
-	if err != nil {
-		return nil, fmt.Errorf("parse error: %w", err)
+// MarshalJSON encodes the resolved URL and the error as plain strings.
+func (l HyperLink) MarshalJSON() ([]byte, error) {
+	out := hyperLinkJSON{
+		Raw:        l.Raw,
+		HrefType:   l.HrefType,
+		StatusCode: l.StatusCode,
+		Failure:    l.Failure,
+
+		Redirects:      l.Redirects,
+		FinalURL:       l.FinalURL,
+		RedirectIssues: l.RedirectIssues,
+
+		Timing: l.Timing,
+
+		Element:     l.Element,
+		Attr:        l.Attr,
+		Occurrences: l.Occurrences,
+		Cached:      l.Cached,
+
+		Robots: l.Robots,
+
+		RetryAfter: l.RetryAfter,
+		Retries:    l.Retries,
 	}
 
-	if _url.IsAbs() {
-		return _url, nil
+	if l.Resolved != nil {
+		out.Resolved = l.Resolved.String()
 	}
 
-	if baseURL == nil {
-		return nil, fmt.Errorf("relative URL %q with nil base", rawHref)
+	if l.Err != nil {
+		out.Error = l.Err.Error()
 	}
 
-	return baseURL.ResolveReference(_url), nil
+	return json.Marshal(out)
 }
 
-// fetchStatus does HEAD first; if it returns 405 Method Not Allowed,
-// it retries with GET.
-func fetchStatus(url string, httpClient *http.Client) (int, error) {
-	req, err := http.NewRequest(http.MethodHead, url, nil)
+// UnmarshalJSON is the inverse of MarshalJSON, the error is restored as an opaque error.
+func (l *HyperLink) UnmarshalJSON(data []byte) error {
+	var in hyperLinkJSON
+
+	err := json.Unmarshal(data, &in)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	req.Header.Set("User-Agent", "Scan24 (+https://github.com/hugmouse/scan24)")
-	// HEAD
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return 0, fmt.Errorf("failed to HEAD the url '%s': %w", url, err)
+	*l = HyperLink{
+		Raw:        in.Raw,
+		HrefType:   in.HrefType,
+		StatusCode: in.StatusCode,
+		Failure:    in.Failure,
+
+		Redirects:      in.Redirects,
+		FinalURL:       in.FinalURL,
+		RedirectIssues: in.RedirectIssues,
+
+		Timing: in.Timing,
+
+		Element:     in.Element,
+		Attr:        in.Attr,
+		Occurrences: in.Occurrences,
+		Cached:      in.Cached,
+
+		Robots: in.Robots,
+
+		RetryAfter: in.RetryAfter,
+		Retries:    in.Retries,
 	}
-	defer resp.Body.Close()
 
-	// retry with GET instead
-	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusBadRequest {
-		req, err = http.NewRequest(http.MethodGet, url, nil)
+	if in.Resolved != "" {
+		l.Resolved, err = url.Parse(in.Resolved)
 		if err != nil {
-			return 0, err
-		}
-
-		resp2, err2 := httpClient.Do(req)
-		if err2 != nil {
-			return 0, fmt.Errorf("failed to GET the url '%s': %w", url, err)
+			return err
 		}
+	}
 
-		defer resp2.Body.Close()
+	if in.Error != "" {
+		l.Err = errors.New(in.Error)
 
-		return resp2.StatusCode, nil
+		if in.Failure != "" {
+			l.Err = &LinkError{Kind: in.Failure, Err: l.Err}
+		}
 	}
 
-	return resp.StatusCode, nil
+	return nil
+}
+
+// Analyze inspects rawHref, resolves it against baseURL (if relative),
+// where baseURL is the document base URL of the page the link was found on,
+// and checks it with httpClient, see Checker.Check.
+func Analyze(ctx context.Context, rawHref string, baseURL *url.URL, httpClient *http.Client) HyperLink {
+	return (&Checker{Client: httpClient}).Check(ctx, rawHref, baseURL)
 }
@@ -0,0 +1,36 @@
+package parser
+
+import (
+	"net/url"
+	"strings"
+)
+
+// NormalizeURL returns a canonical form of u, so that equivalent URLs compare equal:
+// the scheme and host are lowercased, the default port is dropped and an empty
+// path of an http(s) URL becomes "/". The query and the fragment are kept as is.
+func NormalizeURL(u *url.URL) string {
+	out := *u
+	out.Scheme = strings.ToLower(out.Scheme)
+	out.Host = strings.ToLower(out.Host)
+
+	if (out.Scheme == "http" && out.Port() == "80") || (out.Scheme == "https" && out.Port() == "443") {
+		out.Host = strings.TrimSuffix(out.Host, ":"+out.Port())
+	}
+
+	if (out.Scheme == "http" || out.Scheme == "https") && out.Path == "" && out.Opaque == "" {
+		out.Path = "/"
+	}
+
+	return out.String()
+}
+
+// LinkKey is the key under which links of a page are deduplicated:
+// rawHref resolved against baseURL and normalized, or rawHref itself if it can not be resolved.
+func LinkKey(rawHref string, baseURL *url.URL) string {
+	resolved, err := resolveURL(rawHref, baseURL)
+	if err != nil {
+		return rawHref
+	}
+
+	return NormalizeURL(resolved)
+}
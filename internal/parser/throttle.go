@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IsThrottled reports whether status asks the client to slow down.
+func IsThrottled(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// ParseRetryAfter reads a Retry-After header, either a number of seconds or an HTTP-date.
+//
+// Dates in the past result in zero, ok is false if the header is missing or invalid.
+func ParseRetryAfter(value string, now time.Time) (wait time.Duration, ok bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		// Anything longer than a day is as good as forever for a link check
+		return time.Duration(min(seconds, 24*60*60)) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(date.Sub(now), 0), true
+}
@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timing is the breakdown of the time spent on a request, redirects included.
+//
+// DNS, Connect and TLSHandshake are summed up over every connection that had to be
+// opened, they are zero when a kept-alive connection was reused.
+type Timing struct {
+	DNS          time.Duration `json:"dns"`
+	Connect      time.Duration `json:"connect"`
+	TLSHandshake time.Duration `json:"tls_handshake"`
+	// TTFB is the time from the start until the first byte of the last response.
+	TTFB  time.Duration `json:"ttfb"`
+	Total time.Duration `json:"total"`
+}
+
+// phase is how far the last request got, it tells which of our timeouts ran out.
+type phase int
+
+const (
+	phaseConnect phase = iota // resolving the host and dialing
+	phaseTLS
+	phaseHeaders // waiting for the response
+	phaseBody
+)
+
+// timeoutKind is the FailureKind of a timeout that ran out in phase p.
+func (p phase) timeoutKind() FailureKind {
+	switch p {
+	case phaseConnect:
+		return FailureTimeoutDial
+	case phaseTLS:
+		return FailureTimeoutTLS
+	case phaseHeaders:
+		return FailureTimeoutHeaders
+	}
+
+	return FailureTimeout
+}
+
+// Trace instruments every request made with the returned context,
+// stop returns the timings collected since Trace was called.
+func Trace(ctx context.Context) (traced context.Context, stop func() Timing) {
+	traced, stopPhase := trace(ctx)
+
+	return traced, func() Timing {
+		timing, _ := stopPhase()
+
+		return timing
+	}
+}
+
+// trace is Trace that also returns the phase the last request reached.
+func trace(ctx context.Context) (traced context.Context, stop func() (Timing, phase)) {
+	var (
+		mu           sync.Mutex
+		timing       Timing
+		reached      phase
+		dnsStart     time.Time
+		connectStart = make(map[string]time.Time)
+		tlsStart     time.Time
+	)
+
+	start := time.Now()
+
+	// Dual-stack dialing may connect to several addresses at once, hence the mutex
+	trace := &httptrace.ClientTrace{
+		// Every request, redirects included, starts over with getting a connection
+		GetConn: func(string) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			reached = phaseConnect
+		},
+		GotConn: func(httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			reached = phaseHeaders
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			timing.DNS += time.Since(dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			connectStart[network+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, _ error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			timing.Connect += time.Since(connectStart[network+addr])
+		},
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			tlsStart = time.Now()
+			reached = phaseTLS
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			timing.TLSHandshake += time.Since(tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			timing.TTFB = time.Since(start)
+			reached = phaseBody
+		},
+	}
+
+	stop = func() (Timing, phase) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		timing.Total = time.Since(start)
+
+		return timing, reached
+	}
+
+	return httptrace.WithClientTrace(ctx, trace), stop
+}
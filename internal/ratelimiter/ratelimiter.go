@@ -1,22 +1,226 @@
 package ratelimiter
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/net/publicsuffix"
 	"golang.org/x/time/rate"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
+// Backoff defaults, used when DomainRateLimiter.BaseBackoff or MaxBackoff are zero.
+const (
+	DefaultBaseBackoff = time.Second
+	DefaultMaxBackoff  = time.Minute
+)
+
+// Grouping tells which hosts share a limiter.
+type Grouping string
+
+const (
+	// GroupByHost gives every host its own limiter.
+	GroupByHost Grouping = "host"
+	// GroupBySite shares a limiter between all hosts of a registrable domain (eTLD+1),
+	// so a.cdn.example.com and b.cdn.example.com are limited together.
+	GroupBySite Grouping = "site"
+)
+
+// ErrInvalidGrouping is returned by ParseGrouping for unknown groupings.
+var ErrInvalidGrouping = errors.New("rate limit grouping must be host or site")
+
+// ParseGrouping validates a grouping given by the user.
+func ParseGrouping(s string) (Grouping, error) {
+	switch g := Grouping(s); g {
+	case GroupByHost, GroupBySite:
+		return g, nil
+	}
+
+	return "", ErrInvalidGrouping
+}
+
+// Override replaces the limit and burst of a domain.
+type Override struct {
+	Limit rate.Limit
+	Burst int
+}
+
+// ErrInvalidOverride is returned by ParseOverrides for entries it can't read.
+var ErrInvalidOverride = errors.New("rate limit override must look like domain=limit or domain=limit:burst")
+
+// ParseOverrides reads overrides like "example.com=10" or "example.com=0.5:2",
+// limits are requests per second and the burst defaults to 1.
+func ParseOverrides(entries []string) (map[string]Override, error) {
+	overrides := make(map[string]Override, len(entries))
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		domain, value, ok := strings.Cut(entry, "=")
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("%q: %w", entry, ErrInvalidOverride)
+		}
+
+		limitValue, burstValue, hasBurst := strings.Cut(value, ":")
+
+		limit, err := strconv.ParseFloat(limitValue, 64)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("%q: %w", entry, ErrInvalidOverride)
+		}
+
+		o := Override{Limit: rate.Limit(limit), Burst: 1}
+
+		if hasBurst {
+			o.Burst, err = strconv.Atoi(burstValue)
+			if err != nil || o.Burst < 1 {
+				return nil, fmt.Errorf("%q: %w", entry, ErrInvalidOverride)
+			}
+		}
+
+		overrides[strings.ToLower(strings.TrimSpace(domain))] = o
+	}
+
+	return overrides, nil
+}
+
+// DomainRateLimiter limits the requests to every domain, its exported fields have to be set before it is used.
 type DomainRateLimiter struct {
-	limiters map[string]*rate.Limiter
-	mu       sync.Mutex
-	limit    rate.Limit
-	burst    int
+	hosts map[string]*host
+	mu    sync.Mutex
+	limit rate.Limit
+	burst int
+
+	// lastSweep is when idle limiters were last looked for.
+	lastSweep time.Time
+
+	// BaseBackoff is the first pause after a host throttled us, it doubles with every
+	// throttling response in a row. MaxBackoff caps the pause, including Retry-After.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// GroupBy picks the hosts that share a limiter, GroupByHost if empty.
+	GroupBy Grouping
+	// Overrides replace the limit and burst of a domain and its subdomains,
+	// the most specific domain wins. With GroupBySite, an overridden subdomain
+	// like a.example.com gets a limiter of its own instead of sharing the site's.
+	Overrides map[string]Override
+	// IdleTTL drops limiters that were not used for that long, zero keeps them forever.
+	IdleTTL time.Duration
+}
+
+// host is the rate limiting state of a single domain.
+type host struct {
+	limiter *rate.Limiter
+	// ceiling is the rate the limiter recovers to, lower than the configured limit after a Crawl-delay.
+	ceiling rate.Limit
+	// throttles counts the throttling responses since the last successful one.
+	throttles int
+	// pausedUntil holds back every request until a backoff is over.
+	pausedUntil time.Time
+	lastUsed    time.Time
+	// waiting counts the Wait calls in progress, such limiters are never dropped.
+	waiting int
 }
 
 func NewDomainRateLimiter(limit rate.Limit, burst int) *DomainRateLimiter {
 	return &DomainRateLimiter{
-		limiters: make(map[string]*rate.Limiter),
-		limit:    limit,
-		burst:    burst,
+		hosts: make(map[string]*host),
+		limit: limit,
+		burst: burst,
+	}
+}
+
+// Key returns the name of the limiter that requests to domain go through.
+func (d *DomainRateLimiter) Key(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	// IP addresses and single-label hosts like localhost have no registrable domain
+	if d.GroupBy != GroupBySite || net.ParseIP(domain) != nil {
+		return domain
+	}
+
+	site, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return domain
+	}
+
+	// Grouping by the site would never match the override of a subdomain
+	if overridden := d.overridden(domain); len(overridden) > len(site) {
+		return overridden
+	}
+
+	return site
+}
+
+// overridden returns the most specific domain of Overrides that domain is or is a subdomain of,
+// an empty string if there is none.
+func (d *DomainRateLimiter) overridden(domain string) string {
+	for domain != "" {
+		if _, ok := d.Overrides[domain]; ok {
+			return domain
+		}
+
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			break
+		}
+
+		domain = parent
+	}
+
+	return ""
+}
+
+// limitFor returns the limit and burst of the limiter named key.
+func (d *DomainRateLimiter) limitFor(key string) (rate.Limit, int) {
+	if o, ok := d.Overrides[d.overridden(key)]; ok {
+		return o.Limit, o.Burst
+	}
+
+	return d.limit, d.burst
+}
+
+// host returns the state of the limiter of domain, d.mu must be held.
+func (d *DomainRateLimiter) host(domain string) *host {
+	now := time.Now()
+	d.sweep(now)
+
+	key := d.Key(domain)
+
+	h, exists := d.hosts[key]
+	if !exists {
+		limit, burst := d.limitFor(key)
+		h = &host{limiter: rate.NewLimiter(limit, burst), ceiling: limit}
+		d.hosts[key] = h
+	}
+
+	h.lastUsed = now
+
+	return h
+}
+
+// sweep drops the limiters that have been idle for longer than IdleTTL, d.mu must be held.
+//
+// It runs at most once per IdleTTL, a dropped limiter simply starts over on the next request.
+func (d *DomainRateLimiter) sweep(now time.Time) {
+	if d.IdleTTL <= 0 || now.Sub(d.lastSweep) < d.IdleTTL {
+		return
+	}
+
+	d.lastSweep = now
+
+	for key, h := range d.hosts {
+		if h.waiting == 0 && now.Sub(h.lastUsed) > d.IdleTTL && now.After(h.pausedUntil) {
+			delete(d.hosts, key)
+		}
 	}
 }
 
@@ -24,11 +228,172 @@ func (d *DomainRateLimiter) GetLimiter(domain string) *rate.Limiter {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	limiter, exists := d.limiters[domain]
-	if !exists {
-		limiter = rate.NewLimiter(d.limit, d.burst)
-		d.limiters[domain] = limiter
+	return d.host(domain).limiter
+}
+
+// Wait blocks until a request to domain is allowed, sitting out the backoff of a throttling host first.
+func (d *DomainRateLimiter) Wait(ctx context.Context, domain string) error {
+	d.mu.Lock()
+	h := d.host(domain)
+	h.waiting++
+	pause := time.Until(h.pausedUntil)
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		h.waiting--
+		h.lastUsed = time.Now()
+		d.mu.Unlock()
+	}()
+
+	if pause > 0 {
+		timer := time.NewTimer(pause)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return h.limiter.Wait(ctx)
+}
+
+// SetCrawlDelay slows the limiter of domain down to one request every delay,
+// as asked for by the Crawl-delay of its robots.txt. It never speeds a limiter up.
+func (d *DomainRateLimiter) SetCrawlDelay(domain string, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h := d.host(domain)
+
+	limit := rate.Every(delay)
+	if limit < h.ceiling {
+		h.ceiling = limit
+	}
+
+	if limit < h.limiter.Limit() {
+		h.limiter.SetLimit(limit)
+	}
+}
+
+// Throttled records a 429 or 503 response of domain and returns how long requests to it are paused:
+// retryAfter if the server asked for it, an exponentially growing backoff otherwise, capped at MaxBackoff.
+// The rate of domain is halved as well, Succeeded brings it back up.
+func (d *DomainRateLimiter) Throttled(domain string, retryAfter time.Duration) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h := d.host(domain)
+
+	maxBackoff := d.maxBackoff()
+
+	// Shifting more than that would overflow long before reaching any sensible cap
+	backoff := maxBackoff
+	if h.throttles < 32 {
+		backoff = min(d.baseBackoff()<<h.throttles, maxBackoff)
+	}
+
+	backoff = min(max(backoff, retryAfter), maxBackoff)
+	h.throttles++
+
+	if until := time.Now().Add(backoff); until.After(h.pausedUntil) {
+		h.pausedUntil = until
+	}
+
+	// Halved down to one request per MaxBackoff, limits that were even lower stay as they are
+	limit := h.limiter.Limit()
+	h.limiter.SetLimit(max(limit/2, min(limit, rate.Every(maxBackoff))))
+
+	return backoff
+}
+
+// Succeeded records a response of domain that was not throttled,
+// every one of them raises a slowed down rate by a tenth of the configured limit.
+func (d *DomainRateLimiter) Succeeded(domain string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	h := d.host(domain)
+	h.throttles = 0
+
+	if limit := h.limiter.Limit(); limit < h.ceiling {
+		h.limiter.SetLimit(min(limit+h.ceiling/10, h.ceiling))
+	}
+}
+
+// LimiterInfo describes the current state of a single limiter.
+type LimiterInfo struct {
+	Key string `json:"key"`
+	// Limit is in requests per second, Unlimited is set instead for rate.Inf.
+	Limit     float64 `json:"limit"`
+	Unlimited bool    `json:"unlimited,omitempty"`
+	// Ceiling is the limit that a slowed down limiter recovers to.
+	Ceiling     float64   `json:"ceiling"`
+	Burst       int       `json:"burst"`
+	Tokens      float64   `json:"tokens"`
+	Throttles   int       `json:"throttles"`
+	PausedUntil time.Time `json:"paused_until,omitzero"`
+	LastUsed    time.Time `json:"last_used"`
+}
+
+// Limiters returns the state of every limiter, sorted by key.
+func (d *DomainRateLimiter) Limiters() []LimiterInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.sweep(now)
+
+	infos := make([]LimiterInfo, 0, len(d.hosts))
+
+	for key, h := range d.hosts {
+		info := LimiterInfo{
+			Key:       key,
+			Burst:     h.limiter.Burst(),
+			Throttles: h.throttles,
+			LastUsed:  h.lastUsed,
+		}
+
+		if limit := h.limiter.Limit(); limit == rate.Inf {
+			info.Unlimited = true
+		} else {
+			info.Limit = float64(limit)
+			info.Ceiling = float64(h.ceiling)
+			info.Tokens = h.limiter.TokensAt(now)
+		}
+
+		if h.pausedUntil.After(now) {
+			info.PausedUntil = h.pausedUntil
+		}
+
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+
+	return infos
+}
+
+func (d *DomainRateLimiter) baseBackoff() time.Duration {
+	if d.BaseBackoff > 0 {
+		return d.BaseBackoff
+	}
+
+	return DefaultBaseBackoff
+}
+
+func (d *DomainRateLimiter) maxBackoff() time.Duration {
+	if d.MaxBackoff > 0 {
+		return d.MaxBackoff
 	}
 
-	return limiter
+	return DefaultMaxBackoff
 }
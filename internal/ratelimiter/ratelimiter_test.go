@@ -1,6 +1,9 @@
 package ratelimiter
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"golang.org/x/time/rate"
 	"testing"
 	"time"
@@ -46,3 +49,220 @@ func TestDomainRateLimiter_DifferentDomains(t *testing.T) {
 		t.Error("Expected second request to test.com to be denied")
 	}
 }
+
+func TestDomainRateLimiter_SetCrawlDelay(t *testing.T) {
+	limiter := NewDomainRateLimiter(rate.Limit(2), 1)
+
+	limiter.SetCrawlDelay("example.com", 10*time.Second)
+
+	if got := limiter.GetLimiter("example.com").Limit(); got != rate.Every(10*time.Second) {
+		t.Errorf("Expected the crawl delay to slow the limiter down, got %v", got)
+	}
+
+	limiter.SetCrawlDelay("example.com", time.Second)
+
+	if got := limiter.GetLimiter("example.com").Limit(); got != rate.Every(10*time.Second) {
+		t.Errorf("Expected a shorter crawl delay not to speed the limiter up, got %v", got)
+	}
+
+	limiter.SetCrawlDelay("test.com", 100*time.Millisecond)
+
+	if got := limiter.GetLimiter("test.com").Limit(); got != rate.Limit(2) {
+		t.Errorf("Expected a crawl delay below the rate limit to be ignored, got %v", got)
+	}
+}
+
+func TestDomainRateLimiter_Throttled(t *testing.T) {
+	limiter := NewDomainRateLimiter(rate.Limit(100), 1)
+	limiter.BaseBackoff = 10 * time.Millisecond
+	limiter.MaxBackoff = 100 * time.Millisecond
+
+	backoffs := []time.Duration{
+		limiter.Throttled("example.com", 0),
+		limiter.Throttled("example.com", 0),
+		limiter.Throttled("example.com", 50*time.Millisecond),
+		limiter.Throttled("example.com", time.Hour),
+	}
+
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond}
+	for i := range want {
+		if backoffs[i] != want[i] {
+			t.Errorf("Expected backoff %d to be %s, got %s", i+1, want[i], backoffs[i])
+		}
+	}
+
+	// Halved four times, but never below one request per MaxBackoff
+	if got := limiter.GetLimiter("example.com").Limit(); got != rate.Limit(10) {
+		t.Errorf("Expected the rate to be halved down to its floor, got %v", got)
+	}
+
+	limiter.Succeeded("example.com")
+
+	if got := limiter.GetLimiter("example.com").Limit(); got != rate.Limit(20) {
+		t.Errorf("Expected a success to raise the rate by a tenth of the limit, got %v", got)
+	}
+
+	if got := limiter.Throttled("example.com", 0); got != 10*time.Millisecond {
+		t.Errorf("Expected a success to reset the backoff, got %s", got)
+	}
+
+	if got := limiter.GetLimiter("test.com").Limit(); got != rate.Limit(100) {
+		t.Errorf("Expected other domains to keep their rate, got %v", got)
+	}
+}
+
+func TestDomainRateLimiter_WaitBacksOff(t *testing.T) {
+	limiter := NewDomainRateLimiter(rate.Inf, 1)
+	limiter.Throttled("example.com", 50*time.Millisecond)
+
+	start := time.Now()
+
+	err := limiter.Wait(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("Wait returned an error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected Wait to sit out the backoff, returned after %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	limiter.Throttled("example.com", time.Second)
+
+	if err := limiter.Wait(ctx, "example.com"); err != context.Canceled {
+		t.Errorf("Expected a cancelled wait to fail, got %v", err)
+	}
+}
+
+func TestDomainRateLimiter_Key(t *testing.T) {
+	limiter := NewDomainRateLimiter(rate.Limit(1), 1)
+
+	if limiter.GetLimiter("a.cdn.example.com") == limiter.GetLimiter("b.cdn.example.com") {
+		t.Error("Expected hosts to have their own limiters by default")
+	}
+
+	limiter = NewDomainRateLimiter(rate.Limit(1), 1)
+	limiter.GroupBy = GroupBySite
+
+	tests := map[string]string{
+		"a.cdn.example.com":  "example.com",
+		"B.CDN.Example.com.": "example.com",
+		"foo.github.io":      "foo.github.io",
+		"www.example.co.uk":  "example.co.uk",
+		"127.0.0.1":          "127.0.0.1",
+		"::1":                "::1",
+		"localhost":          "localhost",
+	}
+
+	for domain, want := range tests {
+		if got := limiter.Key(domain); got != want {
+			t.Errorf("Key(%q) = %q, want %q", domain, got, want)
+		}
+	}
+
+	if limiter.GetLimiter("a.cdn.example.com") != limiter.GetLimiter("b.cdn.example.com") {
+		t.Error("Expected hosts of the same site to share a limiter")
+	}
+}
+
+func TestDomainRateLimiter_Overrides(t *testing.T) {
+	overrides, err := ParseOverrides([]string{"example.com=10:5", " api.example.com=0.5 ", ""})
+	if err != nil {
+		t.Fatalf("ParseOverrides returned an error: %v", err)
+	}
+
+	limiter := NewDomainRateLimiter(rate.Limit(2), 1)
+	limiter.Overrides = overrides
+
+	tests := []struct {
+		domain string
+		limit  rate.Limit
+		burst  int
+	}{
+		{"example.com", 10, 5},
+		{"www.example.com", 10, 5},
+		{"api.example.com", 0.5, 1},
+		{"v2.api.example.com", 0.5, 1},
+		{"example.org", 2, 1},
+	}
+
+	for _, tt := range tests {
+		l := limiter.GetLimiter(tt.domain)
+		if l.Limit() != tt.limit || l.Burst() != tt.burst {
+			t.Errorf("Expected %s to be limited to %v with burst %d, got %v and %d", tt.domain, tt.limit, tt.burst, l.Limit(), l.Burst())
+		}
+	}
+
+	// Grouped by site, the overridden subdomain keeps a limiter of its own
+	limiter = NewDomainRateLimiter(rate.Limit(2), 1)
+	limiter.Overrides = overrides
+	limiter.GroupBy = GroupBySite
+
+	for _, tt := range tests {
+		l := limiter.GetLimiter(tt.domain)
+		if l.Limit() != tt.limit || l.Burst() != tt.burst {
+			t.Errorf("Expected %s to be limited to %v with burst %d when grouped by site, got %v and %d", tt.domain, tt.limit, tt.burst, l.Limit(), l.Burst())
+		}
+	}
+
+	if key := limiter.Key("v2.api.example.com"); key != "api.example.com" {
+		t.Errorf("Expected v2.api.example.com to go through the limiter of api.example.com, got %q", key)
+	}
+
+	if limiter.GetLimiter("www.example.com") != limiter.GetLimiter("cdn.example.com") {
+		t.Error("Expected the other hosts of the site to share a limiter")
+	}
+
+	for _, entry := range []string{"example.com", "=1", "example.com=fast", "example.com=0", "example.com=1:0"} {
+		if _, err := ParseOverrides([]string{entry}); !errors.Is(err, ErrInvalidOverride) {
+			t.Errorf("Expected %q to be rejected, got %v", entry, err)
+		}
+	}
+}
+
+func TestDomainRateLimiter_IdleTTL(t *testing.T) {
+	limiter := NewDomainRateLimiter(rate.Limit(1), 1)
+	limiter.IdleTTL = 20 * time.Millisecond
+
+	limiter.GetLimiter("idle.example")
+	limiter.Throttled("paused.example", time.Second)
+
+	time.Sleep(30 * time.Millisecond)
+
+	limiter.GetLimiter("busy.example")
+
+	var keys []string
+	for _, info := range limiter.Limiters() {
+		keys = append(keys, info.Key)
+	}
+
+	if len(keys) != 2 || keys[0] != "busy.example" || keys[1] != "paused.example" {
+		t.Errorf("Expected the idle limiter to be dropped and the paused one to be kept, got %v", keys)
+	}
+}
+
+func TestDomainRateLimiter_Limiters(t *testing.T) {
+	limiter := NewDomainRateLimiter(rate.Limit(4), 2)
+	limiter.Throttled("example.com", 0)
+	limiter.GetLimiter("unlimited.example").SetLimit(rate.Inf)
+
+	infos := limiter.Limiters()
+	if len(infos) != 2 {
+		t.Fatalf("Expected 2 limiters, got %+v", infos)
+	}
+
+	if info := infos[0]; info.Key != "example.com" || info.Limit != 2 || info.Ceiling != 4 || info.Burst != 2 || info.Throttles != 1 || info.PausedUntil.IsZero() {
+		t.Errorf("Unexpected limiter info %+v", info)
+	}
+
+	if info := infos[1]; !info.Unlimited {
+		t.Errorf("Expected an unlimited limiter, got %+v", info)
+	}
+
+	_, err := json.Marshal(infos)
+	if err != nil {
+		t.Errorf("Expected the limiter table to encode as JSON: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package robots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/cache"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Status tells whether robots.txt allows a URL to be requested.
+type Status string
+
+const (
+	StatusAllowed    Status = "allowed"
+	StatusDisallowed Status = "disallowed"
+	// StatusUnknown means robots.txt could not be fetched, the URL is treated as allowed.
+	StatusUnknown Status = "unknown"
+)
+
+// Mode tells what a scan does with robots.txt.
+type Mode string
+
+const (
+	// ModeReport checks every link and reports whether robots.txt disallows it.
+	ModeReport Mode = "report"
+	// ModeObey does not request disallowed links and honors Crawl-delay.
+	ModeObey Mode = "obey"
+	// ModeIgnore never fetches robots.txt.
+	ModeIgnore Mode = "ignore"
+)
+
+// ErrInvalidMode is returned by ParseMode for unknown modes.
+var ErrInvalidMode = errors.New("robots mode must be report, obey or ignore")
+
+// ParseMode validates a mode given by the user.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case ModeReport, ModeObey, ModeIgnore:
+		return mode, nil
+	}
+
+	return "", ErrInvalidMode
+}
+
+// maxCachedFiles bounds the number of robots.txt files kept, the least recently used are dropped first.
+const maxCachedFiles = 1000
+
+// MaxCrawlDelay caps the Crawl-delay that is honored, so that a single host can't stall a scan.
+const MaxCrawlDelay = 30 * time.Second
+
+// Fetcher fetches robots.txt files and caches them per origin.
+type Fetcher struct {
+	client    *http.Client
+	userAgent string
+	cache     *cache.Cache[string, result]
+
+	mu       sync.Mutex
+	inflight map[string]*call
+
+	// Timeout bounds a fetch, which is shared by every scan that waits for it
+	// and therefore does not end with the scan that started it. Zero leaves it to the client.
+	Timeout time.Duration
+	// FailureTTL is how long an unreachable robots.txt is remembered, so that scans don't
+	// all wait for a dead host again, but the host gets another chance soon. Zero doesn't remember it.
+	FailureTTL time.Duration
+}
+
+type result struct {
+	robots *Robots
+	err    error
+}
+
+// call is a robots.txt request in flight, other lookups of the same origin wait for it.
+type call struct {
+	done chan struct{}
+	res  result
+}
+
+// NewFetcher creates a Fetcher that requests robots.txt as userAgent and keeps the files for ttl.
+func NewFetcher(client *http.Client, userAgent string, ttl time.Duration) *Fetcher {
+	return &Fetcher{
+		client:     client,
+		userAgent:  userAgent,
+		cache:      cache.NewWithOptions(cache.Options[string, result]{TTL: ttl, MaxEntries: maxCachedFiles}),
+		inflight:   make(map[string]*call),
+		Timeout:    30 * time.Second,
+		FailureTTL: time.Minute,
+	}
+}
+
+// Close stops the cleanup of expired robots.txt files.
+func (f *Fetcher) Close() {
+	f.cache.Close()
+}
+
+// Get returns the robots.txt that applies to u.
+//
+// A missing file (4xx) allows everything, a server error (5xx or 429) disallows everything.
+// An error is returned when there was no response at all.
+func (f *Fetcher) Get(ctx context.Context, u *url.URL) (*Robots, error) {
+	origin := u.Scheme + "://" + u.Host
+
+	if res, ok := f.cache.Get(origin); ok {
+		return res.robots, res.err
+	}
+
+	f.mu.Lock()
+
+	c, ok := f.inflight[origin]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		f.inflight[origin] = c
+
+		// Cancelling the scan that came first must not fail the others waiting for the same file
+		fetchCtx, cancel := context.WithoutCancel(ctx), context.CancelFunc(func() {})
+		if f.Timeout > 0 {
+			fetchCtx, cancel = context.WithTimeout(fetchCtx, f.Timeout)
+		}
+
+		go func() {
+			defer cancel()
+
+			c.res.robots, c.res.err = f.fetch(fetchCtx, origin)
+
+			switch {
+			case c.res.err == nil:
+				f.cache.Set(origin, c.res)
+			case f.FailureTTL > 0:
+				f.cache.SetWithTTL(origin, c.res, f.FailureTTL)
+			}
+
+			f.mu.Lock()
+			delete(f.inflight, origin)
+			f.mu.Unlock()
+
+			close(c.done)
+		}()
+	}
+
+	f.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.res.robots, c.res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (f *Fetcher) fetch(ctx context.Context, origin string) (*Robots, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", f.userAgent)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		log.Printf("[robots] Could not fetch %s/robots.txt: %v", origin, err)
+
+		return nil, fmt.Errorf("fetching robots.txt: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return Parse(resp.Body), nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return &Robots{disallowAll: true}, nil
+	default:
+		return &Robots{}, nil
+	}
+}
+
+// Check looks u up in its robots.txt, it returns the status and the Crawl-delay for our user agent.
+func (f *Fetcher) Check(ctx context.Context, u *url.URL) (Status, time.Duration) {
+	robots, err := f.Get(ctx, u)
+	if err != nil {
+		return StatusUnknown, 0
+	}
+
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	delay := min(robots.CrawlDelay(f.userAgent), MaxCrawlDelay)
+
+	if !robots.Allowed(f.userAgent, path) {
+		return StatusDisallowed, delay
+	}
+
+	return StatusAllowed, delay
+}
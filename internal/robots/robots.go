@@ -0,0 +1,186 @@
+package robots
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSize is the amount of a robots.txt file that is parsed, RFC 9309 asks for at least 500 KiB.
+const maxSize = 500 << 10
+
+// Robots is a parsed robots.txt file.
+//
+// See https://www.rfc-editor.org/rfc/rfc9309
+type Robots struct {
+	groups []group
+	// disallowAll is set when the server failed to serve the file,
+	// crawlers must then assume that everything is disallowed.
+	disallowAll bool
+
+	Sitemaps []string
+}
+
+type group struct {
+	agents     []string
+	rules      []rule
+	crawlDelay time.Duration
+}
+
+type rule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// Parse reads a robots.txt file, lines it does not understand are skipped.
+func Parse(r io.Reader) *Robots {
+	robots := &Robots{}
+
+	var (
+		current *group
+		// inAgents is set while reading the user-agent lines that start a group
+		inAgents bool
+	)
+
+	scanner := bufio.NewScanner(io.LimitReader(r, maxSize))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if !inAgents {
+				robots.groups = append(robots.groups, group{})
+				current = &robots.groups[len(robots.groups)-1]
+			}
+
+			inAgents = true
+			current.agents = append(current.agents, productToken(value))
+
+			continue
+		case "allow", "disallow":
+			// An empty disallow allows everything, which is the default anyway
+			if current != nil && value != "" {
+				current.rules = append(current.rules, rule{allow: key == "allow", pattern: value, re: compilePattern(value)})
+			}
+		case "crawl-delay":
+			seconds, err := strconv.ParseFloat(value, 64)
+			if current != nil && err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		case "sitemap":
+			robots.Sitemaps = append(robots.Sitemaps, value)
+		}
+
+		inAgents = false
+	}
+
+	return robots
+}
+
+// productToken returns the lowercase name of a user agent, e.g. "scan24" for "Scan24/1.0".
+func productToken(agent string) string {
+	agent = strings.TrimSpace(agent)
+	if i := strings.IndexAny(agent, "/ "); i >= 0 {
+		agent = agent[:i]
+	}
+
+	return strings.ToLower(agent)
+}
+
+// compilePattern turns a path pattern into a regular expression,
+// "*" matches any sequence of characters and a trailing "$" the end of the path.
+func compilePattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+
+	return regexp.MustCompile(expr)
+}
+
+// groupsFor returns the groups that apply to agent: the ones naming it, or the "*" groups otherwise.
+func (r *Robots) groupsFor(agent string) []group {
+	token := productToken(agent)
+
+	var named, wildcard []group
+
+	for _, g := range r.groups {
+		switch {
+		case slices.Contains(g.agents, token):
+			named = append(named, g)
+		case slices.Contains(g.agents, "*"):
+			wildcard = append(wildcard, g)
+		}
+	}
+
+	if len(named) > 0 {
+		return named
+	}
+
+	return wildcard
+}
+
+// Allowed reports whether agent may request path, which includes the query string.
+//
+// The most specific (longest) matching rule wins, Allow wins over Disallow on a tie.
+func (r *Robots) Allowed(agent, path string) bool {
+	if r.disallowAll {
+		return false
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	if path == "/robots.txt" {
+		return true
+	}
+
+	allowed := true
+	longest := -1
+
+	for _, g := range r.groupsFor(agent) {
+		for _, rule := range g.rules {
+			if !rule.re.MatchString(path) {
+				continue
+			}
+
+			if n := len(rule.pattern); n > longest || (n == longest && rule.allow) {
+				longest = n
+				allowed = rule.allow
+			}
+		}
+	}
+
+	return allowed
+}
+
+// CrawlDelay returns the Crawl-delay that applies to agent, zero if there is none.
+func (r *Robots) CrawlDelay(agent string) time.Duration {
+	for _, g := range r.groupsFor(agent) {
+		if g.crawlDelay > 0 {
+			return g.crawlDelay
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,269 @@
+package robots
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRobots = `# Example
+User-agent: Scan24
+User-agent: OtherBot
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+Disallow: /search?
+Crawl-delay: 2.5
+
+User-agent: *
+Disallow: /
+
+Sitemap: https://example.com/sitemap.xml
+`
+
+func TestRobots_Allowed(t *testing.T) {
+	r := Parse(strings.NewReader(testRobots))
+
+	tests := []struct {
+		agent string
+		path  string
+		want  bool
+	}{
+		{"Scan24/1.0", "/", true},
+		{"Scan24/1.0", "/private", false},
+		{"Scan24/1.0", "/private/page", false},
+		{"Scan24/1.0", "/private/public/page", true},
+		{"Scan24/1.0", "/docs/file.pdf", false},
+		{"Scan24/1.0", "/docs/file.pdf?download=1", true},
+		{"Scan24/1.0", "/search?q=go", false},
+		{"Scan24/1.0", "/search", true},
+		{"otherbot", "/private", false},
+		{"SomeBot", "/", false},
+		{"SomeBot", "/robots.txt", true},
+	}
+
+	for _, tt := range tests {
+		if got := r.Allowed(tt.agent, tt.path); got != tt.want {
+			t.Errorf("Allowed(%q, %q) = %v, want %v", tt.agent, tt.path, got, tt.want)
+		}
+	}
+
+	if got := r.CrawlDelay("Scan24/1.0"); got != 2500*time.Millisecond {
+		t.Errorf("unexpected crawl delay %s", got)
+	}
+
+	if got := r.CrawlDelay("SomeBot"); got != 0 {
+		t.Errorf("unexpected crawl delay %s for the wildcard group", got)
+	}
+
+	if len(r.Sitemaps) != 1 || r.Sitemaps[0] != "https://example.com/sitemap.xml" {
+		t.Errorf("unexpected sitemaps %v", r.Sitemaps)
+	}
+}
+
+func TestRobots_AllowWinsTie(t *testing.T) {
+	r := Parse(strings.NewReader("User-agent: *\nDisallow: /page\nAllow: /page\n"))
+
+	if !r.Allowed("scan24", "/page") {
+		t.Error("expected allow to win over an equally long disallow")
+	}
+}
+
+func TestRobots_Empty(t *testing.T) {
+	r := Parse(strings.NewReader("User-agent: *\nDisallow:\n"))
+
+	if !r.Allowed("scan24", "/anything") {
+		t.Error("expected an empty disallow to allow everything")
+	}
+}
+
+func TestFetcher_Check(t *testing.T) {
+	var fetches atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			t.Errorf("unexpected request for %s", r.URL.Path)
+		}
+
+		fetches.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		_, _ = fmt.Fprint(w, "User-agent: *\nDisallow: /admin\nCrawl-delay: 60\n")
+	}))
+	defer server.Close()
+
+	f := NewFetcher(server.Client(), "Scan24/1.0", time.Minute)
+	defer f.Close()
+
+	var wg sync.WaitGroup
+
+	for _, path := range []string{"/", "/admin", "/admin/users", "/page?admin"} {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			u, _ := url.Parse(server.URL + path)
+
+			want := StatusAllowed
+			if strings.HasPrefix(path, "/admin") {
+				want = StatusDisallowed
+			}
+
+			status, delay := f.Check(context.Background(), u)
+			if status != want {
+				t.Errorf("expected %s to be %s, got %s", path, want, status)
+			}
+
+			if delay != MaxCrawlDelay {
+				t.Errorf("expected the crawl delay to be capped, got %s", delay)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected robots.txt to be fetched once, got %d", n)
+	}
+}
+
+func TestFetcher_ErrorStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   Status
+	}{
+		{http.StatusNotFound, StatusAllowed},
+		{http.StatusForbidden, StatusAllowed},
+		{http.StatusInternalServerError, StatusDisallowed},
+		{http.StatusTooManyRequests, StatusDisallowed},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+
+		f := NewFetcher(server.Client(), "Scan24/1.0", time.Minute)
+		u, _ := url.Parse(server.URL + "/page")
+
+		if status, _ := f.Check(context.Background(), u); status != tt.want {
+			t.Errorf("expected %s for a robots.txt with status %d, got %s", tt.want, tt.status, status)
+		}
+
+		f.Close()
+		server.Close()
+	}
+}
+
+func TestFetcher_Unreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	u, _ := url.Parse(server.URL + "/page")
+	server.Close()
+
+	f := NewFetcher(http.DefaultClient, "Scan24/1.0", time.Minute)
+	defer f.Close()
+
+	if status, _ := f.Check(context.Background(), u); status != StatusUnknown {
+		t.Errorf("expected an unreachable robots.txt to be unknown, got %s", status)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	if mode, err := ParseMode("obey"); err != nil || mode != ModeObey {
+		t.Errorf("unexpected result %q, %v", mode, err)
+	}
+
+	if _, err := ParseMode("maybe"); err != ErrInvalidMode {
+		t.Errorf("expected ErrInvalidMode, got %v", err)
+	}
+}
+
+func TestFetcher_FirstScanCancelled(t *testing.T) {
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		_, _ = fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer server.Close()
+
+	f := NewFetcher(server.Client(), "Scan24/1.0", time.Minute)
+	defer f.Close()
+
+	u, _ := url.Parse(server.URL + "/private")
+
+	first, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		_, _ = f.Check(first, u)
+	}()
+
+	// The second scan waits for the fetch the first one started
+	time.Sleep(20 * time.Millisecond)
+
+	statuses := make(chan Status, 1)
+
+	go func() {
+		status, _ := f.Check(context.Background(), u)
+		statuses <- status
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	wg.Wait()
+	close(release)
+
+	if status := <-statuses; status != StatusDisallowed {
+		t.Errorf("expected the second scan to get the robots.txt, got %s", status)
+	}
+}
+
+func TestFetcher_CachesFailures(t *testing.T) {
+	var fetches atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+
+		// Hang up without a response
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_ = conn.Close()
+	}))
+	defer server.Close()
+
+	f := NewFetcher(server.Client(), "Scan24/1.0", time.Minute)
+	f.FailureTTL = 50 * time.Millisecond
+	defer f.Close()
+
+	u, _ := url.Parse(server.URL + "/page")
+
+	for range 3 {
+		if status, _ := f.Check(context.Background(), u); status != StatusUnknown {
+			t.Errorf("expected an unreachable robots.txt to be unknown, got %s", status)
+		}
+	}
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected the failure to be cached, got %d fetches", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	_, _ = f.Check(context.Background(), u)
+
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected the failure to expire, got %d fetches", n)
+	}
+}
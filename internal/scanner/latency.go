@@ -0,0 +1,93 @@
+package scanner
+
+import (
+	"github.com/hugmouse/scan24/internal/parser"
+	"slices"
+	"sort"
+	"time"
+)
+
+// slowestLinksCount is the number of links listed in PageData.SlowestLinks.
+const slowestLinksCount = 5
+
+// HostLatency sums up how long the links to a single host took to check.
+type HostLatency struct {
+	Host  string        `json:"host"`
+	Links int           `json:"links"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// slowestLinks returns up to n links requested by this scan, slowest first.
+//
+// Cached links keep the timing of the scan that checked them, they are left out of the stats.
+func slowestLinks(links []parser.HyperLink, n int) []parser.HyperLink {
+	var requested []parser.HyperLink
+
+	for _, link := range links {
+		if link.Timing.Total > 0 && !link.Cached {
+			requested = append(requested, link)
+		}
+	}
+
+	sort.SliceStable(requested, func(i, j int) bool {
+		return requested[i].Timing.Total > requested[j].Timing.Total
+	})
+
+	if len(requested) > n {
+		requested = requested[:n]
+	}
+
+	return requested
+}
+
+// hostLatencies computes latency percentiles of links requested by this scan per host, slowest host first.
+func hostLatencies(links []parser.HyperLink) []HostLatency {
+	byHost := make(map[string][]time.Duration)
+
+	for _, link := range links {
+		if link.Timing.Total <= 0 || link.Resolved == nil || link.Cached {
+			continue
+		}
+
+		host := link.Resolved.Host
+		byHost[host] = append(byHost[host], link.Timing.Total)
+	}
+
+	latencies := make([]HostLatency, 0, len(byHost))
+
+	for host, durations := range byHost {
+		slices.Sort(durations)
+
+		latencies = append(latencies, HostLatency{
+			Host:  host,
+			Links: len(durations),
+			P50:   percentile(durations, 50),
+			P90:   percentile(durations, 90),
+			P99:   percentile(durations, 99),
+			Max:   durations[len(durations)-1],
+		})
+	}
+
+	sort.Slice(latencies, func(i, j int) bool {
+		if latencies[i].P90 != latencies[j].P90 {
+			return latencies[i].P90 > latencies[j].P90
+		}
+
+		return latencies[i].Host < latencies[j].Host
+	})
+
+	return latencies
+}
+
+// percentile returns the nearest-rank percentile p of sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
@@ -0,0 +1,49 @@
+package scanner
+
+import (
+	"github.com/hugmouse/scan24/internal/parser"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHostLatencies(t *testing.T) {
+	link := func(rawURL string, total time.Duration) parser.HyperLink {
+		u, _ := url.Parse(rawURL)
+
+		return parser.HyperLink{Raw: rawURL, Resolved: u, Timing: parser.Timing{Total: total}}
+	}
+
+	var links []parser.HyperLink
+	for i := 1; i <= 10; i++ {
+		links = append(links, link("https://fast.example/"+string(rune('a'+i)), time.Duration(i)*time.Millisecond))
+	}
+
+	cached := link("https://cached.example/", 5*time.Second)
+	cached.Cached = true
+
+	links = append(links,
+		link("https://slow.example/", 2*time.Second),
+		link("mailto:me@example.com", 0),
+		cached,
+	)
+
+	latencies := hostLatencies(links)
+	if len(latencies) != 2 {
+		t.Fatalf("expected 2 hosts, got %+v", latencies)
+	}
+
+	if latencies[0].Host != "slow.example" || latencies[0].P50 != 2*time.Second {
+		t.Errorf("expected the slow host first: %+v", latencies[0])
+	}
+
+	fast := latencies[1]
+	if fast.Links != 10 || fast.P50 != 5*time.Millisecond || fast.P90 != 9*time.Millisecond || fast.P99 != 10*time.Millisecond || fast.Max != 10*time.Millisecond {
+		t.Errorf("unexpected percentiles: %+v", fast)
+	}
+
+	slowest := slowestLinks(links, 2)
+	if len(slowest) != 2 || slowest[0].Raw != "https://slow.example/" || slowest[1].Timing.Total != 10*time.Millisecond {
+		t.Errorf("unexpected slowest links: %+v", slowest)
+	}
+}
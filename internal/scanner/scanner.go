@@ -0,0 +1,632 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/hugmouse/scan24/internal/linkcache"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"github.com/hugmouse/scan24/internal/robots"
+	"github.com/hugmouse/scan24/internal/workerpool"
+	"golang.org/x/net/html/charset"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LinkCounters counts links by their type relative to the page, see parser.ClassifyURL.
+//
+// A link is alive if it responded with 200 OK.
+type LinkCounters struct {
+	// Internal sums up same-origin, subdomain and same-site links
+	Internal        int64 `json:"internal"`
+	InternalAlive   int64 `json:"internal_alive"`
+	SameOrigin      int64 `json:"same_origin"`
+	SameOriginAlive int64 `json:"same_origin_alive"`
+	Subdomain       int64 `json:"subdomain"`
+	SubdomainAlive  int64 `json:"subdomain_alive"`
+	SameSite        int64 `json:"same_site"`
+	SameSiteAlive   int64 `json:"same_site_alive"`
+	External        int64 `json:"external"`
+	ExternalAlive   int64 `json:"external_alive"`
+	Protocol        int64 `json:"protocol"`
+	// Protocols breaks protocol links down by scheme, e.g. mailto, tel or javascript
+	Protocols map[string]int64 `json:"protocols,omitempty"`
+}
+
+// Add counts a single link.
+func (c *LinkCounters) Add(link parser.HyperLink) {
+	alive := int64(0)
+	if link.StatusCode == http.StatusOK {
+		alive = 1
+	}
+
+	hrefType := parser.HrefType(link.HrefType)
+
+	switch hrefType {
+	case parser.SameOrigin:
+		c.SameOrigin++
+		c.SameOriginAlive += alive
+	case parser.Subdomain:
+		c.Subdomain++
+		c.SubdomainAlive += alive
+	case parser.SameSite:
+		c.SameSite++
+		c.SameSiteAlive += alive
+	case parser.External:
+		c.External++
+		c.ExternalAlive += alive
+	case parser.Protocol:
+		scheme := "other"
+		if link.Resolved != nil {
+			scheme = strings.ToLower(link.Resolved.Scheme)
+		}
+
+		if c.Protocols == nil {
+			c.Protocols = make(map[string]int64)
+		}
+
+		c.Protocol++
+		c.Protocols[scheme]++
+	}
+
+	if parser.IsInternal(hrefType) {
+		c.Internal++
+		c.InternalAlive += alive
+	}
+}
+
+type PageData struct {
+	URL             string             `json:"url"`       // requested URL
+	FinalURL        string             `json:"final_url"` // URL after following redirects
+	BaseURL         string             `json:"base_url"`  // URL that relative links are resolved against
+	Title           string             `json:"title"`
+	HTMLVersion     string             `json:"html_version"`
+	Headings        map[string]int     `json:"headings"`
+	LinkCounters    LinkCounters       `json:"link_counters"`
+	HyperLinks      []parser.HyperLink `json:"hyperlinks"`
+	HasLoginForm    bool               `json:"has_login_form"`
+	SiteInformation string             `json:"site_information,omitempty"`
+	Error           string             `json:"error,omitempty"`
+	// Robots tells whether robots.txt allows the page itself
+	Robots robots.Status `json:"robots,omitempty"`
+
+	// Timing of the page fetch itself
+	Timing        parser.Timing      `json:"timing"`
+	SlowestLinks  []parser.HyperLink `json:"slowest_links,omitempty"`
+	HostLatencies []HostLatency      `json:"host_latencies,omitempty"`
+
+	// Throttling lists the 429 and 503 responses that made the scan back off, in order
+	Throttling []ThrottleEvent `json:"throttling,omitempty"`
+}
+
+// ThrottleEvent is a throttling response to a link check.
+type ThrottleEvent struct {
+	Host       string `json:"host"`
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	// RetryAfter is what the server asked for, Backoff how long the host was actually paused.
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+	Backoff    time.Duration `json:"backoff"`
+	// Attempt is the 1-based number of the check that was throttled.
+	Attempt int       `json:"attempt"`
+	At      time.Time `json:"at"`
+	// Retried is set when the link was checked again afterwards.
+	Retried bool `json:"retried"`
+}
+
+// BrokenLinks returns links that were checked and turned out broken, see parser.HyperLink.Broken.
+func (p PageData) BrokenLinks() []parser.HyperLink {
+	var broken []parser.HyperLink
+
+	for _, link := range p.HyperLinks {
+		if link.Broken() {
+			broken = append(broken, link)
+		}
+	}
+
+	return broken
+}
+
+// Stage tells at which point fetching a document failed.
+type Stage string
+
+const (
+	StageFetch   Stage = "fetch"
+	StageStatus  Stage = "status"
+	StageCharset Stage = "charset"
+	StageRead    Stage = "read"
+	StageParse   Stage = "parse"
+)
+
+// FetchError is returned by Fetch when the page could not be loaded.
+type FetchError struct {
+	Stage      Stage
+	StatusCode int
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	switch e.Stage {
+	case StageFetch:
+		return fmt.Sprintf("Failed to fetch URL: %v", e.Err)
+	case StageStatus:
+		return fmt.Sprintf("URL that you provided failed to load, code: %d", e.StatusCode)
+	case StageCharset:
+		return fmt.Sprintf("Failed to create charset reader: %v", e.Err)
+	case StageRead:
+		return fmt.Sprintf("Failed to read response body: %v", e.Err)
+	default:
+		return fmt.Sprintf("Failed to parse HTML: %v", e.Err)
+	}
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// Document is a fetched and parsed HTML page.
+type Document struct {
+	URL *url.URL
+	// FinalURL is the URL the page was actually loaded from, after redirects.
+	FinalURL *url.URL
+	// BaseURL is the document base URL, links of the page are relative to it.
+	BaseURL     *url.URL
+	ContentType string
+	Timing      parser.Timing
+	Body        []byte
+	Doc         *goquery.Document
+}
+
+// DefaultMaxConcurrency is the number of links of a single page checked at the same time
+// when Scanner.MaxConcurrency is not set.
+const DefaultMaxConcurrency = 16
+
+// Scanner runs the page analysis, it is shared by the web server and the CLI.
+type Scanner struct {
+	Client      *http.Client
+	RateLimiter *ratelimiter.DomainRateLimiter
+
+	// Pool runs link checks, when nil every check gets its own goroutine.
+	Pool *workerpool.Pool
+	// MaxConcurrency caps the number of link checks in flight for one page.
+	MaxConcurrency int
+	// LongRedirectChain is passed on to parser.Checker.
+	LongRedirectChain int
+
+	// LinkCache, if set, is consulted before checking a link and remembers the result.
+	LinkCache *linkcache.Cache
+	// BypassLinkCache makes the scan check every link again, fresh results are still cached.
+	BypassLinkCache bool
+	// SkipSPAFragments is passed on to parser.Checker.
+	SkipSPAFragments bool
+	// MaxRetries is how often a link is checked again after a 429 or 503 response.
+	MaxRetries int
+
+	// Robots fetches robots.txt files, RobotsMode tells what to do with them.
+	// Without a Fetcher or a mode robots.txt is ignored.
+	Robots     *robots.Fetcher
+	RobotsMode robots.Mode
+}
+
+func (s *Scanner) maxConcurrency() int {
+	if s.MaxConcurrency > 0 {
+		return s.MaxConcurrency
+	}
+
+	return DefaultMaxConcurrency
+}
+
+// ProgressFunc is called every time a link check is finished with the link that was checked.
+//
+// Links that could not be checked at all only have Raw and Err set.
+type ProgressFunc func(link parser.HyperLink, done, total int64)
+
+// Fetch downloads targetURL and parses it as HTML.
+func (s *Scanner) Fetch(ctx context.Context, targetURL *url.URL) (*Document, error) {
+	traced, stop := parser.Trace(ctx)
+
+	req, err := http.NewRequestWithContext(traced, http.MethodGet, targetURL.String(), nil)
+	if err != nil {
+		return nil, &FetchError{Stage: StageFetch, Err: err}
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, &FetchError{Stage: StageFetch, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &FetchError{Stage: StageStatus, StatusCode: resp.StatusCode}
+	}
+
+	ct := resp.Header.Get("Content-Type")
+
+	reader, err := charset.NewReader(resp.Body, ct)
+	if err != nil {
+		return nil, &FetchError{Stage: StageCharset, Err: err}
+	}
+
+	bodyBytes, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, &FetchError{Stage: StageRead, Err: err}
+	}
+
+	timing := stop()
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, &FetchError{Stage: StageParse, Err: err}
+	}
+
+	finalURL := resp.Request.URL
+
+	return &Document{
+		URL:         targetURL,
+		FinalURL:    finalURL,
+		BaseURL:     documentBaseURL(doc, finalURL),
+		ContentType: ct,
+		Timing:      timing,
+		Body:        bodyBytes,
+		Doc:         doc,
+	}, nil
+}
+
+// documentBaseURL returns the base URL of the document as defined by the HTML spec:
+// the href of the first <base> element with one, resolved against the document URL.
+//
+// See https://html.spec.whatwg.org/multipage/urls-and-fetching.html#document-base-url
+func documentBaseURL(doc *goquery.Document, documentURL *url.URL) *url.URL {
+	href, ok := doc.Find("base[href]").First().Attr("href")
+	if !ok {
+		return documentURL
+	}
+
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		log.Printf("[%s] Ignoring invalid <base href=%q>: %v", documentURL, href, err)
+
+		return documentURL
+	}
+
+	return documentURL.ResolveReference(ref)
+}
+
+// Scan fetches targetURL and analyzes it.
+func (s *Scanner) Scan(ctx context.Context, targetURL *url.URL, progress ProgressFunc) (PageData, error) {
+	doc, err := s.Fetch(ctx, targetURL)
+	if err != nil {
+		return PageData{}, err
+	}
+
+	return s.Analyze(ctx, doc, progress), nil
+}
+
+// Analyze collects page information and checks every link of the document.
+//
+// Link checks are aborted once ctx is done, progress may be nil.
+func (s *Scanner) Analyze(ctx context.Context, document *Document, progress ProgressFunc) PageData {
+	doc := document.Doc
+	baseURL := document.BaseURL
+
+	htmlVersion, err := parser.GetHTMLVersion(string(document.Body))
+	if err != nil {
+		log.Printf("Could not determine HTML version: %v", err)
+
+		htmlVersion = &parser.DoctypeNode{Name: "Unknown"}
+	}
+
+	title := getTitle(doc)
+
+	headings := make(map[string]int, 6)
+	for _, tag := range []string{"h1", "h2", "h3", "h4", "h5", "h6"} {
+		headings[tag] = doc.Find(tag).Length()
+	}
+
+	links := make([]parser.HyperLink, 0)
+
+	var (
+		jobDone    int64
+		counters   LinkCounters
+		throttling []ThrottleEvent
+	)
+
+	var (
+		wg     sync.WaitGroup
+		linkMu sync.Mutex
+	)
+
+	// Check every anchor and every resource of the page
+	//
+	// This also runs for href that = "#!" or "./" and etc since there might
+	// be a custom HTTP server that renders something different on those URLs
+	//
+	// Links to the same URL are checked once, with every place they occur at
+	groups := groupLinks(parser.ExtractLinks(doc), baseURL)
+
+	total := int64(len(groups))
+	checker := &parser.Checker{
+		Client:            s.Client,
+		LongRedirectChain: s.LongRedirectChain,
+		Document:          doc,
+		DocumentURL:       document.FinalURL,
+		SkipSPAFragments:  s.SkipSPAFragments,
+	}
+
+	checkLink := func(group linkGroup) {
+		occurrences := group.occurrences
+		ref := occurrences[0]
+		attr := ref.URL
+		checked := parser.HyperLink{Raw: attr, Element: ref.Element, Attr: ref.Attr, Occurrences: occurrences}
+
+		defer func() {
+			done := atomic.AddInt64(&jobDone, 1)
+			if progress != nil {
+				progress(checked, done, total)
+			}
+		}()
+
+		var robotsStatus robots.Status
+
+		record := func(link parser.HyperLink) {
+			link.Element = ref.Element
+			link.Attr = ref.Attr
+			link.Occurrences = occurrences
+			link.Robots = robotsStatus
+			checked = link
+
+			linkMu.Lock()
+
+			links = append(links, link)
+			counters.Add(link)
+
+			linkMu.Unlock()
+		}
+
+		// The key of a link that can't be resolved is its raw href
+		u, err := url.Parse(group.key)
+		if err != nil {
+			log.Printf("could not parse url %s: %v", attr, err)
+
+			// Check reports it as an invalid URL without sending anything
+			record(checker.Check(ctx, attr, baseURL))
+
+			return
+		}
+
+		// Only links that are actually requested are looked up in robots.txt and cached
+		requested := u.Scheme == "http" || u.Scheme == "https"
+		cacheable := s.LinkCache != nil && requested
+
+		if requested {
+			robotsStatus = s.robotsStatus(ctx, u)
+		}
+
+		if robotsStatus == robots.StatusDisallowed && s.RobotsMode == robots.ModeObey {
+			record(parser.HyperLink{
+				Raw:        attr,
+				Resolved:   u,
+				HrefType:   string(parser.ClassifyURL(u, baseURL)),
+				StatusCode: -1,
+				Failure:    parser.FailureRobotsDisallowed,
+				Err:        &parser.LinkError{Kind: parser.FailureRobotsDisallowed, Err: parser.ErrRobotsDisallowed},
+			})
+
+			return
+		}
+
+		if cacheable && !s.BypassLinkCache {
+			if link, ok := s.LinkCache.Get(group.key); ok {
+				link.Raw = attr
+				link.Cached = true
+
+				if link.Resolved != nil {
+					link.HrefType = string(parser.ClassifyURL(link.Resolved, baseURL))
+				}
+
+				record(link)
+
+				return
+			}
+		}
+
+		var link parser.HyperLink
+
+		if checker.Requests(attr, baseURL) {
+			// Use the domain of the link to get a rate limiter
+			host := u.Hostname()
+
+			for attempt := 0; ; attempt++ {
+				err = s.RateLimiter.Wait(ctx, host)
+				if err != nil {
+					log.Printf("rate limiter wait error: %v", err)
+
+					// A scan cancelled while backing off still reports the throttled result
+					if attempt > 0 {
+						break
+					}
+
+					checked.Err = err
+					return
+				}
+
+				log.Printf("[%s] Checking out: %s", baseURL, attr)
+
+				link = checker.Check(ctx, attr, baseURL)
+				link.Retries = attempt
+
+				if !parser.IsThrottled(link.StatusCode) {
+					if link.StatusCode > 0 {
+						s.RateLimiter.Succeeded(host)
+					}
+
+					break
+				}
+
+				event := ThrottleEvent{
+					Host:       host,
+					URL:        attr,
+					StatusCode: link.StatusCode,
+					RetryAfter: link.RetryAfter,
+					Backoff:    s.RateLimiter.Throttled(host, link.RetryAfter),
+					Attempt:    attempt + 1,
+					At:         time.Now(),
+					Retried:    attempt < s.MaxRetries,
+				}
+
+				log.Printf("[%s] %s answered %d, backing off %s", baseURL, host, event.StatusCode, event.Backoff)
+
+				linkMu.Lock()
+				throttling = append(throttling, event)
+				linkMu.Unlock()
+
+				if !event.Retried {
+					break
+				}
+			}
+		} else {
+			// Nothing is fetched for mailto: links and anchors of the page itself, they don't
+			// wait for a limiter, or all of them would queue up behind the one of an empty host
+			link = checker.Check(ctx, attr, baseURL)
+		}
+
+		if cacheable {
+			s.LinkCache.Set(group.key, link)
+		}
+
+		record(link)
+	}
+
+	// At most maxConcurrency links of this page are checked at the same time,
+	// the shared worker pool (if any) bounds the number of checks across all scans
+	sem := make(chan struct{}, s.maxConcurrency())
+
+dispatch:
+	for _, group := range groups {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+
+		wg.Add(1)
+
+		task := func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			checkLink(group)
+		}
+
+		if s.Pool == nil {
+			go task()
+
+			continue
+		}
+
+		err := s.Pool.Submit(ctx, task)
+		if err != nil {
+			log.Printf("[%s] Could not schedule link check: %v", baseURL, err)
+
+			wg.Done()
+			<-sem
+
+			break
+		}
+	}
+
+	wg.Wait()
+
+	haveLoginForm := parser.HasLoginForm(doc)
+	pageRobots := s.robotsStatus(ctx, document.FinalURL)
+
+	return PageData{
+		URL:           document.URL.String(),
+		FinalURL:      document.FinalURL.String(),
+		BaseURL:       baseURL.String(),
+		Title:         title,
+		HTMLVersion:   htmlVersion.Name,
+		Headings:      headings,
+		HyperLinks:    links,
+		HasLoginForm:  haveLoginForm,
+		LinkCounters:  counters,
+		Robots:        pageRobots,
+		Timing:        document.Timing,
+		SlowestLinks:  slowestLinks(links, slowestLinksCount),
+		HostLatencies: hostLatencies(links),
+		Throttling:    throttling,
+	}
+}
+
+// robotsStatus looks u up in its robots.txt, when obeying it the rate limiter
+// of the host is slowed down to its Crawl-delay.
+func (s *Scanner) robotsStatus(ctx context.Context, u *url.URL) robots.Status {
+	if s.Robots == nil || s.RobotsMode == "" || s.RobotsMode == robots.ModeIgnore {
+		return ""
+	}
+
+	status, delay := s.Robots.Check(ctx, u)
+	if s.RobotsMode == robots.ModeObey {
+		s.RateLimiter.SetCrawlDelay(u.Hostname(), delay)
+	}
+
+	return status
+}
+
+// linkGroup is every occurrence of the same URL in a document.
+type linkGroup struct {
+	key         string
+	occurrences []parser.LinkRef
+}
+
+// groupLinks groups refs by parser.LinkKey, keeping the order of the first occurrences.
+func groupLinks(refs []parser.LinkRef, baseURL *url.URL) []linkGroup {
+	var groups []linkGroup
+
+	index := make(map[string]int, len(refs))
+
+	for _, ref := range refs {
+		key := parser.LinkKey(ref.URL, baseURL)
+
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, linkGroup{key: key})
+		}
+
+		groups[i].occurrences = append(groups[i].occurrences, ref)
+	}
+
+	return groups
+}
+
+// getTitle attempts to get title from passed *goquery.Document
+//
+// Based on webkit source code HTML can contain multiple <title> tags,
+// but we only care about the first one that we encounter
+//
+// Example:
+//
+// void Document::setTitleElement(const StringWithDirection& title, Element* titleElement)
+//
+//	{
+//	    if (titleElement != m_titleElement) {
+//	        if (m_titleElement || m_titleSetExplicitly)
+//	            // Only allow the first title element to change the title -- others have no effect.
+//	            return;
+//	        m_titleElement = titleElement;
+//	    }
+//	    updateTitle(title);
+//	}
+func getTitle(doc *goquery.Document) (title string) {
+	return doc.Find("title").First().Text()
+}
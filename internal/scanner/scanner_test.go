@@ -0,0 +1,498 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/linkcache"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/ratelimiter"
+	"github.com/hugmouse/scan24/internal/robots"
+	"golang.org/x/time/rate"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScanner_Scan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><head><title>Test</title></head><body><h1>Hi</h1><a href="/page2">Link</a><a href="/missing">Missing</a><a href="mailto:me@example.com">Mail</a></body></html>`)
+	}))
+	defer server.Close()
+
+	s := &Scanner{
+		Client:      server.Client(),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(100), 1),
+	}
+
+	u, _ := url.Parse(server.URL)
+
+	var (
+		calls   atomic.Int64
+		missing atomic.Int64
+	)
+
+	page, err := s.Scan(context.Background(), u, func(link parser.HyperLink, done, total int64) {
+		calls.Add(1)
+
+		if link.Raw == "/missing" && link.StatusCode == http.StatusNotFound {
+			missing.Add(1)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Scan returned an error: %v", err)
+	}
+
+	if page.Title != "Test" {
+		t.Errorf("unexpected title: got %q want %q", page.Title, "Test")
+	}
+
+	if page.Headings["h1"] != 1 {
+		t.Errorf("unexpected h1 count: got %d want 1", page.Headings["h1"])
+	}
+
+	if page.LinkCounters.Internal != 2 || page.LinkCounters.InternalAlive != 1 || page.LinkCounters.Protocol != 1 {
+		t.Errorf("unexpected link counters: %+v", page.LinkCounters)
+	}
+
+	if page.LinkCounters.SameOrigin != 2 || page.LinkCounters.Protocols["mailto"] != 1 {
+		t.Errorf("unexpected link breakdown: %+v", page.LinkCounters)
+	}
+
+	if calls.Load() != 3 {
+		t.Errorf("expected progress to be reported 3 times, got %d", calls.Load())
+	}
+
+	if missing.Load() != 1 {
+		t.Errorf("expected the checked link to be reported with its status")
+	}
+
+	broken := page.BrokenLinks()
+	if len(broken) != 1 || broken[0].Raw != "/missing" {
+		t.Errorf("unexpected broken links: %+v", broken)
+	}
+}
+
+func TestScanner_FetchBadStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	s := &Scanner{Client: server.Client()}
+
+	u, _ := url.Parse(server.URL)
+
+	_, err := s.Fetch(context.Background(), u)
+
+	fErr, ok := err.(*FetchError)
+	if !ok {
+		t.Fatalf("expected *FetchError, got %T (%v)", err, err)
+	}
+
+	if fErr.Stage != StageStatus || fErr.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected fetch error: %+v", fErr)
+	}
+}
+
+func TestScanner_BaseURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/docs/index.html", http.StatusMovedPermanently)
+		case "/docs/index.html":
+			_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><head><base href="/v2/"><base href="/ignored/"></head><body><a href="page">Page</a></body></html>`)
+		case "/v2/page":
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	s := &Scanner{
+		Client:      server.Client(),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(100), 1),
+	}
+
+	u, _ := url.Parse(server.URL + "/")
+
+	page, err := s.Scan(context.Background(), u, nil)
+	if err != nil {
+		t.Fatalf("Scan returned an error: %v", err)
+	}
+
+	if page.URL != server.URL+"/" || page.FinalURL != server.URL+"/docs/index.html" || page.BaseURL != server.URL+"/v2/" {
+		t.Errorf("unexpected URLs: requested %q, final %q, base %q", page.URL, page.FinalURL, page.BaseURL)
+	}
+
+	if len(page.HyperLinks) != 1 || page.HyperLinks[0].StatusCode != http.StatusOK {
+		t.Errorf("expected the link to be resolved against <base href>: %+v", page.HyperLinks)
+	}
+}
+
+func TestScanner_Subresources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><head><link rel="stylesheet" href="/main.css"></head><body><a href="/page">Page</a><img src="/missing.png" srcset="/logo.png 2x"></body></html>`)
+		case "/missing.png":
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	s := &Scanner{
+		Client:      server.Client(),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(100), 1),
+	}
+
+	u, _ := url.Parse(server.URL)
+
+	page, err := s.Scan(context.Background(), u, nil)
+	if err != nil {
+		t.Fatalf("Scan returned an error: %v", err)
+	}
+
+	if len(page.HyperLinks) != 4 {
+		t.Fatalf("expected 4 checked links, got %+v", page.HyperLinks)
+	}
+
+	broken := page.BrokenLinks()
+	if len(broken) != 1 || broken[0].Element != "img" || broken[0].Attr != "src" || broken[0].IsNavigation() {
+		t.Errorf("expected the missing image to be broken: %+v", broken)
+	}
+}
+
+func TestScanner_Deduplicate(t *testing.T) {
+	var heads atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" && r.Method == http.MethodGet {
+			_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><body>
+				<nav><a href="/about">About</a><a href="/contact">Contact</a></nav>
+				<p><a href="about">About us</a></p>
+				<footer><a href="/about">About</a><a href="/ABOUT">Shouting</a></footer>
+			</body></html>`)
+
+			return
+		}
+
+		if r.URL.Path == "/about" {
+			heads.Add(1)
+		}
+	}))
+	defer server.Close()
+
+	s := &Scanner{
+		Client:      server.Client(),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(100), 1),
+	}
+
+	u, _ := url.Parse(server.URL)
+
+	var lastTotal atomic.Int64
+
+	page, err := s.Scan(context.Background(), u, func(_ parser.HyperLink, _, total int64) {
+		lastTotal.Store(total)
+	})
+	if err != nil {
+		t.Fatalf("Scan returned an error: %v", err)
+	}
+
+	if len(page.HyperLinks) != 3 || lastTotal.Load() != 3 {
+		t.Fatalf("expected 3 unique links, got %d (total %d)", len(page.HyperLinks), lastTotal.Load())
+	}
+
+	if heads.Load() != 1 {
+		t.Errorf("expected /about to be checked once, got %d requests", heads.Load())
+	}
+
+	for _, link := range page.HyperLinks {
+		if link.Raw != "/about" {
+			continue
+		}
+
+		if len(link.Occurrences) != 3 {
+			t.Fatalf("expected /about to occur 3 times: %+v", link.Occurrences)
+		}
+
+		second := link.Occurrences[1]
+		if second.URL != "about" || second.Text != "About us" || second.Path != "html > body > p > a" || second.Position != 3 {
+			t.Errorf("unexpected second occurrence: %+v", second)
+		}
+	}
+}
+
+func TestScanner_LinkCache(t *testing.T) {
+	var checks atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><body><a href="/page2">Link</a><a href="mailto:me@example.com">Mail</a></body></html>`)
+
+			return
+		}
+
+		checks.Add(1)
+	}))
+	defer server.Close()
+
+	s := &Scanner{
+		Client:      server.Client(),
+		RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(100), 1),
+		LinkCache:   linkcache.New(time.Minute, time.Minute, 0),
+	}
+	defer s.LinkCache.Close()
+
+	u, _ := url.Parse(server.URL)
+
+	cached := func() (n int) {
+		page, err := s.Scan(context.Background(), u, nil)
+		if err != nil {
+			t.Fatalf("Scan returned an error: %v", err)
+		}
+
+		for _, link := range page.HyperLinks {
+			if link.Cached {
+				n++
+
+				if link.Raw != "/page2" || link.StatusCode != http.StatusOK || link.HrefType != string(parser.SameOrigin) {
+					t.Errorf("unexpected cached link: %+v", link)
+				}
+			}
+		}
+
+		return n
+	}
+
+	if n := cached(); n != 0 || checks.Load() != 1 {
+		t.Fatalf("expected the first scan to check the link, got %d cached and %d checks", n, checks.Load())
+	}
+
+	if n := cached(); n != 1 || checks.Load() != 1 {
+		t.Fatalf("expected the second scan to use the cache, got %d cached and %d checks", n, checks.Load())
+	}
+
+	s.BypassLinkCache = true
+
+	if n := cached(); n != 0 || checks.Load() != 2 {
+		t.Fatalf("expected a bypassing scan to check the link again, got %d cached and %d checks", n, checks.Load())
+	}
+
+	if stats := s.LinkCache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+}
+
+func TestScanner_Robots(t *testing.T) {
+	var privateChecks atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><body><a href="/public">Public</a><a href="/private/page">Private</a></body></html>`)
+		case "/robots.txt":
+			_, _ = fmt.Fprintln(w, "User-agent: *\nDisallow: /private")
+		case "/private/page":
+			privateChecks.Add(1)
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+
+	scan := func(mode robots.Mode) map[string]parser.HyperLink {
+		s := &Scanner{
+			Client:      server.Client(),
+			RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(100), 1),
+			Robots:      robots.NewFetcher(server.Client(), parser.UserAgent, time.Minute),
+			RobotsMode:  mode,
+		}
+
+		page, err := s.Scan(context.Background(), u, nil)
+		if err != nil {
+			t.Fatalf("Scan returned an error: %v", err)
+		}
+
+		links := make(map[string]parser.HyperLink)
+		for _, link := range page.HyperLinks {
+			links[link.Raw] = link
+		}
+
+		return links
+	}
+
+	links := scan(robots.ModeReport)
+	if link := links["/private/page"]; link.Robots != robots.StatusDisallowed || link.StatusCode != http.StatusOK {
+		t.Errorf("expected report mode to check the disallowed link, got %+v", link)
+	}
+
+	if link := links["/public"]; link.Robots != robots.StatusAllowed {
+		t.Errorf("expected the public link to be allowed, got %+v", link)
+	}
+
+	links = scan(robots.ModeObey)
+	if link := links["/private/page"]; link.Failure != parser.FailureRobotsDisallowed || link.Broken() {
+		t.Errorf("expected obey mode to skip the disallowed link without reporting it as broken, got %+v", link)
+	}
+
+	links = scan(robots.ModeIgnore)
+	if link := links["/private/page"]; link.Robots != "" || link.StatusCode != http.StatusOK {
+		t.Errorf("expected ignore mode not to look at robots.txt, got %+v", link)
+	}
+
+	if n := privateChecks.Load(); n != 2 {
+		t.Errorf("expected the disallowed link to be checked in report and ignore mode only, got %d checks", n)
+	}
+}
+
+func TestScanner_Throttling(t *testing.T) {
+	var busyChecks atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><body><a href="/busy">Busy</a><a href="/down">Down</a></body></html>`)
+		case "/busy":
+			// Throttled once, then fine
+			if busyChecks.Add(1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	limiter := ratelimiter.NewDomainRateLimiter(rate.Limit(100), 1)
+	limiter.BaseBackoff = time.Millisecond
+	limiter.MaxBackoff = 10 * time.Millisecond
+
+	s := &Scanner{
+		Client:         server.Client(),
+		RateLimiter:    limiter,
+		MaxConcurrency: 1,
+		MaxRetries:     2,
+	}
+
+	u, _ := url.Parse(server.URL)
+
+	page, err := s.Scan(context.Background(), u, nil)
+	if err != nil {
+		t.Fatalf("Scan returned an error: %v", err)
+	}
+
+	links := make(map[string]parser.HyperLink)
+	for _, link := range page.HyperLinks {
+		links[link.Raw] = link
+	}
+
+	if link := links["/busy"]; link.StatusCode != http.StatusOK || link.Retries != 1 {
+		t.Errorf("expected /busy to work after one retry, got status %d after %d retries", link.StatusCode, link.Retries)
+	}
+
+	if link := links["/down"]; link.StatusCode != http.StatusServiceUnavailable || link.Retries != 2 {
+		t.Errorf("expected /down to give up after two retries, got status %d after %d retries", link.StatusCode, link.Retries)
+	}
+
+	if len(page.Throttling) != 4 {
+		t.Fatalf("expected 4 throttling events, got %+v", page.Throttling)
+	}
+
+	if last := page.Throttling[3]; last.URL != "/down" || last.Attempt != 3 || last.Retried {
+		t.Errorf("expected the last event to be the final attempt of /down, got %+v", last)
+	}
+}
+
+func TestScanner_LimiterKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><body><a href="/relative">Relative</a><a href="page">Page</a></body></html>`)
+	}))
+	defer server.Close()
+
+	limiter := ratelimiter.NewDomainRateLimiter(rate.Limit(100), 1)
+	s := &Scanner{Client: server.Client(), RateLimiter: limiter}
+
+	u, _ := url.Parse(server.URL)
+
+	_, err := s.Scan(context.Background(), u, nil)
+	if err != nil {
+		t.Fatalf("Scan returned an error: %v", err)
+	}
+
+	infos := limiter.Limiters()
+	if len(infos) != 1 || infos[0].Key != u.Hostname() {
+		t.Errorf("expected relative links to be limited as %s, got %+v", u.Hostname(), infos)
+	}
+}
+
+func TestScanner_UnrequestedLinksSkipLimiter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><body>
+			<h2 id="a">A</h2><h2 id="b">B</h2><h2 id="c">C</h2>
+			<a href="#a">A</a><a href="#b">B</a><a href="#c">C</a><a href="#missing">Missing</a>
+			<a href="mailto:a@example.com">Mail</a><a href="mailto:b@example.com">Mail</a>
+			<a href="tel:+100000000">Call</a><a href="javascript:void(0)">JS</a>
+		</body></html>`)
+	}))
+	defer server.Close()
+
+	// Every link that waited would take another second
+	limiter := ratelimiter.NewDomainRateLimiter(rate.Limit(1), 1)
+	s := &Scanner{Client: server.Client(), RateLimiter: limiter}
+
+	u, _ := url.Parse(server.URL)
+	start := time.Now()
+
+	page, err := s.Scan(context.Background(), u, nil)
+	if err != nil {
+		t.Fatalf("Scan returned an error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected links that are not requested to skip the rate limiter, the scan took %s", elapsed)
+	}
+
+	if len(page.HyperLinks) != 8 {
+		t.Errorf("expected 8 checked links, got %d", len(page.HyperLinks))
+	}
+
+	for _, info := range limiter.Limiters() {
+		if info.Key != u.Hostname() {
+			t.Errorf("unexpected limiter %q", info.Key)
+		}
+	}
+}
+
+func TestScanner_InvalidURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><body><a href="/">Home</a><a href="http://[::1">Broken</a></body></html>`)
+	}))
+	defer server.Close()
+
+	s := &Scanner{Client: server.Client(), RateLimiter: ratelimiter.NewDomainRateLimiter(rate.Limit(1000), 1)}
+
+	u, _ := url.Parse(server.URL)
+
+	page, err := s.Scan(context.Background(), u, nil)
+	if err != nil {
+		t.Fatalf("Scan returned an error: %v", err)
+	}
+
+	if len(page.HyperLinks) != 2 {
+		t.Fatalf("expected the invalid link to be reported, got %+v", page.HyperLinks)
+	}
+
+	broken := page.BrokenLinks()
+	if len(broken) != 1 || broken[0].Raw != "http://[::1" || broken[0].Failure != parser.FailureInvalidURL {
+		t.Errorf("expected http://[::1 to be broken with %q, got %+v", parser.FailureInvalidURL, broken)
+	}
+}
@@ -0,0 +1,40 @@
+// Package scanopts has the settings a single scan, crawl or monitor run can change.
+package scanopts
+
+import (
+	"errors"
+	"github.com/hugmouse/scan24/internal/robots"
+	"net/url"
+)
+
+// ErrInvalidCallbackURL is returned by Validate for callback URLs that are not absolute HTTP/HTTPS URLs.
+var ErrInvalidCallbackURL = errors.New("callback URL must be an HTTP/HTTPS URL")
+
+// Options are the settings a single scan or crawl can change, monitors hand them to every run.
+type Options struct {
+	// BypassLinkCache checks every link again instead of reusing recent results.
+	BypassLinkCache bool `json:"bypass_link_cache"`
+	// Robots overrides the server's robots.txt mode.
+	Robots robots.Mode `json:"robots,omitempty"`
+	// CallbackURL receives the webhooks of the job.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// Validate checks options given by the user, errors are robots.ErrInvalidMode or ErrInvalidCallbackURL.
+func (o Options) Validate() error {
+	if o.Robots != "" {
+		_, err := robots.ParseMode(string(o.Robots))
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.CallbackURL != "" {
+		u, err := url.ParseRequestURI(o.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ErrInvalidCallbackURL
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package scanopts
+
+import (
+	"errors"
+	"github.com/hugmouse/scan24/internal/robots"
+	"testing"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		opts Options
+		want error
+	}{
+		{Options{}, nil},
+		{Options{BypassLinkCache: true, Robots: robots.ModeObey, CallbackURL: "https://example.com/hooks/scan24"}, nil},
+		{Options{Robots: "sometimes"}, robots.ErrInvalidMode},
+		{Options{CallbackURL: "ftp://example.com"}, ErrInvalidCallbackURL},
+		{Options{CallbackURL: "/hooks/scan24"}, ErrInvalidCallbackURL},
+	}
+
+	for _, tt := range tests {
+		if err := tt.opts.Validate(); !errors.Is(err, tt.want) {
+			t.Errorf("Validate(%+v): got %v want %v", tt.opts, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrInvalidID is returned by Save for job IDs that can't be used as file names.
+var ErrInvalidID = errors.New("invalid scan ID")
+
+const (
+	indexFile = "index.jsonl"
+	scansDir  = "scans"
+)
+
+// DiskStore keeps every job as a gzipped JSON file in a directory.
+//
+// The summaries of all jobs are kept in memory, they are loaded from an append-only
+// index file when the store is opened. The index is rewritten once most of it is
+// made of replaced or deleted entries.
+type DiskStore struct {
+	dir       string
+	retention Retention
+
+	mu    sync.RWMutex
+	index *os.File
+	// records is the number of lines in the index file
+	records int
+
+	byID map[string]Summary
+	// byTime and byURL hold job IDs ordered by their creation time, oldest first
+	byTime []string
+	byURL  map[string][]string
+}
+
+// record is a line of the index file.
+type record struct {
+	Op      string   `json:"op"`
+	Summary *Summary `json:"summary,omitempty"`
+	ID      string   `json:"id,omitempty"`
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// Open opens the store in dir, creating it if needed, and applies the retention policy.
+func Open(dir string, retention Retention) (*DiskStore, error) {
+	err := os.MkdirAll(filepath.Join(dir, scansDir), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("creating the history directory: %w", err)
+	}
+
+	s := &DiskStore{
+		dir:       dir,
+		retention: retention,
+		byID:      make(map[string]Summary),
+		byURL:     make(map[string][]string),
+	}
+
+	damaged, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	// A damaged index could not be appended to safely, replaced entries are just wasted space
+	if damaged || s.records > 2*len(s.byID)+100 {
+		err = s.compact()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.index, err = os.OpenFile(filepath.Join(dir, indexFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening the history index: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.applyRetention(time.Now())
+	if err != nil {
+		_ = s.index.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load replays the index file, it reports whether any line of it was unreadable.
+func (s *DiskStore) load() (damaged bool, err error) {
+	f, err := os.Open(filepath.Join(s.dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("opening the history index: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	for scanner.Scan() {
+		s.records++
+
+		var r record
+
+		err := json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			// Most likely the last line, cut short by a crash
+			log.Printf("[storage] Skipping damaged line %d of the history index: %v", s.records, err)
+
+			damaged = true
+
+			continue
+		}
+
+		switch {
+		case r.Op == opPut && r.Summary != nil:
+			s.put(*r.Summary)
+		case r.Op == opDelete:
+			s.remove(r.ID)
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return damaged, fmt.Errorf("reading the history index: %w", err)
+	}
+
+	return damaged, nil
+}
+
+// compact rewrites the index with the live summaries only, it's called before the index is opened for appending.
+func (s *DiskStore) compact() error {
+	path := filepath.Join(s.dir, indexFile)
+
+	tmp, err := os.CreateTemp(s.dir, indexFile+".*")
+	if err != nil {
+		return fmt.Errorf("compacting the history index: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+
+	for _, id := range s.byTime {
+		summary := s.byID[id]
+
+		err = enc.Encode(record{Op: opPut, Summary: &summary})
+		if err != nil {
+			_ = tmp.Close()
+
+			return fmt.Errorf("compacting the history index: %w", err)
+		}
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("compacting the history index: %w", err)
+	}
+
+	s.records = len(s.byTime)
+
+	return nil
+}
+
+// Save writes the job and adds it to the index, older scans may be dropped by the retention policy.
+func (s *DiskStore) Save(job jobs.Job) error {
+	if !validID(job.ID) {
+		return ErrInvalidID
+	}
+
+	err := s.writeJob(job)
+	if err != nil {
+		return err
+	}
+
+	summary := NewSummary(job)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.appendRecord(record{Op: opPut, Summary: &summary})
+	if err != nil {
+		return err
+	}
+
+	s.put(summary)
+
+	return s.applyRetention(time.Now())
+}
+
+// writeJob writes the job file through a temporary file, so that readers never see half of it.
+func (s *DiskStore) writeJob(job jobs.Job) error {
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, scansDir), job.ID+".*")
+	if err != nil {
+		return fmt.Errorf("saving scan %s: %w", job.ID, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+
+	err = json.NewEncoder(gz).Encode(job)
+	if err == nil {
+		err = gz.Close()
+	}
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.jobPath(job.ID))
+	}
+
+	if err != nil {
+		return fmt.Errorf("saving scan %s: %w", job.ID, err)
+	}
+
+	return nil
+}
+
+// Get loads a stored job.
+func (s *DiskStore) Get(id string) (jobs.Job, error) {
+	s.mu.RLock()
+	_, ok := s.byID[id]
+	s.mu.RUnlock()
+
+	if !ok {
+		return jobs.Job{}, ErrNotFound
+	}
+
+	f, err := os.Open(s.jobPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return jobs.Job{}, ErrNotFound
+	}
+
+	if err != nil {
+		return jobs.Job{}, fmt.Errorf("loading scan %s: %w", id, err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return jobs.Job{}, fmt.Errorf("loading scan %s: %w", id, err)
+	}
+
+	var job jobs.Job
+
+	err = json.NewDecoder(gz).Decode(&job)
+	if err != nil {
+		return jobs.Job{}, fmt.Errorf("loading scan %s: %w", id, err)
+	}
+
+	return job, nil
+}
+
+// List returns the summaries of the jobs matching q, newest first.
+func (s *DiskStore) List(q Query) ([]Summary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.byTime
+	if q.URL != "" {
+		ids = s.byURL[q.URL]
+	}
+
+	var list []Summary
+
+	for i := len(ids) - 1; i >= 0 && len(list) < q.limit(); i-- {
+		if summary := s.byID[ids[i]]; q.matches(summary) {
+			list = append(list, summary)
+		}
+	}
+
+	return list, nil
+}
+
+// Delete removes a job, deleting a job that is not stored is not an error.
+func (s *DiskStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.delete(id)
+}
+
+// Close closes the index file.
+func (s *DiskStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.index.Close()
+}
+
+// delete removes a job from the index and the disk, s.mu must be held.
+func (s *DiskStore) delete(id string) error {
+	if _, ok := s.byID[id]; !ok {
+		return nil
+	}
+
+	err := s.appendRecord(record{Op: opDelete, ID: id})
+	if err != nil {
+		return err
+	}
+
+	s.remove(id)
+
+	err = os.Remove(s.jobPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("deleting scan %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// applyRetention deletes the scans that the retention policy does not keep, s.mu must be held.
+func (s *DiskStore) applyRetention(now time.Time) error {
+	var expired []string
+
+	if s.retention.MaxAge > 0 {
+		cutoff := now.Add(-s.retention.MaxAge)
+
+		for _, id := range s.byTime {
+			if !s.byID[id].CreatedAt.Before(cutoff) {
+				break
+			}
+
+			expired = append(expired, id)
+		}
+	}
+
+	if s.retention.MaxPerURL > 0 {
+		for _, ids := range s.byURL {
+			kept := make(map[jobs.Kind]int)
+
+			for i := len(ids) - 1; i >= 0; i-- {
+				kind := s.byID[ids[i]].Kind
+
+				kept[kind]++
+				if kept[kind] > s.retention.MaxPerURL {
+					expired = append(expired, ids[i])
+				}
+			}
+		}
+	}
+
+	for _, id := range expired {
+		err := s.delete(id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *DiskStore) appendRecord(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.index.Write(append(line, '\n'))
+	if err == nil {
+		err = s.index.Sync()
+	}
+
+	if err != nil {
+		return fmt.Errorf("writing the history index: %w", err)
+	}
+
+	s.records++
+
+	return nil
+}
+
+// put adds or replaces a summary in the in-memory indexes.
+func (s *DiskStore) put(summary Summary) {
+	s.remove(summary.ID)
+	s.byID[summary.ID] = summary
+
+	s.byTime = s.insert(s.byTime, summary)
+	s.byURL[summary.URL] = s.insert(s.byURL[summary.URL], summary)
+}
+
+// insert adds the ID of summary to ids, keeping them ordered by creation time.
+func (s *DiskStore) insert(ids []string, summary Summary) []string {
+	i := sort.Search(len(ids), func(i int) bool {
+		return s.byID[ids[i]].CreatedAt.After(summary.CreatedAt)
+	})
+
+	return slices.Insert(ids, i, summary.ID)
+}
+
+// remove drops a summary from the in-memory indexes.
+func (s *DiskStore) remove(id string) {
+	summary, ok := s.byID[id]
+	if !ok {
+		return
+	}
+
+	delete(s.byID, id)
+
+	s.byTime = slices.DeleteFunc(s.byTime, func(other string) bool { return other == id })
+
+	byURL := slices.DeleteFunc(s.byURL[summary.URL], func(other string) bool { return other == id })
+	if len(byURL) == 0 {
+		delete(s.byURL, summary.URL)
+	} else {
+		s.byURL[summary.URL] = byURL
+	}
+}
+
+func (s *DiskStore) jobPath(id string) string {
+	return filepath.Join(s.dir, scansDir, id+".json.gz")
+}
+
+// validID reports whether id is safe to use as a file name, job IDs are hex strings.
+func validID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	for _, r := range id {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && r != '-' && r != '_' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"errors"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"time"
+)
+
+// ErrNotFound is returned for scans that are not in the store.
+var ErrNotFound = errors.New("scan not found")
+
+// Store keeps the results of finished jobs beyond the lifetime of the jobs.Manager.
+type Store interface {
+	// Save stores a finished job, saving a job with the same ID again replaces it.
+	Save(job jobs.Job) error
+	// Get returns the full job with the given ID.
+	Get(id string) (jobs.Job, error)
+	// List returns the summaries of the stored jobs matching q, newest first.
+	List(q Query) ([]Summary, error)
+	// Delete removes a job from the store.
+	Delete(id string) error
+	Close() error
+}
+
+// Summary is what the store knows about a job without loading it.
+type Summary struct {
+	ID          string     `json:"id"`
+	Kind        jobs.Kind  `json:"kind"`
+	URL         string     `json:"url"`
+	State       jobs.State `json:"state"`
+	Title       string     `json:"title,omitempty"`
+	Links       int        `json:"links"`
+	BrokenLinks int        `json:"broken_links"`
+	CreatedAt   time.Time  `json:"created_at"`
+	FinishedAt  time.Time  `json:"finished_at"`
+}
+
+// NewSummary summarizes job, crawls count the links and broken links of all their pages.
+func NewSummary(job jobs.Job) Summary {
+	s := Summary{
+		ID:          job.ID,
+		Kind:        job.Kind,
+		URL:         job.URL,
+		State:       job.State,
+		Title:       job.Page.Title,
+		Links:       len(job.Page.HyperLinks),
+		BrokenLinks: len(job.Page.BrokenLinks()),
+		CreatedAt:   job.CreatedAt,
+		FinishedAt:  job.FinishedAt,
+	}
+
+	if job.Site != nil {
+		s.Links = 0
+		s.BrokenLinks = len(job.Site.BrokenLinks)
+
+		for _, p := range job.Site.Pages {
+			if p.Page != nil {
+				s.Links += len(p.Page.HyperLinks)
+			}
+		}
+	}
+
+	return s
+}
+
+// Query selects stored jobs, zero values match everything.
+type Query struct {
+	URL   string
+	Kind  jobs.Kind
+	Since time.Time
+	Until time.Time
+	// Limit caps the number of results, DefaultLimit if zero.
+	Limit int
+}
+
+// DefaultLimit is the number of summaries List returns when Query.Limit is not set.
+const DefaultLimit = 100
+
+func (q Query) matches(s Summary) bool {
+	return (q.URL == "" || s.URL == q.URL) &&
+		(q.Kind == "" || s.Kind == q.Kind) &&
+		(q.Since.IsZero() || !s.CreatedAt.Before(q.Since)) &&
+		(q.Until.IsZero() || s.CreatedAt.Before(q.Until))
+}
+
+func (q Query) limit() int {
+	if q.Limit > 0 {
+		return q.Limit
+	}
+
+	return DefaultLimit
+}
+
+// Retention tells how long scans are kept, zero values keep them forever.
+type Retention struct {
+	MaxAge time.Duration
+	// MaxPerURL keeps only the newest scans of every URL and kind.
+	MaxPerURL int
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"errors"
+	"github.com/hugmouse/scan24/internal/jobs"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/scanner"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testJob(id, targetURL string, createdAt time.Time) jobs.Job {
+	return jobs.Job{
+		ID:    id,
+		Kind:  jobs.KindPage,
+		URL:   targetURL,
+		State: jobs.StateDone,
+		Page: scanner.PageData{
+			Title: "Title of " + id,
+			HyperLinks: []parser.HyperLink{
+				{Raw: "/ok", StatusCode: 200},
+				{Raw: "/missing", StatusCode: 404},
+			},
+		},
+		CreatedAt:  createdAt,
+		FinishedAt: createdAt.Add(time.Second),
+	}
+}
+
+func TestDiskStore_SaveGetList(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, Retention{})
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+
+	now := time.Now()
+
+	for i, id := range []string{"a1", "b2", "c3"} {
+		targetURL := "https://example.com"
+		if id == "b2" {
+			targetURL = "https://example.org"
+		}
+
+		err = s.Save(testJob(id, targetURL, now.Add(time.Duration(i)*time.Minute)))
+		if err != nil {
+			t.Fatalf("Save(%s) returned an error: %v", id, err)
+		}
+	}
+
+	job, err := s.Get("a1")
+	if err != nil {
+		t.Fatalf("Get returned an error: %v", err)
+	}
+
+	if job.Page.Title != "Title of a1" || len(job.Page.HyperLinks) != 2 {
+		t.Errorf("unexpected job: %+v", job)
+	}
+
+	_, err = s.Get("nope")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	list, _ := s.List(Query{URL: "https://example.com"})
+	if len(list) != 2 || list[0].ID != "c3" || list[1].ID != "a1" {
+		t.Fatalf("expected c3 and a1, newest first, got %+v", list)
+	}
+
+	if list[0].Links != 2 || list[0].BrokenLinks != 1 {
+		t.Errorf("unexpected summary: %+v", list[0])
+	}
+
+	list, _ = s.List(Query{Since: now.Add(30 * time.Second), Limit: 1})
+	if len(list) != 1 || list[0].ID != "c3" {
+		t.Errorf("expected only c3, got %+v", list)
+	}
+
+	list, _ = s.List(Query{Kind: jobs.KindCrawl})
+	if len(list) != 0 {
+		t.Errorf("expected no crawls, got %+v", list)
+	}
+
+	err = s.Save(testJob("../escape", "https://example.com", now))
+	if !errors.Is(err, ErrInvalidID) {
+		t.Errorf("expected ErrInvalidID, got %v", err)
+	}
+}
+
+func TestDiskStore_Reopen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, Retention{})
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+
+	now := time.Now()
+	_ = s.Save(testJob("a1", "https://example.com", now))
+	_ = s.Save(testJob("b2", "https://example.com", now.Add(time.Minute)))
+	_ = s.Delete("a1")
+	_ = s.Close()
+
+	// A crash in the middle of a write leaves half a line behind
+	f, err := os.OpenFile(filepath.Join(dir, indexFile), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = f.WriteString(`{"op":"put","summ`)
+	f.Close()
+
+	s, err = Open(dir, Retention{})
+	if err != nil {
+		t.Fatalf("reopening returned an error: %v", err)
+	}
+	defer s.Close()
+
+	list, _ := s.List(Query{})
+	if len(list) != 1 || list[0].ID != "b2" {
+		t.Fatalf("expected only b2 after reopening, got %+v", list)
+	}
+
+	_, err = s.Get("a1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the deleted scan to be gone, got %v", err)
+	}
+
+	// The damaged line must not break the records appended after it
+	err = s.Save(testJob("c3", "https://example.com", now.Add(2*time.Minute)))
+	if err != nil {
+		t.Fatalf("Save returned an error: %v", err)
+	}
+
+	_ = s.Close()
+
+	s, err = Open(dir, Retention{})
+	if err != nil {
+		t.Fatalf("reopening returned an error: %v", err)
+	}
+	defer s.Close()
+
+	list, _ = s.List(Query{})
+	if len(list) != 2 || list[0].ID != "c3" {
+		t.Errorf("expected c3 and b2, got %+v", list)
+	}
+}
+
+func TestDiskStore_Retention(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, Retention{MaxAge: time.Hour, MaxPerURL: 2})
+	if err != nil {
+		t.Fatalf("Open returned an error: %v", err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	_ = s.Save(testJob("old", "https://example.org", now.Add(-2*time.Hour)))
+
+	for i, id := range []string{"a1", "b2", "c3"} {
+		_ = s.Save(testJob(id, "https://example.com", now.Add(time.Duration(i)*time.Minute)))
+	}
+
+	list, _ := s.List(Query{})
+	if len(list) != 2 || list[0].ID != "c3" || list[1].ID != "b2" {
+		t.Fatalf("expected c3 and b2 to be kept, got %+v", list)
+	}
+
+	for _, id := range []string{"old", "a1"} {
+		_, err = os.Stat(s.jobPath(id))
+		if !os.IsNotExist(err) {
+			t.Errorf("expected the file of %s to be deleted, got %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hugmouse/scan24/internal/monitor"
+	"github.com/hugmouse/scan24/internal/parser"
+	"github.com/hugmouse/scan24/internal/storage"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event is what a webhook is sent for.
+type Event string
+
+const (
+	// EventJobCompleted is sent when a scan or crawl is done.
+	EventJobCompleted Event = "job.completed"
+	// EventJobFailed is sent when a scan or crawl failed, cancelled jobs send nothing.
+	EventJobFailed Event = "job.failed"
+	// EventMonitorAlert is sent when a monitor run raised alerts.
+	EventMonitorAlert Event = "monitor.alert"
+	// EventPing is only sent on request, to test a receiver.
+	EventPing Event = "ping"
+)
+
+// ErrInvalidEvent is returned for unknown events.
+var ErrInvalidEvent = errors.New("unknown webhook event")
+
+// ParseEvent validates an event given by the user.
+func ParseEvent(s string) (Event, error) {
+	switch e := Event(s); e {
+	case EventJobCompleted, EventJobFailed, EventMonitorAlert, EventPing:
+		return e, nil
+	}
+
+	return "", fmt.Errorf("%q: %w", s, ErrInvalidEvent)
+}
+
+// Header names of a delivery.
+const (
+	HeaderEvent     = "X-Scan24-Event"
+	HeaderDelivery  = "X-Scan24-Delivery"
+	HeaderTimestamp = "X-Scan24-Timestamp"
+	HeaderSignature = "X-Scan24-Signature"
+)
+
+// Sign returns the signature header of a request body sent at timestamp (in Unix seconds),
+// the hex-encoded HMAC-SHA256 of the timestamp, a dot and the body, keyed with secret.
+//
+// Including the timestamp lets receivers reject old requests that are sent again.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the one Sign returns for the body, in constant time.
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// Payload is the JSON body of a webhook.
+type Payload struct {
+	Event     Event     `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	// Job is the finished job, or the one of the monitor run that raised alerts.
+	Job   *storage.Summary `json:"job,omitempty"`
+	Error string           `json:"error,omitempty"`
+	// ResultURL links to the full result of Job.
+	ResultURL string          `json:"result_url,omitempty"`
+	Monitor   *Monitor        `json:"monitor,omitempty"`
+	Alerts    []monitor.Alert `json:"alerts,omitempty"`
+}
+
+// Monitor names the monitor of an alert.
+type Monitor struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	Schedule string `json:"schedule"`
+}
+
+// State is where a delivery is at.
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateDelivered State = "delivered"
+	StateFailed    State = "failed"
+)
+
+// Delivery is a payload sent to a single URL, with every attempt made so far.
+type Delivery struct {
+	ID    string `json:"id"`
+	Event Event  `json:"event"`
+	URL   string `json:"url"`
+	// JobID is the job of the payload, if it has one.
+	JobID string `json:"job_id,omitempty"`
+	// Signed is false for URLs that are not configured, like callback URLs of scans.
+	Signed   bool      `json:"signed"`
+	State    State     `json:"state"`
+	Attempts []Attempt `json:"attempts,omitempty"`
+	// NextAttempt is set while a failed attempt waits to be retried.
+	NextAttempt time.Time `json:"next_attempt,omitzero"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Attempt is a single request of a delivery, StatusCode is 0 if there was no response.
+type Attempt struct {
+	At         time.Time     `json:"at"`
+	StatusCode int           `json:"status_code,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Dispatcher sends payloads in the background and retries failed deliveries,
+// its exported fields have to be set before the first Send.
+type Dispatcher struct {
+	// URLs receive every event in Events.
+	URLs []string
+	// Events limits what is sent to URLs, all events if empty. Callback URLs get every event they are given.
+	Events []Event
+	// Secret signs the payloads sent to URLs, no signature is sent if it is empty.
+	// Callback URLs are never signed, as anyone could collect signed payloads with them.
+	Secret string
+	// MaxAttempts is how often a delivery is tried before it is given up.
+	MaxAttempts int
+	// MinBackoff is the pause after the first failed attempt, it doubles with every further one up to MaxBackoff.
+	// Retry-After of a 429 or 503 response is honored up to MaxBackoff as well.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// LogSize is the number of deliveries Deliveries returns.
+	LogSize int
+
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu sync.Mutex
+	// log holds the latest deliveries, oldest first
+	log []*Delivery
+}
+
+// New creates a Dispatcher that sends with client.
+//
+// Redirects are not followed by deliveries, client only needs a timeout and a transport.
+func New(client *http.Client) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := *client
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return &Dispatcher{
+		MaxAttempts: 5,
+		MinBackoff:  time.Second,
+		MaxBackoff:  5 * time.Minute,
+		LogSize:     200,
+		client:      &c,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Subscribed reports whether URLs receive event.
+func (d *Dispatcher) Subscribed(event Event) bool {
+	return len(d.URLs) > 0 && (len(d.Events) == 0 || slices.Contains(d.Events, event))
+}
+
+// Send delivers p to URLs if they subscribed to its event, and to every callback URL.
+// A URL given twice gets the payload once, callback URLs that are not one of URLs get it unsigned.
+//
+// It returns the new deliveries, they are made in the background.
+func (d *Dispatcher) Send(p Payload, callbacks ...string) []Delivery {
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now()
+	}
+
+	var targets []string
+
+	if d.Subscribed(p.Event) {
+		targets = append(targets, d.URLs...)
+	}
+
+	for _, u := range callbacks {
+		if u != "" && !slices.Contains(targets, u) {
+			targets = append(targets, u)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("[webhook] Error encoding %s payload: %v", p.Event, err)
+
+		return nil
+	}
+
+	var jobID string
+	if p.Job != nil {
+		jobID = p.Job.ID
+	}
+
+	deliveries := make([]Delivery, 0, len(targets))
+
+	for _, u := range targets {
+		dl := &Delivery{
+			ID:        newID(),
+			Event:     p.Event,
+			URL:       u,
+			JobID:     jobID,
+			Signed:    d.Secret != "" && slices.Contains(d.URLs, u),
+			State:     StatePending,
+			CreatedAt: p.CreatedAt,
+		}
+
+		d.mu.Lock()
+		d.log = append(d.log, dl)
+		if over := len(d.log) - max(d.LogSize, 1); over > 0 {
+			d.log = slices.Delete(d.log, 0, over)
+		}
+		deliveries = append(deliveries, dl.clone())
+		d.mu.Unlock()
+
+		d.wg.Add(1)
+
+		go func() {
+			defer d.wg.Done()
+
+			d.deliver(dl, body)
+		}()
+	}
+
+	return deliveries
+}
+
+// Deliveries returns the latest deliveries, newest first.
+func (d *Dispatcher) Deliveries() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	deliveries := make([]Delivery, len(d.log))
+	for i, dl := range d.log {
+		deliveries[len(d.log)-1-i] = dl.clone()
+	}
+
+	return deliveries
+}
+
+// Wait waits until every delivery made so far succeeded or was given up.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Close gives up the deliveries that wait for a retry and waits for the running attempts.
+func (d *Dispatcher) Close() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// deliver makes the attempts of a delivery until one succeeds, a response can't be retried or MaxAttempts are used up.
+func (d *Dispatcher) deliver(dl *Delivery, body []byte) {
+	for attempt := 1; ; attempt++ {
+		a, retryAfter, retry := d.attempt(dl, body)
+
+		d.mu.Lock()
+		dl.Attempts = append(dl.Attempts, a)
+		dl.NextAttempt = time.Time{}
+
+		switch {
+		case a.Error == "":
+			dl.State = StateDelivered
+		case !retry || attempt >= d.MaxAttempts:
+			dl.State = StateFailed
+		}
+
+		state := dl.State
+		d.mu.Unlock()
+
+		if state != StatePending {
+			if state == StateFailed {
+				log.Printf("[webhook] Giving up %s delivery %s to %s after %d attempts: %s", dl.Event, dl.ID, dl.URL, attempt, a.Error)
+			}
+
+			return
+		}
+
+		wait := d.backoff(attempt)
+		if retryAfter > 0 {
+			wait = min(retryAfter, d.MaxBackoff)
+		}
+
+		d.mu.Lock()
+		dl.NextAttempt = time.Now().Add(wait)
+		d.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-timer.C:
+		case <-d.ctx.Done():
+			timer.Stop()
+
+			d.mu.Lock()
+			dl.State = StateFailed
+			dl.NextAttempt = time.Time{}
+			d.mu.Unlock()
+
+			return
+		}
+	}
+}
+
+// backoff returns the pause after the given failed attempt, MinBackoff doubled for every attempt before it up to MaxBackoff.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	// Shifting more than that would overflow long before reaching any sensible cap
+	if attempt > 32 {
+		return d.MaxBackoff
+	}
+
+	return max(min(d.MinBackoff<<(attempt-1), d.MaxBackoff), 0)
+}
+
+// attempt sends a delivery once, retry reports whether a failure is worth another attempt.
+func (d *Dispatcher) attempt(dl *Delivery, body []byte) (a Attempt, retryAfter time.Duration, retry bool) {
+	a.At = time.Now()
+
+	defer func() {
+		a.Duration = time.Since(a.At)
+	}()
+
+	// Running attempts are not cut short by Close, the client timeout ends them
+	req, err := http.NewRequest(http.MethodPost, dl.URL, bytes.NewReader(body))
+	if err != nil {
+		a.Error = err.Error()
+
+		return a, 0, false
+	}
+
+	timestamp := strconv.FormatInt(a.At.Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", parser.UserAgent)
+	req.Header.Set(HeaderEvent, string(dl.Event))
+	req.Header.Set(HeaderDelivery, dl.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+
+	if dl.Signed {
+		req.Header.Set(HeaderSignature, Sign(d.Secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+
+		return a, 0, true
+	}
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	_ = resp.Body.Close()
+
+	a.StatusCode = resp.StatusCode
+
+	if resp.StatusCode/100 == 2 {
+		return a, 0, false
+	}
+
+	a.Error = "unexpected status " + resp.Status
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		retryAfter, _ = parser.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+
+		return a, retryAfter, true
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode/100 == 5:
+		return a, 0, true
+	}
+
+	return a, 0, false
+}
+
+func (dl *Delivery) clone() Delivery {
+	c := *dl
+	c.Attempts = slices.Clone(dl.Attempts)
+
+	return c
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,180 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/hugmouse/scan24/internal/storage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatcher_SignsAndRetries(t *testing.T) {
+	var (
+		calls    atomic.Int32
+		mu       sync.Mutex
+		received []Payload
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if !Verify("s3cret", r.Header.Get(HeaderTimestamp), body, r.Header.Get(HeaderSignature)) {
+			t.Errorf("Invalid signature %q", r.Header.Get(HeaderSignature))
+		}
+
+		if r.Header.Get(HeaderEvent) != string(EventJobCompleted) {
+			t.Errorf("Unexpected event header %q", r.Header.Get(HeaderEvent))
+		}
+
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		var p Payload
+
+		err := json.Unmarshal(body, &p)
+		if err != nil {
+			t.Errorf("Invalid payload: %v", err)
+		}
+
+		mu.Lock()
+		received = append(received, p)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	d := New(server.Client())
+	d.URLs = []string{server.URL}
+	d.Secret = "s3cret"
+	d.MinBackoff = 10 * time.Millisecond
+
+	d.Send(Payload{Event: EventJobCompleted, Job: &storage.Summary{ID: "job1", URL: "https://example.com"}, ResultURL: "/api/v1/scans/job1/result"})
+	d.Wait()
+	d.Close()
+
+	deliveries := d.Deliveries()
+	if len(deliveries) != 1 {
+		t.Fatalf("Expected 1 delivery, got %d", len(deliveries))
+	}
+
+	dl := deliveries[0]
+	if dl.State != StateDelivered || len(dl.Attempts) != 2 || dl.JobID != "job1" {
+		t.Fatalf("Expected a delivery of job1 after 2 attempts, got %+v", dl)
+	}
+
+	if dl.Attempts[0].StatusCode != http.StatusInternalServerError || dl.Attempts[1].StatusCode != http.StatusOK {
+		t.Errorf("Unexpected attempts %+v", dl.Attempts)
+	}
+
+	if len(received) != 1 || received[0].Job.ID != "job1" || received[0].ResultURL != "/api/v1/scans/job1/result" {
+		t.Errorf("Unexpected payloads %+v", received)
+	}
+}
+
+func TestDispatcher_CallbacksUnsigned(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		signatures = make(map[string]string)
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		signatures[r.URL.Path] = r.Header.Get(HeaderSignature)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	d := New(server.Client())
+	d.URLs = []string{server.URL + "/configured"}
+	d.Secret = "s3cret"
+
+	// Anyone can pass a callback URL, it must not get payloads signed with the secret
+	d.Send(Payload{Event: EventPing}, server.URL+"/callback", server.URL+"/configured")
+	d.Wait()
+	d.Close()
+
+	if signatures["/configured"] == "" {
+		t.Error("Expected the configured webhook to be signed")
+	}
+
+	if sig, ok := signatures["/callback"]; !ok || sig != "" {
+		t.Errorf("Expected the callback to be delivered unsigned, got %q (delivered=%v)", sig, ok)
+	}
+
+	for _, dl := range d.Deliveries() {
+		if dl.Signed != (dl.URL == server.URL+"/configured") {
+			t.Errorf("Unexpected signed=%v of %s", dl.Signed, dl.URL)
+		}
+	}
+}
+
+func TestDispatcher_GivesUp(t *testing.T) {
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			calls.Add(1)
+			w.WriteHeader(http.StatusGone)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	d := New(server.Client())
+	d.URLs = []string{server.URL + "/busy"}
+	d.Events = []Event{EventJobFailed}
+	d.MaxAttempts = 3
+	d.MinBackoff = time.Millisecond
+
+	if sent := d.Send(Payload{Event: EventJobCompleted}); len(sent) != 0 {
+		t.Errorf("Expected no delivery of an unsubscribed event, got %+v", sent)
+	}
+
+	// Callbacks get every event they are given
+	d.Send(Payload{Event: EventJobFailed}, server.URL+"/gone")
+	d.Wait()
+	d.Close()
+
+	deliveries := d.Deliveries()
+	if len(deliveries) != 2 {
+		t.Fatalf("Expected 2 deliveries, got %d", len(deliveries))
+	}
+
+	for _, dl := range deliveries {
+		want := 3
+		if dl.URL == server.URL+"/gone" {
+			want = 1
+		}
+
+		if dl.State != StateFailed || len(dl.Attempts) != want {
+			t.Errorf("Expected %s to fail after %d attempts, got %+v", dl.URL, want, dl)
+		}
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected a 410 to be tried once, got %d attempts", calls.Load())
+	}
+}
+
+func TestDispatcher_Backoff(t *testing.T) {
+	d := New(http.DefaultClient)
+	d.MinBackoff = time.Second
+	d.MaxBackoff = time.Minute
+
+	tests := map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 6: 32 * time.Second, 7: time.Minute, 35: time.Minute, 64: time.Minute, 1000: time.Minute}
+
+	for attempt, want := range tests {
+		if got := d.backoff(attempt); got != want {
+			t.Errorf("backoff(%d): got %s want %s", attempt, got, want)
+		}
+	}
+}
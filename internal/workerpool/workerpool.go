@@ -0,0 +1,82 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrClosed = errors.New("worker pool is closed")
+
+// Pool runs tasks on a fixed number of goroutines.
+//
+// Submit blocks until a worker is free, so callers get backpressure
+// instead of piling up goroutines.
+type Pool struct {
+	tasks chan func()
+	quit  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+// New starts a pool with the given number of workers, at least one.
+func New(workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &Pool{
+		tasks: make(chan func()),
+		quit:  make(chan struct{}),
+	}
+
+	p.wg.Add(workers)
+
+	for range workers {
+		go p.worker()
+	}
+
+	return p
+}
+
+func (p *Pool) worker() {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case task := <-p.tasks:
+			task()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Submit hands task to the next free worker.
+//
+// It returns ctx.Err() if ctx is done before a worker picks the task up,
+// in that case task is never run.
+func (p *Pool) Submit(ctx context.Context, task func()) error {
+	select {
+	case <-p.quit:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case p.tasks <- task:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.quit:
+		return ErrClosed
+	}
+}
+
+// Close stops the workers after they finish their current tasks.
+func (p *Pool) Close() {
+	p.once.Do(func() {
+		close(p.quit)
+		p.wg.Wait()
+	})
+}
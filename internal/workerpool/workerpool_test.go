@@ -0,0 +1,77 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool_BoundsConcurrency(t *testing.T) {
+	p := New(3)
+	defer p.Close()
+
+	var (
+		running atomic.Int64
+		peak    atomic.Int64
+		wg      sync.WaitGroup
+	)
+
+	for range 20 {
+		wg.Add(1)
+
+		err := p.Submit(context.Background(), func() {
+			defer wg.Done()
+
+			n := running.Add(1)
+			for {
+				old := peak.Load()
+				if n <= old || peak.CompareAndSwap(old, n) {
+					break
+				}
+			}
+
+			time.Sleep(time.Millisecond * 2)
+			running.Add(-1)
+		})
+		if err != nil {
+			t.Fatalf("Submit returned an error: %v", err)
+		}
+	}
+
+	wg.Wait()
+
+	if peak.Load() > 3 {
+		t.Errorf("expected at most 3 concurrent tasks, got %d", peak.Load())
+	}
+}
+
+func TestPool_SubmitCancelled(t *testing.T) {
+	p := New(1)
+	defer p.Close()
+
+	block := make(chan struct{})
+	_ = p.Submit(context.Background(), func() { <-block })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	err := p.Submit(ctx, func() { t.Error("task should not run") })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	close(block)
+}
+
+func TestPool_Closed(t *testing.T) {
+	p := New(1)
+	p.Close()
+
+	err := p.Submit(context.Background(), func() {})
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+#!/bin/sh
+# Vendors the official htmx SSE extension next to htmx itself, with its version in the file name,
+# and points the templates at it. Run it from the repository root.
+#
+# Usage: scripts/vendor-htmx-ext-sse.sh [VERSION [SOURCE]]
+#
+# SOURCE is a copy of sse.js of that release, e.g. package/dist/sse.js of `npm pack htmx-ext-sse@VERSION`,
+# for machines that can't reach unpkg.com. It is downloaded if omitted.
+set -eu
+
+VERSION="${1:-2.2.2}"
+SOURCE="${2:-}"
+TARGET="static/htmx-ext-sse-${VERSION}.js"
+
+if [ -n "${SOURCE}" ]; then
+    cp "${SOURCE}" "${TARGET}"
+else
+    curl -fsSL "https://unpkg.com/htmx-ext-sse@${VERSION}/sse.js" -o "${TARGET}"
+fi
+
+# The official extension registers itself as "sse", the minimal copy did so too
+if ! grep -q 'defineExtension("sse"\|defineExtension('"'"'sse'"'"'' "${TARGET}"; then
+    echo "${TARGET} does not look like the htmx SSE extension" >&2
+    rm "${TARGET}"
+    exit 1
+fi
+
+for tmpl in templates/*.gohtml; do
+    sed -i.bak -E "s|/static/htmx-ext-sse(-[0-9.]+)?\.js|/${TARGET}|g" "${tmpl}" && rm "${tmpl}.bak"
+done
+
+# Replaces the earlier minimal copy and older vendored versions
+for old in static/htmx-ext-sse*.js; do
+    if [ "${old}" != "${TARGET}" ]; then
+        git rm -q "${old}"
+    fi
+done
+
+git add "${TARGET}" templates
@@ -3,5 +3,11 @@
 (function() {
     const url = new URL(location);
     url.pathname = "/result";
+
+    const id = document.currentScript && document.currentScript.dataset.jobId;
+    if (id) {
+        url.search = "?id=" + encodeURIComponent(id);
+    }
+
     history.pushState({}, "", url);
-}())
\ No newline at end of file
+}())
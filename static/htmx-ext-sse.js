@@ -0,0 +1,88 @@
+/*
+ * Minimal Server-Sent Events extension for htmx 2.
+ *
+ * Supports the subset of the official sse extension attributes that Scan24 uses:
+ *
+ *   hx-ext="sse" sse-connect="/url"  opens an EventSource for the element
+ *   sse-swap="name"                  swaps the data of every "name" event into the element
+ *   hx-trigger="sse:name"            triggers a request when a "name" event arrives
+ *   sse-close="name"                 closes the EventSource once a "name" event arrives
+ */
+(function () {
+    var api;
+
+    function connect(elt) {
+        var url = api.getAttributeValue(elt, "sse-connect");
+        if (!url) {
+            return;
+        }
+
+        var source = new EventSource(url);
+        api.getInternalData(elt).sseEventSource = source;
+
+        elt.querySelectorAll("[sse-swap]").forEach(function (child) {
+            api.getAttributeValue(child, "sse-swap").split(",").forEach(function (name) {
+                source.addEventListener(name.trim(), function (e) {
+                    if (!api.bodyContains(child)) {
+                        return;
+                    }
+
+                    api.swap(api.getTarget(child), e.data, api.getSwapSpecification(child));
+                });
+            });
+        });
+
+        elt.querySelectorAll("[hx-trigger]").forEach(function (child) {
+            var trigger = api.getAttributeValue(child, "hx-trigger");
+            trigger.split(",").forEach(function (spec) {
+                spec = spec.trim();
+                if (spec.indexOf("sse:") !== 0) {
+                    return;
+                }
+
+                var name = spec.slice(4).split(" ")[0];
+                source.addEventListener(name, function () {
+                    api.triggerEvent(child, "sse:" + name);
+                });
+            });
+        });
+
+        var closeOn = api.getAttributeValue(elt, "sse-close");
+        if (closeOn) {
+            source.addEventListener(closeOn, function () {
+                source.close();
+            });
+        }
+    }
+
+    function disconnect(elt) {
+        var data = api.getInternalData(elt);
+        if (data.sseEventSource) {
+            data.sseEventSource.close();
+            data.sseEventSource = null;
+        }
+    }
+
+    htmx.defineExtension("sse", {
+        init: function (apiRef) {
+            api = apiRef;
+        },
+        onEvent: function (name, evt) {
+            var elt = evt.target || evt.detail.elt;
+
+            if (!elt || !elt.hasAttribute || !elt.hasAttribute("sse-connect")) {
+                return;
+            }
+
+            switch (name) {
+                case "htmx:afterProcessNode":
+                    disconnect(elt);
+                    connect(elt);
+                    break;
+                case "htmx:beforeCleanupElement":
+                    disconnect(elt);
+                    break;
+            }
+        }
+    });
+})();
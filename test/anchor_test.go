@@ -0,0 +1,110 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/hugmouse/scan24/internal/parser"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHasAnchor(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<h2 id="pricing">Pricing</h2><a name="legacy"></a><span name="span"></span><p id="Ünïcode">`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fragment string
+		want     bool
+	}{
+		{fragment: "pricing", want: true},
+		{fragment: "Pricing", want: false},
+		{fragment: "legacy", want: true},
+		{fragment: "span", want: false},
+		{fragment: "Ünïcode", want: true},
+		{fragment: "missing", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fragment, func(t *testing.T) {
+			if got := parser.HasAnchor(doc, tt.fragment); got != tt.want {
+				t.Errorf("HasAnchor(%q) = %v, want %v", tt.fragment, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecker_Anchors(t *testing.T) {
+	var requests atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		switch r.URL.Path {
+		case "/docs":
+			_, _ = fmt.Fprintln(w, `<!DOCTYPE html><html><body><h2 id="install">Install</h2></body></html>`)
+		case "/moved":
+			http.Redirect(w, r, "/docs", http.StatusMovedPermanently)
+		case "/file.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+		}
+	}))
+	defer server.Close()
+
+	page := mustParse(t, server.URL+"/")
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<section id="pricing"></section>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checker := &parser.Checker{Client: server.Client(), Document: doc, DocumentURL: page}
+
+	tests := []struct {
+		href    string
+		missing bool
+	}{
+		{href: "#pricing"},
+		{href: "#features", missing: true},
+		{href: "#"},
+		{href: "#top"},
+		{href: "#:~:text=pricing"},
+		{href: "#!/route", missing: true},
+		{href: "/docs#install"},
+		{href: "/docs#uninstall", missing: true},
+		{href: "/moved#install"},
+		{href: "/file.pdf#page=2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.href, func(t *testing.T) {
+			link := checker.Check(context.Background(), tt.href, page)
+
+			if tt.missing != (link.Failure == parser.FailureMissingAnchor) {
+				t.Fatalf("unexpected failure %q (err %v)", link.Failure, link.Err)
+			}
+
+			if tt.missing && (!link.Broken() || !errors.Is(link.Err, parser.ErrMissingAnchor) || link.StatusCode != http.StatusOK) {
+				t.Errorf("expected a broken link with status 200 and ErrMissingAnchor: %+v", link)
+			}
+		})
+	}
+
+	// Same-document anchors are looked up in the page that was already fetched,
+	// fragments that don't need an anchor are checked like any other link
+	if n := requests.Load(); n != 8 {
+		t.Errorf("expected 8 requests, got %d", n)
+	}
+
+	checker.SkipSPAFragments = true
+
+	if link := checker.Check(context.Background(), "#!/route", page); link.Failure != "" {
+		t.Errorf("expected SPA routes to be skipped, got %q", link.Failure)
+	}
+}
@@ -0,0 +1,136 @@
+package test
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/hugmouse/scan24/internal/parser"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	const page = `<!DOCTYPE html>
+<html>
+<head>
+	<link rel="stylesheet" href="/main.css">
+	<link rel="icon" href="/favicon.ico">
+	<link rel="preconnect" href="https://fonts.example.com">
+	<script src="/app.js"></script>
+	<script>console.log("inline")</script>
+	<style>body { background: url("/bg.png") } .logo { background-image: url(logo.svg) } .x { fill: url(#gradient) }</style>
+</head>
+<body>
+	<a href="">Empty</a>
+	<a href="/about">About</a>
+	<img src="/a.png" srcset="/a-1x.png 1x, /a,2x.png 2x">
+	<img src="data:image/png;base64,AAAA">
+	<picture><source srcset="/b.webp"></picture>
+	<video src="/v.mp4" poster="/poster.jpg"><track src="/subs.vtt"></video>
+	<iframe src="https://embed.example.com/"></iframe>
+	<map><area href="/region"></map>
+	<form action="/search"><input type="image" src="/go.png"><input type="text" src="/ignored"></form>
+	<div style="background: url('/hero.jpg')"></div>
+</body>
+</html>`
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []parser.LinkRef{
+		{URL: "/main.css", Element: "link", Attr: "href"},
+		{URL: "/favicon.ico", Element: "link", Attr: "href"},
+		{URL: "/app.js", Element: "script", Attr: "src"},
+		{URL: "/bg.png", Element: "style", Attr: "style"},
+		{URL: "logo.svg", Element: "style", Attr: "style"},
+		{URL: "", Element: "a", Attr: "href"},
+		{URL: "/about", Element: "a", Attr: "href"},
+		{URL: "/a.png", Element: "img", Attr: "src"},
+		{URL: "/a-1x.png", Element: "img", Attr: "srcset"},
+		{URL: "/a,2x.png", Element: "img", Attr: "srcset"},
+		{URL: "/b.webp", Element: "source", Attr: "srcset"},
+		{URL: "/v.mp4", Element: "video", Attr: "src"},
+		{URL: "/poster.jpg", Element: "video", Attr: "poster"},
+		{URL: "/subs.vtt", Element: "track", Attr: "src"},
+		{URL: "https://embed.example.com/", Element: "iframe", Attr: "src"},
+		{URL: "/region", Element: "area", Attr: "href"},
+		{URL: "/search", Element: "form", Attr: "action"},
+		{URL: "/go.png", Element: "input", Attr: "src"},
+		{URL: "/hero.jpg", Element: "div", Attr: "style"},
+	}
+
+	got := parser.ExtractLinks(doc)
+
+	locations := make([]parser.LinkRef, len(got))
+	for i, ref := range got {
+		if ref.Position != i+1 {
+			t.Errorf("%q: expected position %d, got %d", ref.URL, i+1, ref.Position)
+		}
+
+		locations[i] = parser.LinkRef{URL: ref.URL, Element: ref.Element, Attr: ref.Attr}
+	}
+
+	if !reflect.DeepEqual(locations, want) {
+		t.Fatalf("ExtractLinks() =\n%+v\nwant\n%+v", locations, want)
+	}
+
+	about := got[6]
+	if about.Text != "About" || about.Path != "html > body > a:nth-of-type(2)" {
+		t.Errorf("unexpected text or path of the second anchor: %+v", about)
+	}
+
+	if track := got[13]; track.Path != "html > body > video > track" {
+		t.Errorf("unexpected path of the track: %+v", track)
+	}
+}
+
+func TestExtractLinks_Text(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<a href="/a">  Read
+		<b>more</b> </a><a href="/b" aria-label="Home"><svg></svg></a><a href="/c"><img src="/logo.png" alt="Logo"></a>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var texts []string
+	for _, ref := range parser.ExtractLinks(doc) {
+		texts = append(texts, ref.Text)
+	}
+
+	want := []string{"Read more", "Home", "Logo", "Logo"}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("unexpected link texts: got %q want %q", texts, want)
+	}
+}
+
+func TestParseSrcset(t *testing.T) {
+	tests := []struct {
+		srcset string
+		want   []string
+	}{
+		{srcset: "", want: nil},
+		{srcset: "/a.png", want: []string{"/a.png"}},
+		{srcset: "/a.png 1x, /b.png 2x", want: []string{"/a.png", "/b.png"}},
+		{srcset: " /a.png 480w,\n/b.png  800w ", want: []string{"/a.png", "/b.png"}},
+		{srcset: "/a.png,/b.png 2x", want: []string{"/a.png,/b.png"}}, // URLs may contain commas
+		{srcset: "/img?w=1,2 1x, /c.png", want: []string{"/img?w=1,2", "/c.png"}},
+		{srcset: "/a.png 1x (weird, descriptor), /b.png", want: []string{"/a.png", "/b.png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.srcset, func(t *testing.T) {
+			if got := parser.ParseSrcset(tt.srcset); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSrcset(%q) = %q, want %q", tt.srcset, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSSURLs(t *testing.T) {
+	css := `@font-face { src: URL( "/f.woff2" ) format("woff2"), url('/f.woff') } a { background: url(/a.png) no-repeat }`
+
+	want := []string{"/f.woff2", "/f.woff", "/a.png"}
+	if got := parser.CSSURLs(css); !reflect.DeepEqual(got, want) {
+		t.Errorf("CSSURLs() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,182 @@
+package test
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"github.com/hugmouse/scan24/internal/parser"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAnalyze_FailureKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialErr error
+		want    parser.FailureKind
+	}{
+		{name: "NXDOMAIN", dialErr: &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, want: parser.FailureDNSNotFound},
+		{name: "DNS server failure", dialErr: &net.DNSError{Err: "server misbehaving", Name: "example.com"}, want: parser.FailureDNS},
+		{name: "Connection refused", dialErr: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, want: parser.FailureConnectionRefused},
+		{name: "Connection reset", dialErr: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, want: parser.FailureConnectionReset},
+		{name: "Dial timeout", dialErr: &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}}, want: parser.FailureTimeoutDial},
+		{name: "Expired certificate", dialErr: x509.CertificateInvalidError{Reason: x509.Expired}, want: parser.FailureCertExpired},
+		{name: "Hostname mismatch", dialErr: x509.HostnameError{Certificate: &x509.Certificate{}, Host: "example.com"}, want: parser.FailureCertHostname},
+		{name: "Unknown authority", dialErr: x509.UnknownAuthorityError{}, want: parser.FailureCertUnknownAuthority},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+						return nil, tt.dialErr
+					},
+				},
+			}
+
+			link := parser.Analyze(context.Background(), "http://example.com/", nil, client)
+
+			if link.Failure != tt.want || link.StatusCode != 0 || !link.Broken() {
+				t.Errorf("Analyze() = %+v, want failure %q", link, tt.want)
+			}
+
+			if !errors.Is(link.Err, tt.want) {
+				t.Errorf("expected errors.Is(%v, %q)", link.Err, tt.want)
+			}
+
+			var linkErr *parser.LinkError
+			if !errors.As(link.Err, &linkErr) || linkErr.Kind != tt.want {
+				t.Errorf("expected a *parser.LinkError, got %T", link.Err)
+			}
+		})
+	}
+}
+
+func TestAnalyze_TooManyRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Path+"x", http.StatusFound)
+	}))
+	defer server.Close()
+
+	client := server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return http.ErrUseLastResponse
+		}
+
+		return nil
+	}
+
+	link := parser.Analyze(context.Background(), server.URL, nil, client)
+
+	if link.Failure != parser.FailureTooManyRedirects || link.StatusCode != http.StatusFound || !link.Broken() {
+		t.Errorf("unexpected link: %+v", link)
+	}
+
+	if !errors.Is(link.Err, parser.ErrTooManyRedirects) {
+		t.Errorf("expected the error to wrap ErrTooManyRedirects: %v", link.Err)
+	}
+}
+
+func TestAnalyze_HeaderTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{ResponseHeaderTimeout: 20 * time.Millisecond},
+	}
+
+	link := parser.Analyze(context.Background(), server.URL, nil, client)
+
+	if link.Failure != parser.FailureTimeoutHeaders || !link.Failure.IsTimeout() {
+		t.Errorf("unexpected link: %+v", link)
+	}
+}
+
+func TestAnalyze_ClientTimeout(t *testing.T) {
+	// Accepts connections but never answers, so TLS handshakes hang
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen returned an error: %v", err)
+	}
+	defer silent.Close()
+
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+		}
+	}()
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	tests := []struct {
+		name      string
+		url       string
+		transport *http.Transport
+		want      parser.FailureKind
+	}{
+		{
+			name: "Dial",
+			url:  "http://example.com/",
+			transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					<-ctx.Done()
+
+					return nil, ctx.Err()
+				},
+			},
+			want: parser.FailureTimeoutDial,
+		},
+		{name: "TLS handshake", url: "https://" + silent.Addr().String() + "/", transport: &http.Transport{}, want: parser.FailureTimeoutTLS},
+		{name: "Headers", url: slow.URL, transport: &http.Transport{}, want: parser.FailureTimeoutHeaders},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Client.Timeout reports "awaiting headers" whatever the request was doing
+			client := &http.Client{Transport: tt.transport, Timeout: 100 * time.Millisecond}
+
+			link := parser.Analyze(context.Background(), tt.url, nil, client)
+
+			if link.Failure != tt.want || !errors.Is(link.Err, tt.want) {
+				t.Errorf("got failure %q want %q (err: %v)", link.Failure, tt.want, link.Err)
+			}
+		})
+	}
+}
+
+func TestAnalyze_UnsupportedScheme(t *testing.T) {
+	base, _ := url.Parse("https://example.com/")
+
+	link := parser.Analyze(context.Background(), "mailto:me@example.com", base, http.DefaultClient)
+
+	if link.Failure != parser.FailureUnsupportedScheme || link.Broken() {
+		t.Errorf("unexpected link: %+v", link)
+	}
+
+	if !errors.Is(link.Err, parser.ErrUnsupportedScheme) {
+		t.Errorf("expected the error to wrap ErrUnsupportedScheme: %v", link.Err)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
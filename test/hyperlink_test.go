@@ -2,6 +2,7 @@ package test
 
 import (
 	"github.com/hugmouse/scan24/internal/parser"
+	"net/url"
 	"testing"
 )
 
@@ -36,3 +37,47 @@ func TestClassify(t *testing.T) {
 		})
 	}
 }
+
+func TestClassifyURL(t *testing.T) {
+	base := mustParse(t, "https://www.example.co.uk/blog/")
+
+	tests := []struct {
+		name string
+		href string
+		want parser.HrefType
+	}{
+		{name: "Same-origin (relative)", href: "post", want: parser.SameOrigin},
+		{name: "Same-origin (absolute)", href: "https://www.example.co.uk/about", want: parser.SameOrigin},
+		{name: "Same-origin (default port)", href: "https://www.example.co.uk:443/", want: parser.SameOrigin},
+		{name: "Same-site (other scheme)", href: "http://www.example.co.uk/", want: parser.SameSite},
+		{name: "Same-site (other port)", href: "https://www.example.co.uk:8443/", want: parser.SameSite},
+		{name: "Same-site (sibling host)", href: "https://shop.example.co.uk/", want: parser.SameSite},
+		{name: "Same-site (parent host)", href: "https://example.co.uk/", want: parser.SameSite},
+		{name: "Subdomain", href: "https://static.www.example.co.uk/app.js", want: parser.Subdomain},
+		{name: "External (protocol-relative)", href: "//cdn.example.com/x", want: parser.External},
+		{name: "External (other registrable domain)", href: "https://other.co.uk/", want: parser.External},
+		{name: "Protocol (mailto)", href: "mailto:me@example.co.uk", want: parser.Protocol},
+		{name: "Protocol (ftp)", href: "ftp://www.example.co.uk/file", want: parser.Protocol},
+		{name: "Protocol (javascript)", href: "javascript:void(0)", want: parser.Protocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := base.ResolveReference(mustParse(t, tt.href))
+
+			if got := parser.ClassifyURL(link, base); got != tt.want {
+				t.Errorf("ClassifyURL(%s) = %v, want %v", link, got, tt.want)
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("could not parse %q: %v", rawURL, err)
+	}
+
+	return u
+}
@@ -0,0 +1,33 @@
+package test
+
+import (
+	"github.com/hugmouse/scan24/internal/parser"
+	"testing"
+)
+
+func TestLinkKey(t *testing.T) {
+	base := mustParse(t, "https://example.com/blog/")
+
+	tests := []struct {
+		href string
+		want string
+	}{
+		{href: "post", want: "https://example.com/blog/post"},
+		{href: "/blog/post", want: "https://example.com/blog/post"},
+		{href: "HTTPS://Example.COM:443/blog/post", want: "https://example.com/blog/post"},
+		{href: "http://example.com:80", want: "http://example.com/"},
+		{href: "http://example.com:8080", want: "http://example.com:8080/"},
+		{href: "/blog/post?page=2", want: "https://example.com/blog/post?page=2"},
+		{href: "/blog/post#comments", want: "https://example.com/blog/post#comments"},
+		{href: "mailto:Me@example.com", want: "mailto:Me@example.com"},
+		{href: "%zz", want: "%zz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.href, func(t *testing.T) {
+			if got := parser.LinkKey(tt.href, base); got != tt.want {
+				t.Errorf("LinkKey(%q) = %q, want %q", tt.href, got, tt.want)
+			}
+		})
+	}
+}
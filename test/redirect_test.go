@@ -0,0 +1,91 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"github.com/hugmouse/scan24/internal/parser"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestChecker_RedirectChain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/older", http.StatusMovedPermanently)
+		case "/older":
+			http.Redirect(w, r, "/new", http.StatusPermanentRedirect)
+		case "/temporary":
+			http.Redirect(w, r, "/new", http.StatusFound)
+		case "/loop-a":
+			http.Redirect(w, r, "/loop-b", http.StatusFound)
+		case "/loop-b":
+			http.Redirect(w, r, "/loop-a", http.StatusFound)
+		case "/new":
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	checker := &parser.Checker{Client: server.Client(), LongRedirectChain: 1}
+
+	t.Run("Permanent chain", func(t *testing.T) {
+		link := checker.Check(context.Background(), server.URL+"/old", nil)
+
+		if link.StatusCode != http.StatusOK || link.FinalURL != server.URL+"/new" {
+			t.Fatalf("unexpected link: %+v", link)
+		}
+
+		want := []parser.RedirectHop{
+			{URL: server.URL + "/old", StatusCode: http.StatusMovedPermanently, Location: "/older"},
+			{URL: server.URL + "/older", StatusCode: http.StatusPermanentRedirect, Location: "/new"},
+		}
+		if !slices.Equal(link.Redirects, want) {
+			t.Errorf("unexpected redirects: got %+v want %+v", link.Redirects, want)
+		}
+
+		if !slices.Equal(link.RedirectIssues, []parser.RedirectIssue{parser.RedirectLongChain, parser.RedirectUpdatable}) {
+			t.Errorf("unexpected issues: %v", link.RedirectIssues)
+		}
+	})
+
+	t.Run("Temporary redirect", func(t *testing.T) {
+		link := checker.Check(context.Background(), server.URL+"/temporary", nil)
+
+		if len(link.Redirects) != 1 || len(link.RedirectIssues) != 0 {
+			t.Errorf("unexpected link: %+v", link)
+		}
+	})
+
+	t.Run("Loop", func(t *testing.T) {
+		link := checker.Check(context.Background(), server.URL+"/loop-a", nil)
+
+		if link.Failure != parser.FailureRedirectLoop || !errors.Is(link.Err, parser.ErrRedirectLoop) || !link.Broken() {
+			t.Errorf("unexpected link: %+v", link)
+		}
+
+		if !slices.Contains(link.RedirectIssues, parser.RedirectLoop) {
+			t.Errorf("expected the loop to be flagged: %v", link.RedirectIssues)
+		}
+	})
+}
+
+func TestChecker_HTTPSDowngrade(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer plain.Close()
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL, http.StatusFound)
+	}))
+	defer secure.Close()
+
+	link := (&parser.Checker{Client: secure.Client()}).Check(context.Background(), secure.URL, nil)
+
+	if !slices.Contains(link.RedirectIssues, parser.RedirectDowngrade) {
+		t.Errorf("expected the downgrade to be flagged: %+v", link)
+	}
+}
@@ -0,0 +1,52 @@
+package test
+
+import (
+	"github.com/hugmouse/scan24/internal/parser"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "120", want: 2 * time.Minute, wantOK: true},
+		{value: " 0 ", want: 0, wantOK: true},
+		{value: "-5", wantOK: false},
+		{value: "99999999", want: 24 * time.Hour, wantOK: true},
+		{value: "Sun, 01 Jun 2025 12:00:30 GMT", want: 30 * time.Second, wantOK: true},
+		{value: "Sunday, 01-Jun-25 12:01:00 GMT", want: time.Minute, wantOK: true},
+		{value: "Sun, 01 Jun 2025 11:00:00 GMT", want: 0, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := parser.ParseRetryAfter(tt.value, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ParseRetryAfter(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestChecker_RetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	link := parser.Analyze(t.Context(), server.URL, nil, server.Client())
+
+	if link.StatusCode != http.StatusTooManyRequests || link.RetryAfter != 7*time.Second {
+		t.Errorf("expected a 429 with Retry-After of 7s, got %d and %s", link.StatusCode, link.RetryAfter)
+	}
+}
@@ -0,0 +1,28 @@
+package test
+
+import (
+	"context"
+	"github.com/hugmouse/scan24/internal/parser"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecker_Timing(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	link := parser.Analyze(context.Background(), server.URL, nil, server.Client())
+
+	timing := link.Timing
+	if timing.Connect <= 0 || timing.TLSHandshake <= 0 {
+		t.Errorf("expected connect and TLS handshake to be timed: %+v", timing)
+	}
+
+	if timing.TTFB < 20*time.Millisecond || timing.Total < timing.TTFB {
+		t.Errorf("unexpected time to first byte: %+v", timing)
+	}
+}